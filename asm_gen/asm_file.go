@@ -0,0 +1,61 @@
+package asm_gen
+
+import (
+	"fmt"
+	"he++/tac"
+	"io"
+)
+
+// the function execution of a program begins at
+var ENTRY_FUNC = "principal"
+
+func (ag *AsmGen) HasEntry() bool {
+	_, ok := ag.tacHandler.TacBlocks[ENTRY_FUNC]
+	return ok
+}
+
+// writes a complete GNU as source file for the program
+func (ag *AsmGen) WriteAsmFile(w io.Writer) {
+	fmt.Fprintln(w, ".intel_syntax noprefix")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, ".text")
+	if ag.HasEntry() {
+		fmt.Fprintln(w, ".globl _start")
+		fmt.Fprintln(w, "_start:")
+		fmt.Fprintf(w, "\t%s %s\n", CALL, ENTRY_FUNC)
+		// exit(<value returned by the entry function>)
+		if ag.tacHandler.TacBlocks[ENTRY_FUNC].ReturnCategory() == tac.VOID {
+			fmt.Fprintf(w, "\t%s edi, edi\n", XOR)
+		} else {
+			fmt.Fprintf(w, "\t%s edi, eax\n", MOV)
+		}
+		fmt.Fprintf(w, "\t%s eax, 60\n", MOV)
+		fmt.Fprintln(w, "\tsyscall")
+	}
+
+	for _, fasm := range ag.functions {
+		name := fasm.ftac.Name()
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, ".globl %s\n", name)
+		fmt.Fprintf(w, ".type %s, @function\n", name)
+		fmt.Fprintf(w, "%s:\n", name)
+		for i := range fasm.instrs {
+			fmt.Fprintln(w, fasm.instrs[i])
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, ".section .rodata")
+	for _, fasm := range ag.functions {
+		for _, c := range fasm.floatConsts {
+			directive := ".quad"
+			if c.size == 4 {
+				directive = ".long"
+			}
+			fmt.Fprintf(w, "%s:\n\t%s %#x\n", c.label, directive, c.bits)
+		}
+	}
+
+	// the stack needn't be executable
+	fmt.Fprintln(w, `.section .note.GNU-stack,"",@progbits`)
+}
@@ -0,0 +1,81 @@
+package asm_gen_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"he++/asm_gen"
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/difftest"
+	"he++/tac"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// every fixture is built at every -O level with every register allocator,
+// and has to exit with what its first line says it returns
+func TestFixtures(t *testing.T) {
+	if !difftest.HasToolchain() {
+		t.Skip("no toolchain to build with")
+	}
+	paths, _ := filepath.Glob("testdata/*.lg")
+	if len(paths) == 0 {
+		t.Fatal("no fixtures")
+	}
+	returns := regexp.MustCompile(`^// returns (\d+)\n`)
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := returns.FindSubmatch(src)
+		if m == nil {
+			t.Fatalf("%s: doesn't say what it returns", path)
+		}
+		want, _ := strconv.Atoi(string(m[1]))
+		for level, passes := range tac.OptLevels {
+			for _, regAlloc := range asm_gen.RegAllocators {
+				name := fmt.Sprintf("%s at -O%d, %s allocator", filepath.Base(path), level, regAlloc)
+				t.Run(name, func(t *testing.T) {
+					res, diags := compiler.Compile(string(src), compiler.Options{RegAlloc: regAlloc, Passes: passes})
+					if diagnostics.HasErrors(diags) {
+						t.Fatalf("unexpected diagnostics %v", diags)
+					}
+					dir := t.TempDir()
+					bin := filepath.Join(dir, "prog")
+					if err := asm_gen.BuildExecutable(res.Asm, dir, bin); err != nil {
+						t.Fatal(err)
+					}
+					if got := exitStatus(t, bin); got != want {
+						t.Errorf("expected exit status %d, got %d", want, got)
+					}
+				})
+			}
+		}
+	}
+}
+
+func exitStatus(t *testing.T, bin string) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := exec.CommandContext(ctx, bin).Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		t.Fatal("ran into the timeout")
+	case errors.As(err, &exitErr) && exitErr.ExitCode() < 0:
+		t.Fatalf("killed: %v", exitErr)
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return 0
+}
@@ -19,8 +19,10 @@ func (ins x86_64Instr) String() string {
 	if len(ins.labels) > 0 {
 		sb.WriteString(":\n")
 	}
-	sb.WriteString(fmt.Sprintf("%s %s", ins.instrName, strings.Join(ins.params, ", ")))
-	return sb.String()
+	if ins.instrName != "" {
+		sb.WriteString(fmt.Sprintf("\t%s %s", ins.instrName, strings.Join(ins.params, ", ")))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
 }
 
 const (
@@ -30,9 +32,40 @@ const (
 	SUB  = "sub"
 	IMUL = "imul"
 	IDIV = "idiv"
+	CDQ  = "cdq"
+	CQO  = "cqo"
 	NEG  = "neg"
 	CMP  = "cmp"
 
+	// sign extending from 32 bits, and from 8 or 16
+	MOVSXD = "movsxd"
+	MOVSX  = "movsx"
+
+	MOVQ   = "movq"
+	MOVD   = "movd"
+	MOVSS  = "movss"
+	MOVSD  = "movsd"
+	MOVAPS = "movaps"
+
+	ADDSS   = "addss"
+	ADDSD   = "addsd"
+	SUBSS   = "subss"
+	SUBSD   = "subsd"
+	MULSS   = "mulss"
+	MULSD   = "mulsd"
+	DIVSS   = "divss"
+	DIVSD   = "divsd"
+	XORPS   = "xorps"
+	UCOMISS = "ucomiss"
+	UCOMISD = "ucomisd"
+
+	CVTSI2SS  = "cvtsi2ss"
+	CVTSI2SD  = "cvtsi2sd"
+	CVTTSS2SI = "cvttss2si"
+	CVTTSD2SI = "cvttsd2si"
+	CVTSS2SD  = "cvtss2sd"
+	CVTSD2SS  = "cvtsd2ss"
+
 	JMP = "jmp"
 	JE  = "je"
 	JNE = "jne"
@@ -40,15 +73,25 @@ const (
 	JLE = "jle"
 	JG  = "jg"
 	JGE = "jge"
+	// unsigned, for the flags ucomiss and ucomisd set
+	JB  = "jb"
+	JBE = "jbe"
+	JA  = "ja"
+	JAE = "jae"
+	// set by ucomiss and ucomisd when an operand is NaN
+	JP = "jp"
 
 	AND = "and"
 	OR  = "or"
 	XOR = "xor"
 	SHL = "shl"
 	SHR = "shr"
+	SAR = "sar"
 
 	CALL = "call"
 	RET  = "ret"
+	PUSH = "push"
+	POP  = "pop"
 )
 
 var compOpsName = map[string]string{
@@ -60,6 +103,15 @@ var compOpsName = map[string]string{
 	lexer.NEQ:     JNE,
 }
 
+var floatCompOpsName = map[string]string{
+	lexer.LESS:    JB,
+	lexer.LEQ:     JBE,
+	lexer.GREATER: JA,
+	lexer.GEQ:     JAE,
+	lexer.EQ:      JE,
+	lexer.NEQ:     JNE,
+}
+
 func opInstrName(op tac.TACOperator) string {
 	switch string(op) {
 
@@ -89,7 +141,8 @@ func opInstrName(op tac.TACOperator) string {
 	case lexer.LSHIFT:
 		return SHL
 	case lexer.RSHIFT:
-		return SHR
+		// ints are signed
+		return SAR
 
 	// Calls
 	case "call":
@@ -0,0 +1,293 @@
+package asm_gen
+
+import (
+	"fmt"
+	"he++/tac"
+	"slices"
+	"strings"
+)
+
+// Calls follow the SysV ABI: the first six integer and pointer arguments
+// go in registers, the first eight floating point ones in xmm registers,
+// and the rest on the stack, the first of them at the lowest address. rsp
+// is 16 byte aligned at the call and the result comes back in rax or xmm0.
+// The callee keeps rbx, rbp and r12 to r15 intact, every other register
+// may be overwritten.
+
+var INT_ARG_REGS = []*x86_64Reg{RDI, RSI, RDX, RCX, R8, R9}
+var FLOAT_ARG_REGS = []*x86_64Reg{XMM0, XMM1, XMM2, XMM3, XMM4, XMM5, XMM6, XMM7}
+
+// the register each argument is passed in, nil for those passed on the
+// stack, and the stack ones in the order they are pushed
+func placeArgs(cats []tac.DataCategory) ([]*x86_64Reg, []int) {
+	regs := make([]*x86_64Reg, len(cats))
+	onStack := make([]int, 0)
+	nInt, nFloat := 0, 0
+	for i, dc := range cats {
+		switch {
+		case dc.IsFloating() && nFloat < len(FLOAT_ARG_REGS):
+			regs[i] = FLOAT_ARG_REGS[nFloat]
+			nFloat++
+		case !dc.IsFloating() && nInt < len(INT_ARG_REGS):
+			regs[i] = INT_ARG_REGS[nInt]
+			nInt++
+		default:
+			onStack = append(onStack, i)
+		}
+	}
+	return regs, onStack
+}
+
+// a move into a register, either from another one of its kind or done by
+// instr
+type regMove struct {
+	dst   *x86_64Reg
+	src   *x86_64Reg
+	instr x86_64Instr
+}
+
+// emits moves that each read their source before any of the others
+// overwrites it
+func (fasm *FunctionAsm) parallelMove(moves []regMove) {
+	pending := slices.DeleteFunc(moves, func(m regMove) bool { return m.src != nil && m.src == m.dst })
+	readsFrom := func(reg *x86_64Reg) bool {
+		return slices.ContainsFunc(pending, func(m regMove) bool { return m.src == reg })
+	}
+	for len(pending) > 0 {
+		i := slices.IndexFunc(pending, func(m regMove) bool { return !readsFrom(m.dst) })
+		if i == -1 {
+			// only cycles are left, one register of a cycle is set aside
+			// so that the move into it can go ahead
+			r := pending[0].dst
+			tmp := TEMPREG
+			if r.isXmm() {
+				tmp = FTEMPREG
+			}
+			fasm.emitInstr(copyReg(tmp, r))
+			for j := range pending {
+				if pending[j].src == r {
+					pending[j].src = tmp
+				}
+			}
+			continue
+		}
+		m := pending[i]
+		if m.src != nil {
+			fasm.emitInstr(copyReg(m.dst, m.src))
+		} else {
+			fasm.emitInstr(m.instr)
+		}
+		pending = slices.Delete(pending, i, i+1)
+	}
+}
+
+func copyReg(dst, src *x86_64Reg) x86_64Instr {
+	if dst.isXmm() {
+		return x86_64Instr{instrName: MOVAPS, params: []string{dst.NameForSize(8), src.NameForSize(8)}}
+	}
+	return x86_64Instr{instrName: MOV, params: []string{dst.NameForSize(8), src.NameForSize(8)}}
+}
+
+// the register arg lives in, nil if it's on the stack or an immediate
+func (fasm *FunctionAsm) regOf(arg tac.TACOpArg) *x86_64Reg {
+	v, ok := arg.(*tac.VRegArg)
+	if !ok || fasm.VRegMapping[v.RegNo].isStack() {
+		return nil
+	}
+	return fasm.VRegMapping[v.RegNo].reg
+}
+
+// move of arg into a register of its kind
+func (fasm *FunctionAsm) moveInto(reg *x86_64Reg, arg tac.TACOpArg) regMove {
+	if src := fasm.regOf(arg); src != nil {
+		return regMove{dst: reg, src: src}
+	}
+	if arg.Category().IsFloating() {
+		return regMove{dst: reg, instr: xmmLoad(reg, fasm.instrParam(arg), true, arg.Category())}
+	}
+	dst := reg.NameForSize(arg.Category().SizeBytes())
+	if arg.LocType() == tac.Imm {
+		// the category of an immediate isn't always its size
+		dst = reg.NameForSize(8)
+	}
+	return regMove{dst: reg, instr: x86_64Instr{instrName: MOV, params: []string{dst, fasm.instrParam(arg)}}}
+}
+
+// writes arg to the stack slot at mem
+func (fasm *FunctionAsm) storeArg(mem string, arg tac.TACOpArg) {
+	size := arg.Category().SizeBytes()
+	reg := fasm.regOf(arg)
+	name, src := MOV, ""
+	switch f := arg.(type) {
+	case *tac.ImmFloatArg:
+		src = fmt.Sprint(floatBits(f.Num(), f.Category()))
+	default:
+		src = fasm.instrParam(arg)
+	}
+	if reg != nil && reg.isXmm() {
+		name = sseName(sseMov, arg.Category())
+	} else if reg == nil {
+		// no memory to memory moves, and immediates may need all 64 bits
+		if arg.LocType() == tac.Imm {
+			size = 8
+		}
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{TEMPREG.NameForSize(size), src}})
+		src = TEMPREG.NameForSize(size)
+	}
+	fasm.emitInstr(x86_64Instr{instrName: name, params: []string{fmt.Sprintf("%s[%s]", memWidth(size), mem), src}})
+}
+
+// caller saved registers holding values that are needed after the
+// instruction at index at
+func (fasm *FunctionAsm) liveAcross(at int) []*x86_64Reg {
+	regs := make([]*x86_64Reg, 0)
+	for vreg, life := range fasm.ftac.RegLifetimes() {
+		loc, ok := fasm.VRegMapping[vreg]
+		if ok && !loc.isStack() && !isCalleeSaved(loc.reg) && life.Start < at && life.End > at && !slices.Contains(regs, loc.reg) {
+			regs = append(regs, loc.reg)
+		}
+	}
+	slices.SortFunc(regs, func(a, b *x86_64Reg) int { return strings.Compare(a.name_8, b.name_8) })
+	return regs
+}
+
+// the params of a call come right before it
+func (fasm *FunctionAsm) genAsmForParam(v *tac.ParamInstr) {
+	_, arg, _ := v.ThreeAdresses()
+	fasm.params = append(fasm.params, *arg)
+}
+
+// at is the index of the call among the function's TAC instructions
+func (fasm *FunctionAsm) genAsmForCall(v *tac.CallInstr, at int) {
+	args := fasm.params
+	fasm.params = nil
+	if len(v.Labels()) > 0 {
+		fasm.emitInstr(x86_64Instr{labels: v.Labels()})
+	}
+	retArg, callee, _ := v.ThreeAdresses()
+
+	// the values still needed afterwards wait below the spill slots
+	saved := fasm.liveAcross(at)
+	for i, reg := range saved {
+		fasm.emitInstr(saveReg(fasm.callSaveSlot(i), reg))
+	}
+	fasm.callSaveSize = max(fasm.callSaveSize, 8*len(saved))
+
+	cats := make([]tac.DataCategory, len(args))
+	for i, arg := range args {
+		cats[i] = arg.Category()
+	}
+	regs, onStack := placeArgs(cats)
+	// the body keeps rsp aligned, so only the stack arguments are padded
+	frame := (8*len(onStack) + 15) / 16 * 16
+	if frame > 0 {
+		fasm.emitInstr(x86_64Instr{instrName: SUB, params: []string{RSP.NameForSize(8), fmt.Sprint(frame)}})
+	}
+	for j, i := range onStack {
+		fasm.storeArg(fmt.Sprintf("rsp + %d", 8*j), args[i])
+	}
+
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{RAX.NameForSize(8), fasm.instrParam(*callee)}})
+	moves := make([]regMove, 0)
+	for i, arg := range args {
+		if regs[i] != nil {
+			moves = append(moves, fasm.moveInto(regs[i], arg))
+		}
+	}
+	fasm.parallelMove(moves)
+
+	fasm.emitInstr(x86_64Instr{instrName: CALL, params: []string{RAX.NameForSize(8)}})
+	if frame > 0 {
+		fasm.emitInstr(x86_64Instr{instrName: ADD, params: []string{RSP.NameForSize(8), fmt.Sprint(frame)}})
+	}
+
+	if ret, ok := (*retArg).(*tac.VRegArg); ok && ret.Category() != tac.VOID {
+		dc := ret.Category()
+		switch {
+		case dc.IsFloating() && fasm.isStackArg(ret):
+			fasm.emitInstr(x86_64Instr{instrName: sseName(sseMov, dc), params: []string{fasm.instrParam(ret), XMM0.NameForSize(8)}})
+		case dc.IsFloating():
+			fasm.emitInstr(copyReg(fasm.regOf(ret), XMM0))
+		default:
+			fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fasm.instrParam(ret), RAX.NameForSize(dc.SizeBytes())}})
+		}
+	}
+	for i, reg := range saved {
+		fasm.emitInstr(restoreReg(reg, fasm.callSaveSlot(i)))
+	}
+}
+
+// the whole of a register, to slot and back
+func saveReg(slot string, reg *x86_64Reg) x86_64Instr {
+	if reg.isXmm() {
+		return x86_64Instr{instrName: MOVSD, params: []string{slot, reg.NameForSize(8)}}
+	}
+	return x86_64Instr{instrName: MOV, params: []string{slot, reg.NameForSize(8)}}
+}
+
+func restoreReg(reg *x86_64Reg, slot string) x86_64Instr {
+	if reg.isXmm() {
+		return x86_64Instr{instrName: MOVSD, params: []string{reg.NameForSize(8), slot}}
+	}
+	return x86_64Instr{instrName: MOV, params: []string{reg.NameForSize(8), slot}}
+}
+
+func (fasm *FunctionAsm) callSaveSlot(i int) string {
+	return fmt.Sprintf("%s[rbp - %d]", memWidth(8), fasm.stackFrameSize+8*(i+1))
+}
+
+// moves the arguments from where the caller put them to where the
+// allocator wants them, all at once since an argument may have been
+// given the register another one comes in
+func (fasm *FunctionAsm) genAsmForFuncArgs(recvs []*tac.FuncArgRecvInstr) {
+	cats := fasm.ftac.ArgCategories()
+	regs, onStack := placeArgs(cats)
+	moves := make([]regMove, 0)
+	for _, recv := range recvs {
+		i := recv.ArgNo()
+		dst, _, _ := recv.ThreeAdresses()
+		dc := cats[i]
+		to := fasm.instrParam(*dst)
+		toReg := fasm.regOf(*dst)
+		if regs[i] == nil {
+			// above the return address, in the order they were pushed
+			from := fmt.Sprintf("%s[rbp + %d]", memWidth(dc.SizeBytes()), 16+8*slices.Index(onStack, i))
+			switch {
+			case toReg != nil && dc.IsFloating():
+				moves = append(moves, regMove{dst: toReg, instr: xmmLoad(toReg, from, true, dc)})
+			case toReg != nil:
+				moves = append(moves, regMove{dst: toReg, instr: x86_64Instr{instrName: MOV, params: []string{to, from}}})
+			default:
+				fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{TEMPREG.NameForSize(dc.SizeBytes()), from}})
+				fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{to, TEMPREG.NameForSize(dc.SizeBytes())}})
+			}
+			continue
+		}
+		switch {
+		case dc.IsFloating() && toReg == nil:
+			fasm.emitInstr(x86_64Instr{instrName: sseName(sseMov, dc), params: []string{to, regs[i].NameForSize(8)}})
+		case toReg == nil:
+			// stack slots first, before the registers get shuffled
+			fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{to, regs[i].NameForSize(dc.SizeBytes())}})
+		default:
+			moves = append(moves, regMove{dst: toReg, src: regs[i]})
+		}
+	}
+	fasm.parallelMove(moves)
+}
+
+func (fasm *FunctionAsm) genAsmForLoadLabel(v *tac.LoadLabelInstr) {
+	to, _, _ := v.ThreeAdresses()
+	dest := fasm.instrParam(*to)
+	if fasm.isStackArg(*to) {
+		dest = TEMPREG.NameForSize(8)
+	}
+	fasm.emitInstr(x86_64Instr{
+		instrName: LEA,
+		params:    []string{dest, fmt.Sprintf("[rip + %s]", v.Label())},
+		labels:    v.Labels(),
+	})
+	if fasm.isStackArg(*to) {
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fasm.instrParam(*to), dest}})
+	}
+}
@@ -0,0 +1,124 @@
+package asm_gen
+
+import (
+	"he++/lexer"
+	"he++/tac"
+	"he++/utils"
+)
+
+// idiv divides rdx:rax, leaving the quotient in rax and the remainder in
+// rdx, and a shift by a count held in a register takes it in cl. rax is
+// never handed out, but the allocator keeps the values alive around such
+// an instruction out of rdx and rcx.
+
+// the registers the instruction overwrites besides its destination
+func clobberedBy(ins tac.ThreeAddressInstr) []*x86_64Reg {
+	v, ok := ins.(*tac.BinaryOpInstr)
+	if !ok || isFloating(v) {
+		return nil
+	}
+	_, _, count := v.ThreeAdresses()
+	switch string(v.Operator()) {
+	case lexer.DIV, lexer.MODULO:
+		return []*x86_64Reg{RDX}
+	case lexer.LSHIFT, lexer.RSHIFT:
+		if (*count).LocType() != tac.Imm {
+			return []*x86_64Reg{RCX}
+		}
+	}
+	return nil
+}
+
+// the registers each vreg mustn't be given, since an instruction
+// overwrites them while the vreg is alive. The destination of the
+// instruction is written after the fact and may have them.
+func forbiddenRegs(ftac *tac.FunctionTAC) map[tac.VirtualRegisterNumber]map[*x86_64Reg]bool {
+	forbidden := make(map[tac.VirtualRegisterNumber]map[*x86_64Reg]bool)
+	lives := ftac.RegLifetimes()
+	for i, ins := range ftac.Instrs() {
+		regs := clobberedBy(ins)
+		if len(regs) == 0 {
+			continue
+		}
+		for vreg, life := range lives {
+			if life.Start >= i || life.End < i {
+				continue
+			}
+			if forbidden[vreg] == nil {
+				forbidden[vreg] = make(map[*x86_64Reg]bool)
+			}
+			for _, reg := range regs {
+				forbidden[vreg][reg] = true
+			}
+		}
+	}
+	return forbidden
+}
+
+// pops the first register not in forbidden, leaving the others as they
+// were
+func popAllowed(regList *utils.Stack[*x86_64Reg], forbidden map[*x86_64Reg]bool) (*x86_64Reg, bool) {
+	skipped := make([]*x86_64Reg, 0)
+	defer func() {
+		for i := len(skipped) - 1; i >= 0; i-- {
+			regList.Push(skipped[i])
+		}
+	}()
+	for {
+		reg, ok := regList.Pop()
+		if !ok || !forbidden[reg] {
+			return reg, ok
+		}
+		skipped = append(skipped, reg)
+	}
+}
+
+// quotient or remainder of a signed division
+func (fasm *FunctionAsm) genAsmForDivision(v *tac.BinaryOpInstr) {
+	vregTo, vregA1, vregA2 := v.ThreeAdresses()
+	size := (*vregTo).Category().SizeBytes()
+	fasm.emitInstr(x86_64Instr{
+		instrName: MOV,
+		params:    []string{RAX.NameForSize(size), fasm.instrParam(*vregA1)},
+		labels:    v.Labels(),
+	})
+	// sign extend rax into rdx
+	extend := CDQ
+	if size == 8 {
+		extend = CQO
+	}
+	fasm.emitInstr(x86_64Instr{instrName: extend})
+	divisor := fasm.instrParam(*vregA2)
+	if (*vregA2).LocType() == tac.Imm {
+		// idiv takes no immediate
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{TEMPREG.NameForSize(size), divisor}})
+		divisor = TEMPREG.NameForSize(size)
+	}
+	fasm.emitInstr(x86_64Instr{instrName: IDIV, params: []string{divisor}})
+	result := RAX
+	if string(v.Operator()) == lexer.MODULO {
+		result = RDX
+	}
+	if to := fasm.instrParam(*vregTo); to != result.NameForSize(size) {
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{to, result.NameForSize(size)}})
+	}
+}
+
+// shifts by a count only known at runtime. The value is shifted in the
+// temp reg, the destination may be rcx.
+func (fasm *FunctionAsm) genAsmForVariableShift(v *tac.BinaryOpInstr) {
+	vregTo, vregA1, vregA2 := v.ThreeAdresses()
+	size := (*vregTo).Category().SizeBytes()
+	tmp := TEMPREG.NameForSize(size)
+	fasm.emitInstr(x86_64Instr{
+		instrName: MOV,
+		params:    []string{tmp, fasm.instrParam(*vregA1)},
+		labels:    v.Labels(),
+	})
+	countSize := (*vregA2).Category().SizeBytes()
+	if count := fasm.instrParam(*vregA2); count != RCX.NameForSize(countSize) {
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{RCX.NameForSize(countSize), count}})
+	}
+	fasm.emitInstr(x86_64Instr{instrName: opInstrName(v.Operator()), params: []string{tmp, RCX.NameForSize(1)}})
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fasm.instrParam(*vregTo), tmp}})
+}
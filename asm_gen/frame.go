@@ -0,0 +1,134 @@
+package asm_gen
+
+import (
+	"fmt"
+	"he++/tac"
+	"slices"
+)
+
+// Every function sets up rbp as its frame pointer. Below it lie the
+// spill slots and the memory of stack allocations, then the slots values
+// live across calls are saved in and last the callee saved registers the
+// function uses:
+//
+//	[rbp + 16 + 8j]  stack argument j
+//	[rbp + 8]        return address
+//	[rbp]            caller's rbp
+//	[rbp - 8] ...    spill slots, then allocations, stackFrameSize bytes
+//	...              saved across calls, callSaveSize bytes
+//	...              callee saved registers
+//
+// rsp stays 16 byte aligned in the body, so calls only pad their
+// stack arguments.
+
+// registers a function has to give back as it found them
+var CALLEE_SAVED = []*x86_64Reg{RBX, R12, R13, R14, R15}
+
+// the callee saved registers the allocator handed out, in a fixed order
+func (fasm *FunctionAsm) usedCalleeSaved() []*x86_64Reg {
+	used := make([]*x86_64Reg, 0)
+	for _, reg := range CALLEE_SAVED {
+		for _, loc := range fasm.VRegMapping {
+			if !loc.isStack() && loc.reg == reg {
+				used = append(used, reg)
+				break
+			}
+		}
+	}
+	return used
+}
+
+func isCalleeSaved(reg *x86_64Reg) bool {
+	return slices.Contains(CALLEE_SAVED, reg)
+}
+
+func (fasm *FunctionAsm) calleeSavedSlot(i int) string {
+	return fmt.Sprintf("%s[rbp - %d]", memWidth(8), fasm.stackFrameSize+fasm.callSaveSize+8*(i+1))
+}
+
+// gives the stack allocations of a size known up front room in the
+// frame, so that every activation of a recursive function has its own
+func (fasm *FunctionAsm) placeAllocs() {
+	fasm.allocOffsets = make(map[int]int)
+	for _, ins := range fasm.ftac.Instrs() {
+		alloc, ok := ins.(*tac.AllocInstr)
+		if !ok || alloc.AllocType != tac.STACK_ALLOC {
+			continue
+		}
+		if size, ok := alloc.SizeReg.(*tac.ImmIntArg); ok {
+			fasm.stackFrameSize += int(size.Num()+7) / 8 * 8
+			fasm.allocOffsets[alloc.AllocNo] = fasm.stackFrameSize
+		}
+	}
+}
+
+// clears the size bytes at [rbp - offset], a word at a time
+func (fasm *FunctionAsm) zeroFrame(offset int, size int64) {
+	words := int(size+7) / 8
+	if words <= 8 {
+		for i := range words {
+			fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fmt.Sprintf("%s[rbp - %d]", memWidth(8), offset-8*i), "0"}})
+		}
+		return
+	}
+	// counting TEMPREG down to 0
+	loop := fmt.Sprintf("%s_zero_%d", fasm.ftac.Name(), offset)
+	counter := TEMPREG.NameForSize(8)
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{counter, fmt.Sprint(words)}})
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fmt.Sprintf("%s[rbp + %s*8 - %d]", memWidth(8), counter, offset+8), "0"}, labels: []string{loop}})
+	fasm.emitInstr(x86_64Instr{instrName: SUB, params: []string{counter, "1"}})
+	fasm.emitInstr(x86_64Instr{instrName: JNE, params: []string{loop}})
+}
+
+// memory of a size known only when running is taken off the stack below
+// the frame, rounded up to keep rsp aligned, and given back with the
+// rest of the frame in the epilogue
+func (fasm *FunctionAsm) genAsmForDynamicAlloc(v *tac.AllocInstr) {
+	size := TEMPREG.NameForSize(8)
+	fasm.emitInstr(fasm.extendingMove(size, tac.I64, v.SizeReg, v.Labels()))
+	fasm.emitInstr(x86_64Instr{instrName: ADD, params: []string{size, "15"}})
+	fasm.emitInstr(x86_64Instr{instrName: AND, params: []string{size, "-16"}})
+	fasm.emitInstr(x86_64Instr{instrName: SUB, params: []string{RSP.NameForSize(8), size}})
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fasm.instrParam(v.PtrToAlloc), RSP.NameForSize(8)}})
+	// zeroed from the top down, counting TEMPREG down past 0
+	loop := fmt.Sprintf("%s_zero_alloc_%d", fasm.ftac.Name(), v.AllocNo)
+	check := loop + "_check"
+	fasm.emitInstr(x86_64Instr{instrName: JMP, params: []string{check}})
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fmt.Sprintf("%s[rsp + %s]", memWidth(8), size), "0"}, labels: []string{loop}})
+	fasm.emitInstr(x86_64Instr{instrName: SUB, params: []string{size, "8"}, labels: []string{check}})
+	fasm.emitInstr(x86_64Instr{instrName: JGE, params: []string{loop}})
+}
+
+func (fasm *FunctionAsm) epilogueLabel() string {
+	return fasm.ftac.Name() + "_epilogue"
+}
+
+// the frame size is known only once the body has been lowered
+func (fasm *FunctionAsm) genPrologue() []x86_64Instr {
+	saved := fasm.usedCalleeSaved()
+	size := fasm.stackFrameSize + fasm.callSaveSize + 8*len(saved)
+	size = (size + 15) / 16 * 16
+	prologue := []x86_64Instr{
+		{instrName: PUSH, params: []string{RBP.NameForSize(8)}},
+		{instrName: MOV, params: []string{RBP.NameForSize(8), RSP.NameForSize(8)}},
+	}
+	if size > 0 {
+		prologue = append(prologue, x86_64Instr{instrName: SUB, params: []string{RSP.NameForSize(8), fmt.Sprint(size)}})
+	}
+	for i, reg := range saved {
+		prologue = append(prologue, x86_64Instr{instrName: MOV, params: []string{fasm.calleeSavedSlot(i), reg.NameForSize(8)}})
+	}
+	return prologue
+}
+
+// every return jumps here with its value in place
+func (fasm *FunctionAsm) genEpilogue() {
+	labels := []string{fasm.epilogueLabel()}
+	for i, reg := range fasm.usedCalleeSaved() {
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{reg.NameForSize(8), fasm.calleeSavedSlot(i)}, labels: labels})
+		labels = nil
+	}
+	fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{RSP.NameForSize(8), RBP.NameForSize(8)}, labels: labels})
+	fasm.emitInstr(x86_64Instr{instrName: POP, params: []string{RBP.NameForSize(8)}})
+	fasm.emitInstr(x86_64Instr{instrName: RET})
+}
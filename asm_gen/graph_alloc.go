@@ -0,0 +1,344 @@
+package asm_gen
+
+import (
+	"cmp"
+	"fmt"
+	"he++/tac"
+	"math"
+	"slices"
+)
+
+// Chaitin/Briggs style allocation over an interference graph built from
+// the vreg lifetimes. Moves between vregs whose lifetimes only touch at
+// the move are coalesced when Briggs' test says it can't make the graph
+// harder to colour. Nodes that can't be simplified are pushed
+// optimistically, the cheapest to spill first, cost being the number of
+// uses and defs weighted by loop depth. Spilled vregs share stack slots
+// when they don't interfere.
+
+type RegAllocator string
+
+const (
+	LINEAR_SCAN    RegAllocator = "linear"
+	GRAPH_COLORING RegAllocator = "graph"
+)
+
+var RegAllocators = []RegAllocator{LINEAR_SCAN, GRAPH_COLORING}
+
+// the caller saved ones go first, they cost nothing unless the vreg
+// lives across a call
+var graphIntRegs = []*x86_64Reg{RDI, RSI, RDX, RCX, R8, R9, R10, RBX, R12, R13, R14, R15}
+
+var graphFloatRegs = []*x86_64Reg{XMM0, XMM1, XMM2, XMM3, XMM4, XMM5, XMM6, XMM7,
+	XMM8, XMM9, XMM10, XMM11, XMM12, XMM13, XMM14}
+
+// a vreg, or several once coalesced
+type igNode struct {
+	members   []tac.VirtualRegisterNumber
+	adj       map[*igNode]bool
+	isFloat   bool
+	cost      float64
+	crossCall bool
+	forbidden map[*x86_64Reg]bool
+	// tried first, arguments stay in the register they come in
+	hint *x86_64Reg
+	// set while the node is out of the graph during simplification
+	removed bool
+	reg     *x86_64Reg
+}
+
+func (n *igNode) degree() int {
+	d := 0
+	for nb := range n.adj {
+		if !nb.removed {
+			d++
+		}
+	}
+	return d
+}
+
+// the registers a node can be given, in order of preference
+func (n *igNode) palette() []*x86_64Reg {
+	regs := graphIntRegs
+	switch {
+	case n.isFloat:
+		regs = graphFloatRegs
+	case n.crossCall:
+		// a callee saved register is saved once in the prologue instead
+		// of around every call
+		regs = append(slices.Clone(CALLEE_SAVED), slices.DeleteFunc(slices.Clone(graphIntRegs), isCalleeSaved)...)
+	}
+	if n.hint != nil && !n.crossCall {
+		regs = append([]*x86_64Reg{n.hint}, regs...)
+	}
+	return regs
+}
+
+type interferenceGraph struct {
+	nodes  map[tac.VirtualRegisterNumber]*igNode
+	moves  []graphMove
+	ncoals int
+}
+
+// a = b, with the lifetimes of a and b meeting only there
+type graphMove struct {
+	to, from tac.VirtualRegisterNumber
+	weight   float64
+}
+
+func (fasm *FunctionAsm) colorVRegs() {
+	g := buildInterferenceGraph(fasm.ftac)
+	g.coalesce()
+	spilled := g.simplifyAndSelect()
+	mapping := make(map[tac.VirtualRegisterNumber]Location)
+	for _, n := range g.distinctNodes() {
+		if n.reg == nil {
+			continue
+		}
+		for _, vreg := range n.members {
+			mapping[vreg] = Location{reg: n.reg, offset: 0}
+		}
+	}
+	// spill slots are coloured the same way, with as many colours as needed.
+	// Ints and floats don't compete for registers, so there are no edges
+	// between them, but they do for slots.
+	lives := fasm.ftac.RegLifetimes()
+	slots := make(map[*igNode]int)
+	for _, n := range spilled {
+		used := make(map[int]bool)
+		for nb, slot := range slots {
+			if n.adj[nb] || nb.isFloat != n.isFloat && livesOverlap(lives, n, nb) {
+				used[slot] = true
+			}
+		}
+		slot := 0
+		for used[slot] {
+			slot++
+		}
+		slots[n] = slot
+		fasm.stackFrameSize = max(fasm.stackFrameSize, tac.PTR.SizeBytes()*(slot+1))
+		for _, vreg := range n.members {
+			mapping[vreg] = Location{reg: RBP, offset: tac.PTR.SizeBytes() * (slot + 1)}
+		}
+	}
+	fmt.Fprintf(fasm.dbg, "%s: coalesced %d moves, spilled %d nodes into %d slots\n",
+		fasm.ftac.Name(), g.ncoals, len(spilled), fasm.stackFrameSize/tac.PTR.SizeBytes())
+	fasm.VRegMapping = mapping
+}
+
+// whether a value of a is live while one of b is
+func livesOverlap(lives map[tac.VirtualRegisterNumber]tac.Life, a, b *igNode) bool {
+	for _, va := range a.members {
+		for _, vb := range b.members {
+			if la, lb := lives[va], lives[vb]; la.Start <= lb.End && lb.Start <= la.End {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func buildInterferenceGraph(ftac *tac.FunctionTAC) *interferenceGraph {
+	lives := ftac.RegLifetimes()
+	isFloat := floatVRegs(ftac)
+	forbidden := forbiddenRegs(ftac)
+	instrs := ftac.Instrs()
+	g := &interferenceGraph{nodes: make(map[tac.VirtualRegisterNumber]*igNode)}
+
+	vregs := make([]tac.VirtualRegisterNumber, 0, len(lives))
+	for vreg := range lives {
+		vregs = append(vregs, vreg)
+	}
+	slices.Sort(vregs)
+	for _, vreg := range vregs {
+		g.nodes[vreg] = &igNode{
+			members:   []tac.VirtualRegisterNumber{vreg},
+			adj:       make(map[*igNode]bool),
+			isFloat:   isFloat[vreg],
+			forbidden: make(map[*x86_64Reg]bool),
+		}
+		for reg := range forbidden[vreg] {
+			g.nodes[vreg].forbidden[reg] = true
+		}
+	}
+
+	argRegs, _ := placeArgs(ftac.ArgCategories())
+	cfg := ftac.BuildCFG()
+	touching := make(map[[2]tac.VirtualRegisterNumber]bool)
+	for i, ins := range instrs {
+		weight := math.Pow(10, float64(cfg.BlockOf(i).LoopDepth()))
+		dest, arg1, arg2 := ins.ThreeAdresses()
+		for _, arg := range []*tac.TACOpArg{dest, arg1, arg2} {
+			if v, ok := (*arg).(*tac.VRegArg); ok {
+				g.nodes[v.RegNo].cost += weight
+			}
+		}
+		if recv, ok := ins.(*tac.FuncArgRecvInstr); ok {
+			if v, ok := (*dest).(*tac.VRegArg); ok {
+				g.nodes[v.RegNo].hint = argRegs[recv.ArgNo()]
+			}
+		}
+		if _, ok := ins.(*tac.CallInstr); ok {
+			for _, vreg := range vregs {
+				if life := lives[vreg]; life.Start < i && life.End > i {
+					g.nodes[vreg].crossCall = true
+				}
+			}
+		}
+		if _, ok := ins.(*tac.AssignInstr); !ok {
+			continue
+		}
+		to, ok1 := (*dest).(*tac.VRegArg)
+		from, ok2 := (*arg1).(*tac.VRegArg)
+		if ok1 && ok2 && to.RegNo != from.RegNo && to.Category() == from.Category() &&
+			lives[to.RegNo].Start == i && lives[from.RegNo].End == i {
+			g.moves = append(g.moves, graphMove{to: to.RegNo, from: from.RegNo, weight: weight})
+			touching[[2]tac.VirtualRegisterNumber{to.RegNo, from.RegNo}] = true
+			touching[[2]tac.VirtualRegisterNumber{from.RegNo, to.RegNo}] = true
+		}
+	}
+
+	for i, a := range vregs {
+		for _, b := range vregs[i+1:] {
+			la, lb := lives[a], lives[b]
+			na, nb := g.nodes[a], g.nodes[b]
+			if na.isFloat != nb.isFloat || la.Start > lb.End || lb.Start > la.End {
+				continue
+			}
+			if touching[[2]tac.VirtualRegisterNumber{a, b}] {
+				continue
+			}
+			na.adj[nb] = true
+			nb.adj[na] = true
+		}
+	}
+	return g
+}
+
+// every node once, in the order of their lowest vreg
+func (g *interferenceGraph) distinctNodes() []*igNode {
+	nodes := make([]*igNode, 0)
+	seen := make(map[*igNode]bool)
+	keys := make([]tac.VirtualRegisterNumber, 0, len(g.nodes))
+	for vreg := range g.nodes {
+		keys = append(keys, vreg)
+	}
+	slices.Sort(keys)
+	for _, vreg := range keys {
+		if n := g.nodes[vreg]; !seen[n] {
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func colorCount(n *igNode) int {
+	if n.isFloat {
+		return len(graphFloatRegs)
+	}
+	return len(graphIntRegs)
+}
+
+// merges the ends of moves, those in the deepest loops first, as long as
+// the merged node has fewer than K neighbours of significant degree
+func (g *interferenceGraph) coalesce() {
+	slices.SortStableFunc(g.moves, func(a, b graphMove) int {
+		return cmp.Compare(b.weight, a.weight)
+	})
+	for _, mv := range g.moves {
+		a, b := g.nodes[mv.to], g.nodes[mv.from]
+		if a == b || a.adj[b] {
+			continue
+		}
+		k := colorCount(a)
+		significant := 0
+		for nb := range a.adj {
+			if len(nb.adj) >= k {
+				significant++
+			}
+		}
+		for nb := range b.adj {
+			if !a.adj[nb] && len(nb.adj) >= k {
+				significant++
+			}
+		}
+		if significant >= k {
+			continue
+		}
+		g.merge(a, b)
+		g.ncoals++
+	}
+}
+
+// folds b into a
+func (g *interferenceGraph) merge(a, b *igNode) {
+	for nb := range b.adj {
+		delete(nb.adj, b)
+		nb.adj[a] = true
+		a.adj[nb] = true
+	}
+	for reg := range b.forbidden {
+		a.forbidden[reg] = true
+	}
+	a.members = append(a.members, b.members...)
+	a.cost += b.cost
+	a.crossCall = a.crossCall || b.crossCall
+	if a.hint == nil {
+		a.hint = b.hint
+	}
+	for _, vreg := range b.members {
+		g.nodes[vreg] = a
+	}
+}
+
+// removes nodes of degree below K until the graph is empty, picking the
+// one with the lowest cost per neighbour when there are none. Colours
+// are handed out in the reverse order; the nodes left without one are
+// returned.
+func (g *interferenceGraph) simplifyAndSelect() []*igNode {
+	remaining := g.distinctNodes()
+	stack := make([]*igNode, 0, len(remaining))
+	for len(remaining) > 0 {
+		pick := slices.IndexFunc(remaining, func(n *igNode) bool { return n.degree() < colorCount(n) })
+		if pick == -1 {
+			pick = 0
+			best := math.Inf(1)
+			for i, n := range remaining {
+				if c := n.cost / float64(n.degree()); c < best {
+					pick, best = i, c
+				}
+			}
+		}
+		remaining[pick].removed = true
+		stack = append(stack, remaining[pick])
+		remaining = slices.Delete(remaining, pick, pick+1)
+	}
+
+	spilled := make([]*igNode, 0)
+	for _, n := range slices.Backward(stack) {
+		n.removed = false
+		used := make(map[*x86_64Reg]bool)
+		// left to the neighbours still to come that want them, if possible
+		wanted := make(map[*x86_64Reg]bool)
+		for nb := range n.adj {
+			if nb.reg != nil {
+				used[nb.reg] = true
+			} else if nb.removed && nb.hint != nil {
+				wanted[nb.hint] = true
+			}
+		}
+		palette := slices.DeleteFunc(slices.Clone(n.palette()), func(reg *x86_64Reg) bool {
+			return used[reg] || n.forbidden[reg]
+		})
+		if i := slices.IndexFunc(palette, func(reg *x86_64Reg) bool { return !wanted[reg] || reg == n.hint }); i != -1 {
+			n.reg = palette[i]
+		} else if len(palette) > 0 {
+			n.reg = palette[0]
+		}
+		if n.reg == nil {
+			spilled = append(spilled, n)
+		}
+	}
+	return spilled
+}
@@ -3,10 +3,12 @@ package asm_gen
 import (
 	"fmt"
 	"he++/tac"
+	"strings"
 )
 
 type x86_64Reg struct {
 	name_1 string
+	name_2 string
 	name_4 string
 	name_8 string
 }
@@ -15,6 +17,8 @@ func (reg *x86_64Reg) NameForSize(size int) string {
 	switch size {
 	case 1:
 		return reg.name_1
+	case 2:
+		return reg.name_2
 	case 4:
 		return reg.name_4
 	case 8:
@@ -30,35 +34,51 @@ func (reg *x86_64Reg) String() string {
 
 // Predefined registers (as pointers)
 var (
-	None = &x86_64Reg{"", "", "none"}
+	None = &x86_64Reg{"", "", "", "none"}
 
-	RDI = &x86_64Reg{"dil", "edi", "rdi"}
-	RSI = &x86_64Reg{"sil", "esi", "rsi"}
-	RDX = &x86_64Reg{"dl", "edx", "rdx"}
-	RCX = &x86_64Reg{"cl", "ecx", "rcx"}
+	RDI = &x86_64Reg{"dil", "di", "edi", "rdi"}
+	RSI = &x86_64Reg{"sil", "si", "esi", "rsi"}
+	RDX = &x86_64Reg{"dl", "dx", "edx", "rdx"}
+	RCX = &x86_64Reg{"cl", "cx", "ecx", "rcx"}
 
-	R8  = &x86_64Reg{"r8b", "r8d", "r8"}
-	R9  = &x86_64Reg{"r9b", "r9d", "r9"}
-	R10 = &x86_64Reg{"r10b", "r10d", "r10"}
-	R11 = &x86_64Reg{"r11b", "r11d", "r11"}
+	R8  = &x86_64Reg{"r8b", "r8w", "r8d", "r8"}
+	R9  = &x86_64Reg{"r9b", "r9w", "r9d", "r9"}
+	R10 = &x86_64Reg{"r10b", "r10w", "r10d", "r10"}
+	R11 = &x86_64Reg{"r11b", "r11w", "r11d", "r11"}
+	R12 = &x86_64Reg{"r12b", "r12w", "r12d", "r12"}
+	R13 = &x86_64Reg{"r13b", "r13w", "r13d", "r13"}
+	R14 = &x86_64Reg{"r14b", "r14w", "r14d", "r14"}
+	R15 = &x86_64Reg{"r15b", "r15w", "r15d", "r15"}
 
-	RBX = &x86_64Reg{"bl", "ebx", "rbx"}
-	RAX = &x86_64Reg{"al", "eax", "rax"}
+	RBX = &x86_64Reg{"bl", "bx", "ebx", "rbx"}
+	RAX = &x86_64Reg{"al", "ax", "eax", "rax"}
 
-	RBP = &x86_64Reg{"bpl", "ebp", "rbp"}
-	RSP = &x86_64Reg{"spl", "esp", "rsp"}
+	RBP = &x86_64Reg{"bpl", "bp", "ebp", "rbp"}
+	RSP = &x86_64Reg{"spl", "sp", "esp", "rsp"}
 
-	// XMM registers (no sub-widths)
-	XMM0 = &x86_64Reg{"", "", "xmm0"}
-	XMM1 = &x86_64Reg{"", "", "xmm1"}
-	XMM2 = &x86_64Reg{"", "", "xmm2"}
-	XMM3 = &x86_64Reg{"", "", "xmm3"}
-	XMM4 = &x86_64Reg{"", "", "xmm4"}
-	XMM5 = &x86_64Reg{"", "", "xmm5"}
-	XMM6 = &x86_64Reg{"", "", "xmm6"}
-	XMM7 = &x86_64Reg{"", "", "xmm7"}
+	// XMM registers (no sub-widths, every size names the whole register)
+	XMM0  = &x86_64Reg{"xmm0", "xmm0", "xmm0", "xmm0"}
+	XMM1  = &x86_64Reg{"xmm1", "xmm1", "xmm1", "xmm1"}
+	XMM2  = &x86_64Reg{"xmm2", "xmm2", "xmm2", "xmm2"}
+	XMM3  = &x86_64Reg{"xmm3", "xmm3", "xmm3", "xmm3"}
+	XMM4  = &x86_64Reg{"xmm4", "xmm4", "xmm4", "xmm4"}
+	XMM5  = &x86_64Reg{"xmm5", "xmm5", "xmm5", "xmm5"}
+	XMM6  = &x86_64Reg{"xmm6", "xmm6", "xmm6", "xmm6"}
+	XMM7  = &x86_64Reg{"xmm7", "xmm7", "xmm7", "xmm7"}
+	XMM8  = &x86_64Reg{"xmm8", "xmm8", "xmm8", "xmm8"}
+	XMM9  = &x86_64Reg{"xmm9", "xmm9", "xmm9", "xmm9"}
+	XMM10 = &x86_64Reg{"xmm10", "xmm10", "xmm10", "xmm10"}
+	XMM11 = &x86_64Reg{"xmm11", "xmm11", "xmm11", "xmm11"}
+	XMM12 = &x86_64Reg{"xmm12", "xmm12", "xmm12", "xmm12"}
+	XMM13 = &x86_64Reg{"xmm13", "xmm13", "xmm13", "xmm13"}
+	XMM14 = &x86_64Reg{"xmm14", "xmm14", "xmm14", "xmm14"}
+	XMM15 = &x86_64Reg{"xmm15", "xmm15", "xmm15", "xmm15"}
 )
 
+func (reg *x86_64Reg) isXmm() bool {
+	return strings.HasPrefix(reg.name_8, "xmm")
+}
+
 func vRegComparator(ftac *tac.FunctionTAC, ra, rb tac.VirtualRegisterNumber) bool {
 	// decide priority based on lifetime, use frequency etc.
 	lifes := ftac.RegLifetimes()
@@ -0,0 +1,257 @@
+package asm_gen
+
+import (
+	"fmt"
+	"he++/lexer"
+	"he++/tac"
+	"math"
+	"slices"
+)
+
+// Floating point values live in xmm registers and are worked on with the
+// scalar SSE instructions, the ss forms for f32 and the sd forms for f64.
+// Constants are read from .rodata, since no SSE instruction takes an
+// immediate.
+
+// scratch register for floating point values, never handed out by the
+// allocator
+var FTEMPREG = XMM15
+
+// a floating point constant in .rodata
+type floatConst struct {
+	label string
+	bits  uint64
+	size  int
+}
+
+var sseOps = map[string][2]string{
+	lexer.ADD: {ADDSS, ADDSD},
+	lexer.SUB: {SUBSS, SUBSD},
+	lexer.MUL: {MULSS, MULSD},
+	lexer.DIV: {DIVSS, DIVSD},
+}
+
+var (
+	sseMov     = [2]string{MOVSS, MOVSD}
+	sseUcomis  = [2]string{UCOMISS, UCOMISD}
+	sseFromInt = [2]string{CVTSI2SS, CVTSI2SD}
+	sseToInt   = [2]string{CVTTSS2SI, CVTTSD2SI}
+	// into f32 from f64, into f64 from f32
+	sseWidth = [2]string{CVTSD2SS, CVTSS2SD}
+)
+
+// the ss or sd form of an operation
+func sseName(names [2]string, dc tac.DataCategory) string {
+	if dc == tac.F32 {
+		return names[0]
+	}
+	return names[1]
+}
+
+func sseInstrName(op tac.TACOperator, dc tac.DataCategory) string {
+	names, ok := sseOps[string(op)]
+	if !ok {
+		panic("unsupported floating point operator: " + string(op))
+	}
+	return sseName(names, dc)
+}
+
+func floatBits(num float64, dc tac.DataCategory) uint64 {
+	if dc == tac.F32 {
+		return uint64(math.Float32bits(float32(num)))
+	}
+	return math.Float64bits(num)
+}
+
+// memory operand reading num as a number of category dc
+func (fasm *FunctionAsm) floatConstOperand(num float64, dc tac.DataCategory) string {
+	bits, size := floatBits(num, dc), dc.SizeBytes()
+	i := slices.IndexFunc(fasm.floatConsts, func(c floatConst) bool { return c.bits == bits && c.size == size })
+	if i == -1 {
+		i = len(fasm.floatConsts)
+		fasm.floatConsts = append(fasm.floatConsts, floatConst{
+			label: fmt.Sprintf("%s_float_%d", fasm.ftac.Name(), i),
+			bits:  bits,
+			size:  size,
+		})
+	}
+	return fmt.Sprintf("%s[rip + %s]", memWidth(size), fasm.floatConsts[i].label)
+}
+
+// floating point immediates are read from memory as well
+func (fasm *FunctionAsm) inMemory(arg tac.TACOpArg) bool {
+	return fasm.isStackArg(arg) || arg.LocType() == tac.Imm
+}
+
+// copies a floating point value into xmm register to, from src which is
+// in memory if srcMem is set
+func xmmLoad(to *x86_64Reg, src string, srcMem bool, dc tac.DataCategory) x86_64Instr {
+	if srcMem {
+		return x86_64Instr{instrName: sseName(sseMov, dc), params: []string{to.NameForSize(8), src}}
+	}
+	return x86_64Instr{instrName: MOVAPS, params: []string{to.NameForSize(8), src}}
+}
+
+func (fasm *FunctionAsm) xmmOf(arg tac.TACOpArg) *x86_64Reg {
+	if fasm.inMemory(arg) {
+		return FTEMPREG
+	}
+	return fasm.regOf(arg)
+}
+
+// brings arg into an xmm register, FTEMPREG unless it's in one already
+func (fasm *FunctionAsm) floatInReg(arg tac.TACOpArg, labels []string) (*x86_64Reg, []string) {
+	if !fasm.inMemory(arg) {
+		return fasm.regOf(arg), labels
+	}
+	ins := xmmLoad(FTEMPREG, fasm.instrParam(arg), true, arg.Category())
+	ins.labels = labels
+	fasm.emitInstr(ins)
+	return FTEMPREG, nil
+}
+
+// stores the value computed in FTEMPREG if to was spilled
+func (fasm *FunctionAsm) floatWriteBack(to tac.TACOpArg) {
+	if fasm.isStackArg(to) {
+		fasm.emitInstr(x86_64Instr{
+			instrName: sseName(sseMov, to.Category()),
+			params:    []string{fasm.instrParam(to), FTEMPREG.NameForSize(8)},
+		})
+	}
+}
+
+func (fasm *FunctionAsm) genAsmForFloatAssign(v *tac.AssignInstr) {
+	vregTo, vregArg, _ := v.ThreeAdresses()
+	if fasm.instrParam(*vregTo) == fasm.instrParam(*vregArg) {
+		return
+	}
+	if !fasm.isStackArg(*vregTo) {
+		ins := xmmLoad(fasm.regOf(*vregTo), fasm.instrParam(*vregArg), fasm.inMemory(*vregArg), (*vregTo).Category())
+		ins.labels = v.Labels()
+		fasm.emitInstr(ins)
+		return
+	}
+	// no mem to mem moves
+	src, labels := fasm.floatInReg(*vregArg, v.Labels())
+	fasm.emitInstr(x86_64Instr{
+		instrName: sseName(sseMov, (*vregTo).Category()),
+		params:    []string{fasm.instrParam(*vregTo), src.NameForSize(8)},
+		labels:    labels,
+	})
+}
+
+func (fasm *FunctionAsm) genAsmForFloatBinary(v *tac.BinaryOpInstr) {
+	vregTo, vregA1, vregA2 := v.ThreeAdresses()
+	dc := (*vregTo).Category()
+	to := fasm.xmmOf(*vregTo)
+	labels := v.Labels()
+	if fasm.isStackArg(*vregTo) || fasm.instrParam(*vregA1) != to.NameForSize(8) {
+		ins := xmmLoad(to, fasm.instrParam(*vregA1), fasm.inMemory(*vregA1), dc)
+		ins.labels = labels
+		fasm.emitInstr(ins)
+		labels = nil
+	}
+	fasm.emitInstr(x86_64Instr{
+		instrName: sseInstrName(v.Operator(), dc),
+		params:    []string{to.NameForSize(8), fasm.instrParam(*vregA2)},
+		labels:    labels,
+	})
+	fasm.floatWriteBack(*vregTo)
+}
+
+// -x is worked out as 0 - x
+func (fasm *FunctionAsm) genAsmForFloatNeg(v *tac.UnaryOpInstr) {
+	vregTo, vregArg, _ := v.ThreeAdresses()
+	dc := (*vregTo).Category()
+	tmp := FTEMPREG.NameForSize(8)
+	fasm.emitInstr(x86_64Instr{instrName: XORPS, params: []string{tmp, tmp}, labels: v.Labels()})
+	fasm.emitInstr(x86_64Instr{
+		instrName: sseInstrName(tac.TACOperator(lexer.SUB), dc),
+		params:    []string{tmp, fasm.instrParam(*vregArg)},
+	})
+	if fasm.isStackArg(*vregTo) {
+		fasm.floatWriteBack(*vregTo)
+		return
+	}
+	fasm.emitInstr(xmmLoad(fasm.regOf(*vregTo), tmp, false, dc))
+}
+
+// ucomiss and ucomisd set the flags like an unsigned comparison does,
+// and ZF, PF and CF all at once when an operand is NaN. Comparisons with
+// NaN are false, but for !=, so < and <= are made > and >= with the
+// operands swapped, which NaN fails, and == and != look at PF.
+func (fasm *FunctionAsm) genAsmForFloatCJump(v *tac.CJumpInstr) {
+	_, argL, argR := v.ThreeAdresses()
+	dc := (*argL).Category()
+	op := string(v.Op)
+	switch op {
+	case lexer.LESS:
+		argL, argR, op = argR, argL, lexer.GREATER
+	case lexer.LEQ:
+		argL, argR, op = argR, argL, lexer.GEQ
+	}
+	left, labels := fasm.floatInReg(*argL, v.Labels())
+	fasm.emitInstr(x86_64Instr{
+		instrName: sseName(sseUcomis, dc),
+		params:    []string{left.NameForSize(8), fasm.instrParam(*argR)},
+		labels:    labels,
+	})
+	switch op {
+	case lexer.EQ:
+		fasm.emitInstr(x86_64Instr{instrName: JNE, params: []string{v.JmpToLabel}})
+		fasm.emitInstr(x86_64Instr{instrName: JP, params: []string{v.JmpToLabel}})
+	case lexer.NEQ:
+		// false only when equal and ordered
+		unordered := fmt.Sprintf("%s_unordered_%d", fasm.ftac.Name(), len(fasm.instrs))
+		fasm.emitInstr(x86_64Instr{instrName: JP, params: []string{unordered}})
+		fasm.emitInstr(x86_64Instr{instrName: JE, params: []string{v.JmpToLabel}})
+		fasm.emitInstr(x86_64Instr{labels: []string{unordered}})
+	default:
+		fasm.emitInstr(x86_64Instr{
+			instrName: floatCompOpsName[string(OppositeCompOp(tac.TACOperator(op)))],
+			params:    []string{v.JmpToLabel},
+		})
+	}
+}
+
+func (fasm *FunctionAsm) genAsmForConvert(v *tac.ConvertInstr) {
+	vregTo, vregArg, _ := v.ThreeAdresses()
+	from, dc := (*vregArg).Category(), (*vregTo).Category()
+	var name string
+	switch {
+	case !from.IsFloating():
+		name = sseName(sseFromInt, dc)
+	case !dc.IsFloating():
+		name = sseName(sseToInt, from)
+	default:
+		name = sseName(sseWidth, dc)
+	}
+	to := fasm.instrParam(*vregTo)
+	if fasm.isStackArg(*vregTo) {
+		// the destination has to be a register
+		to = FTEMPREG.NameForSize(8)
+		if !dc.IsFloating() {
+			to = TEMPREG.NameForSize(dc.SizeBytes())
+		}
+	}
+	src, labels := fasm.instrParam(*vregArg), v.Labels()
+	if imm, ok := (*vregArg).(*tac.ImmIntArg); ok {
+		// there is no form converting an immediate
+		fasm.emitInstr(x86_64Instr{
+			instrName: MOV,
+			params:    []string{TEMPREG.NameForSize(8), fmt.Sprint(imm.Num())},
+			labels:    labels,
+		})
+		src, labels = TEMPREG.NameForSize(8), nil
+	}
+	fasm.emitInstr(x86_64Instr{
+		instrName: name,
+		params:    []string{to, src},
+		labels:    labels,
+	})
+	if fasm.isStackArg(*vregTo) && dc.IsFloating() {
+		fasm.floatWriteBack(*vregTo)
+	} else if fasm.isStackArg(*vregTo) {
+		fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{fasm.instrParam(*vregTo), to}})
+	}
+}
@@ -0,0 +1,50 @@
+package asm_gen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// writes the assembly text into dir and turns it into an
+// executable at outPath using the system toolchain.
+func BuildExecutable(asm string, dir string, outPath string) error {
+	asmPath := filepath.Join(dir, strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath))+".s")
+	if err := os.WriteFile(asmPath, []byte(asm), 0644); err != nil {
+		return err
+	}
+	return AssembleAndLink(asmPath, outPath)
+}
+
+// prefers as + ld, falling back to the C compiler driver.
+// No libc is linked, the program is entered through _start.
+func AssembleAndLink(asmPath string, outPath string) error {
+	_, asErr := exec.LookPath("as")
+	_, ldErr := exec.LookPath("ld")
+	if asErr == nil && ldErr == nil {
+		objPath := strings.TrimSuffix(asmPath, filepath.Ext(asmPath)) + ".o"
+		defer os.Remove(objPath)
+		if err := runTool("as", "--64", "-o", objPath, asmPath); err != nil {
+			return err
+		}
+		return runTool("ld", "-o", outPath, objPath)
+	}
+	if _, err := exec.LookPath("cc"); err == nil {
+		return runTool("cc", "-nostdlib", "-static", "-o", outPath, asmPath)
+	}
+	return errors.New("no assembler and linker found, install binutils (as, ld) or a C compiler (cc)")
+}
+
+func runTool(name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", name, err, stderr.String())
+	}
+	return nil
+}
@@ -16,18 +16,26 @@ type Event struct {
 func (fasm *FunctionAsm) createVregMapping() {
 	lives := fasm.ftac.RegLifetimes()
 	events := getLifeEventList(lives)
-	intRegListOrdered := utils.MakeStack(R10, R9, R8, RCX, RDX, RSI, RDI)
-	// floatRegListOrdered := utils.MakeStack(XMM7, XMM6, XMM5, XMM4, XMM3, XMM2, XMM1, XMM0)
+	isFloat := floatVRegs(fasm.ftac)
+	forbidden := forbiddenRegs(fasm.ftac)
+	// the callee saved ones go last, they cost a save in the prologue
+	intRegListOrdered := utils.MakeStack(R15, R14, R13, R12, RBX, R10, R9, R8, RCX, RDX, RSI, RDI)
+	// the ones arguments don't come in go first
+	floatRegListOrdered := utils.MakeStack(XMM0, XMM1, XMM2, XMM3, XMM4, XMM5, XMM6, XMM7,
+		XMM8, XMM9, XMM10, XMM11, XMM12, XMM13, XMM14)
 	regComp := func(a, b tac.VirtualRegisterNumber) bool {
 		return vRegComparator(fasm.ftac, a, b)
 	}
-	vregsUsingRealRegs := utils.MakeHeap(regComp)
-	// floatActiveUse := utils.MakeHeap(regComp)
+	intActiveUse := utils.MakeHeap(regComp)
+	floatActiveUse := utils.MakeHeap(regComp)
 	mapping := make(map[tac.VirtualRegisterNumber]Location)
 	for _, evt := range events {
-		// todo: support floats
+		regList, vregsUsingRealRegs := intRegListOrdered, &intActiveUse
+		if isFloat[evt.vregNo] {
+			regList, vregsUsingRealRegs = floatRegListOrdered, &floatActiveUse
+		}
 		if evt.kind {
-			reg, ex := intRegListOrdered.Pop()
+			reg, ex := popAllowed(regList, forbidden[evt.vregNo])
 			if ex {
 				mapping[evt.vregNo] = Location{reg: reg, offset: 0}
 			} else {
@@ -43,16 +51,16 @@ func (fasm *FunctionAsm) createVregMapping() {
 					}
 				}
 				// spill leastImpReg
-				mapping[leastImpReg] = Location{reg: RSP, offset: fasm.stackFrameSize}
 				// todo: wastage of space here
 				fasm.stackFrameSize += tac.PTR.SizeBytes()
+				mapping[leastImpReg] = Location{reg: RBP, offset: fasm.stackFrameSize}
 			}
 		} else {
 			// reclaim the reg
 			mappedReg, ex := mapping[evt.vregNo]
 			if ex {
 				if mappedReg.offset == 0 {
-					intRegListOrdered.Push(mappedReg.reg)
+					regList.Push(mappedReg.reg)
 				} // else no reclamation
 			} else {
 				// logical error
@@ -64,6 +72,20 @@ func (fasm *FunctionAsm) createVregMapping() {
 	fasm.VRegMapping = mapping
 }
 
+// the vregs holding floating point values, they get xmm registers
+func floatVRegs(ftac *tac.FunctionTAC) map[tac.VirtualRegisterNumber]bool {
+	isFloat := make(map[tac.VirtualRegisterNumber]bool)
+	for _, instr := range ftac.Instrs() {
+		dest, arg1, arg2 := instr.ThreeAdresses()
+		for _, arg := range []*tac.TACOpArg{dest, arg1, arg2} {
+			if v, ok := (*arg).(*tac.VRegArg); ok && v.Category().IsFloating() {
+				isFloat[v.RegNo] = true
+			}
+		}
+	}
+	return isFloat
+}
+
 func getLifeEventList(lives map[tac.VirtualRegisterNumber]tac.Life) []Event {
 	events := make([]Event, 0)
 	for vregNo, life := range lives {
@@ -2,7 +2,10 @@ package asm_gen
 
 import (
 	"fmt"
+	"he++/lexer"
 	"he++/tac"
+	"io"
+	"slices"
 )
 
 type Location struct {
@@ -17,9 +20,39 @@ func (l Location) String() string {
 	return string(l.reg.String())
 }
 
+func (l Location) isStack() bool {
+	return l.offset != 0
+}
+
+// memory operand for a stack location
+func (l Location) operand(size int) string {
+	return fmt.Sprintf("%s[%s - %d]", memWidth(size), l.reg.NameForSize(8), l.offset)
+}
+
+func memWidth(size int) string {
+	width := ""
+	switch size {
+	case 1:
+		width = "byte"
+	case 2:
+		width = "word"
+	case 4:
+		width = "dword"
+	case 8:
+		width = "qword"
+	}
+	return width + " ptr "
+}
+
 // following SysV ABI
 type AsmGen struct {
 	tacHandler *tac.TACHandler
+	functions  []FunctionAsm
+	// TAC instructions the backend can't lower yet are reported here
+	Debug io.Writer
+	// linear scan unless set
+	RegAlloc RegAllocator
+	curFn    string
 }
 
 func NewAsmGen(tacHandler *tac.TACHandler) AsmGen {
@@ -29,17 +62,24 @@ func NewAsmGen(tacHandler *tac.TACHandler) AsmGen {
 }
 
 func (ag *AsmGen) GenerateAsm() {
-	for fname, ftac := range ag.tacHandler.TacBlocks {
+	for _, ftac := range ag.tacHandler.Functions() {
+		ag.curFn = ftac.Name()
 		fasm := MakeFunctionAsm(ftac)
-		fasm.GenerateAsm()
-		fmt.Println("asm for", fname)
-		for i := range fasm.instrs {
-			fmt.Println(fasm.instrs[i])
+		fasm.dbg = ag.Debug
+		if fasm.dbg == nil {
+			fasm.dbg = io.Discard
 		}
-		fmt.Println()
+		fasm.regAlloc = ag.RegAlloc
+		fasm.GenerateAsm()
+		ag.functions = append(ag.functions, fasm)
 	}
 }
 
+// name of the function being lowered
+func (ag *AsmGen) CurrentFunction() string {
+	return ag.curFn
+}
+
 type FunctionAsm struct {
 	VRegMapping         map[tac.VirtualRegisterNumber]Location
 	intRegListOrdered   []x86_64Reg
@@ -47,10 +87,22 @@ type FunctionAsm struct {
 	ftac                *tac.FunctionTAC
 	instrs              []x86_64Instr
 	stackFrameSize      int
+	// where each stack allocation starts below rbp, by its number
+	allocOffsets map[int]int
+	dbg          io.Writer
+	// room for the registers saved around calls
+	callSaveSize int
+	floatConsts  []floatConst
+	// arguments of the call being lowered
+	params   []tac.TACOpArg
+	regAlloc RegAllocator
 }
 
 var TEMPREG = R11
 
+// scratch register for spilled pointers, never handed out by the allocator
+var ADDRREG = RAX
+
 func MakeFunctionAsm(ftac *tac.FunctionTAC) FunctionAsm {
 
 	fasm := FunctionAsm{
@@ -68,37 +120,89 @@ func (fasm *FunctionAsm) emitInstr(ins x86_64Instr) {
 }
 
 func (fasm *FunctionAsm) GenerateAsm() {
-	fasm.createVregMapping()
+	if fasm.regAlloc == GRAPH_COLORING {
+		fasm.colorVRegs()
+	} else {
+		fasm.createVregMapping()
+	}
+	fasm.placeAllocs()
 	// for k, v := range fasm.VRegMapping {
 	// 	fmt.Printf("VR: %v, Loc: %s\n", utils.Red(fmt.Sprint(k)), utils.Cyan(v.String()))
 	// }
 	instrs := fasm.ftac.Instrs()
-	for i := range instrs {
+	recvs := make([]*tac.FuncArgRecvInstr, 0)
+	for _, ins := range instrs {
+		recv, ok := ins.(*tac.FuncArgRecvInstr)
+		if !ok {
+			break
+		}
+		recvs = append(recvs, recv)
+	}
+	fasm.genAsmForFuncArgs(recvs)
+	for i := len(recvs); i < len(instrs); i++ {
+		emitted := len(fasm.instrs)
 		switch v := instrs[i].(type) {
 		case *tac.AssignInstr:
-			fasm.genAsmForAssign(v)
+			if isFloating(v) {
+				fasm.genAsmForFloatAssign(v)
+			} else {
+				fasm.genAsmForAssign(v)
+			}
 		case *tac.BinaryOpInstr:
-			fasm.genAsmForBinary(v)
+			switch {
+			case isFloating(v):
+				fasm.genAsmForFloatBinary(v)
+			case slices.Contains(clobberedBy(v), RDX):
+				fasm.genAsmForDivision(v)
+			case slices.Contains(clobberedBy(v), RCX):
+				fasm.genAsmForVariableShift(v)
+			default:
+				fasm.genAsmForBinary(v)
+			}
+		case *tac.UnaryOpInstr:
+			if isFloating(v) {
+				fasm.genAsmForFloatNeg(v)
+			} else {
+				fasm.genAsmForUnary(v)
+			}
+		case *tac.ConvertInstr:
+			fasm.genAsmForConvert(v)
 		case *tac.JumpInstr:
 			fasm.genAsmForJump(v)
 		case *tac.CJumpInstr:
-			fasm.genAsmForCJump(v)
+			if isFloating(v) {
+				fasm.genAsmForFloatCJump(v)
+			} else {
+				fasm.genAsmForCJump(v)
+			}
 		case *tac.MemStoreInstr:
 			fasm.genAsmForMemStore(v)
 		case *tac.MemLoadInstr:
 			fasm.genAsmForMemLoad(v)
 		case *tac.AllocInstr:
 			fasm.genAsmForAlloc(v)
+		case *tac.ParamInstr:
+			fasm.genAsmForParam(v)
 		case *tac.CallInstr:
-			fasm.genAsmForCall(v)
-		case *tac.FuncArgRecvInstr:
-			fasm.genAsmForFuncArgRecv(v)
+			fasm.genAsmForCall(v, i)
+		case *tac.LoadLabelInstr:
+			fasm.genAsmForLoadLabel(v)
 		case *tac.LoopBoundary:
 			fasm.genAsmForLoopBoundary(v)
+		case *tac.FuncRetInstr:
+			fasm.genAsmForRet(v, i == len(instrs)-1)
 		default:
-			fmt.Println("Not impl for", instrs[i])
+			// reported as an internal error rather than left out
+			panic(fmt.Sprintf("can't lower %s", instrs[i]))
+		}
+		if len(fasm.instrs) == emitted && len(instrs[i].Labels()) > 0 {
+			// nothing was emitted, but jumps may still target the labels
+			fasm.emitInstr(x86_64Instr{labels: instrs[i].Labels()})
 		}
 	}
+	// functions without a trailing devolver end up here too
+	fasm.genEpilogue()
+	fasm.instrs = append(fasm.genPrologue(), fasm.instrs...)
 }
 
 func (fasm *FunctionAsm) instrParam(arg tac.TACOpArg) string {
@@ -106,14 +210,14 @@ func (fasm *FunctionAsm) instrParam(arg tac.TACOpArg) string {
 	case *tac.ImmIntArg:
 		return fmt.Sprintf("%d", v.Num())
 	case *tac.ImmFloatArg:
-		return fmt.Sprintf("%f", v.Num())
+		return fasm.floatConstOperand(v.Num(), v.Category())
 	case *tac.VRegArg:
 		loc, ex := fasm.VRegMapping[v.RegNo]
 		if !ex {
 			panic(fmt.Sprintf("Se esperaba un mapping para %s", v.String()))
 		}
-		if loc.offset != 0 {
-			return loc.String()
+		if loc.isStack() {
+			return loc.operand(v.Category().SizeBytes())
 		}
 		actualRegName := loc.reg.NameForSize(v.Category().SizeBytes())
 		return actualRegName
@@ -122,12 +226,64 @@ func (fasm *FunctionAsm) instrParam(arg tac.TACOpArg) string {
 	}
 }
 
+// whether the instruction works on floating point numbers, the operands
+// of a comparison are of one kind
+func isFloating(ins tac.ThreeAddressInstr) bool {
+	dest, arg1, _ := ins.ThreeAdresses()
+	return (*dest).Category().IsFloating() || (*arg1).Category().IsFloating()
+}
+
+func (fasm *FunctionAsm) isStackArg(arg tac.TACOpArg) bool {
+	v, ok := arg.(*tac.VRegArg)
+	return ok && fasm.VRegMapping[v.RegNo].isStack()
+}
+
+// the move of arg into register to, of category dc. An int vreg narrower
+// than dc is sign extended, like the interpreter reads it.
+func (fasm *FunctionAsm) extendingMove(to string, dc tac.DataCategory, arg tac.TACOpArg, labels []string) x86_64Instr {
+	name := MOV
+	if narrower(arg, dc) {
+		name = MOVSX
+		if arg.Category().SizeBytes() == 4 {
+			name = MOVSXD
+		}
+	}
+	return x86_64Instr{instrName: name, params: []string{to, fasm.instrParam(arg)}, labels: labels}
+}
+
+// whether arg is an int vreg narrower than dc
+func narrower(arg tac.TACOpArg, dc tac.DataCategory) bool {
+	v, ok := arg.(*tac.VRegArg)
+	return ok && !dc.IsFloating() && v.Category().SizeBytes() < dc.SizeBytes()
+}
+
 func (fasm *FunctionAsm) genAsmForAssign(v *tac.AssignInstr) {
 	vregTo, vregArg, _ := v.ThreeAdresses()
 	p1, p2 := fasm.instrParam(*vregTo), fasm.instrParam(*vregArg)
+	if dc := (*vregTo).Category(); narrower(*vregArg, dc) {
+		to := p1
+		if fasm.isStackArg(*vregTo) {
+			to = TEMPREG.NameForSize(dc.SizeBytes())
+		}
+		fasm.emitInstr(fasm.extendingMove(to, dc, *vregArg, v.Labels()))
+		if to != p1 {
+			fasm.emitInstr(x86_64Instr{instrName: MOV, params: []string{p1, to}})
+		}
+		return
+	}
 	if p1 == p2 {
 		return
 	}
+	if fasm.isStackArg(*vregTo) && fasm.isStackArg(*vregArg) {
+		// no mem to mem moves
+		tmp := TEMPREG.NameForSize((*vregTo).Category().SizeBytes())
+		fasm.emitInstr(x86_64Instr{instrName: MOV,
+			params: []string{tmp, p2},
+			labels: v.Labels(),
+		})
+		p2 = tmp
+		v = &tac.AssignInstr{}
+	}
 	fasm.emitInstr(x86_64Instr{instrName: MOV,
 		params: []string{p1, p2},
 		labels: v.Labels(),
@@ -137,14 +293,59 @@ func (fasm *FunctionAsm) genAsmForAssign(v *tac.AssignInstr) {
 func (fasm *FunctionAsm) genAsmForBinary(v *tac.BinaryOpInstr) {
 	vregTo, vregA1, vregA2 := v.ThreeAdresses()
 	to := fasm.instrParam(*vregTo)
-	fasm.emitInstr(x86_64Instr{instrName: MOV,
-		params: []string{to, fasm.instrParam(*vregA1)},
-		labels: v.Labels(),
-	})
+	// compute in the temp reg if the destination lives on the stack,
+	// since at most one operand may be in memory
+	dc := (*vregTo).Category()
+	spilledTo := fasm.isStackArg(*vregTo)
+	if spilledTo {
+		to = TEMPREG.NameForSize(dc.SizeBytes())
+	}
+	fasm.emitInstr(fasm.extendingMove(to, dc, *vregA1, v.Labels()))
+	arg2 := fasm.instrParam(*vregA2)
+	if narrower(*vregA2, dc) {
+		arg2 = ADDRREG.NameForSize(dc.SizeBytes())
+		fasm.emitInstr(fasm.extendingMove(arg2, dc, *vregA2, nil))
+	}
 	fasm.emitInstr(x86_64Instr{
 		instrName: opInstrName(v.Operator()),
-		params:    []string{to, fasm.instrParam(*vregA2)},
+		params:    []string{to, arg2},
 	})
+	if spilledTo {
+		fasm.emitInstr(x86_64Instr{instrName: MOV,
+			params: []string{fasm.instrParam(*vregTo), to},
+		})
+	}
+}
+
+func (fasm *FunctionAsm) genAsmForUnary(v *tac.UnaryOpInstr) {
+	vregTo, vregArg, _ := v.ThreeAdresses()
+	to := fasm.instrParam(*vregTo)
+	spilledTo := fasm.isStackArg(*vregTo)
+	if spilledTo {
+		to = TEMPREG.NameForSize((*vregTo).Category().SizeBytes())
+	}
+	labels := v.Labels()
+	if arg := fasm.instrParam(*vregArg); arg != to {
+		fasm.emitInstr(x86_64Instr{instrName: MOV,
+			params: []string{to, arg},
+			labels: labels,
+		})
+		labels = nil
+	}
+	switch v.Operator() {
+	case lexer.SUB:
+		fasm.emitInstr(x86_64Instr{instrName: NEG, params: []string{to}, labels: labels})
+	case lexer.NOT:
+		// booleans are 0 or 1
+		fasm.emitInstr(x86_64Instr{instrName: XOR, params: []string{to, "1"}, labels: labels})
+	default:
+		panic("unsupported unary operator: " + v.Operator())
+	}
+	if spilledTo {
+		fasm.emitInstr(x86_64Instr{instrName: MOV,
+			params: []string{fasm.instrParam(*vregTo), to},
+		})
+	}
 }
 
 func (fasm *FunctionAsm) genAsmForJump(v *tac.JumpInstr) {
@@ -158,11 +359,27 @@ func (fasm *FunctionAsm) genAsmForJump(v *tac.JumpInstr) {
 func (fasm *FunctionAsm) genAsmForCJump(v *tac.CJumpInstr) {
 	_, argL, argR := v.ThreeAdresses()
 	op := OppositeCompOp(v.Op)
+	left, right := fasm.instrParam(*argL), fasm.instrParam(*argR)
+	labels := v.Labels()
+	if (*argL).LocType() == tac.Imm || (fasm.isStackArg(*argL) && fasm.isStackArg(*argR)) {
+		// cmp can't take an immediate or a second memory operand on the left
+		tmp := TEMPREG.NameForSize((*argR).Category().SizeBytes())
+		if (*argL).LocType() != tac.Imm {
+			tmp = TEMPREG.NameForSize((*argL).Category().SizeBytes())
+		}
+		fasm.emitInstr(x86_64Instr{
+			instrName: MOV,
+			params:    []string{tmp, left},
+			labels:    labels,
+		})
+		left = tmp
+		labels = nil
+	}
 
 	fasm.emitInstr(x86_64Instr{
 		instrName: CMP,
-		params:    []string{fasm.instrParam(*argL), fasm.instrParam(*argR)},
-		labels:    v.Labels(),
+		params:    []string{left, right},
+		labels:    labels,
 	})
 	fasm.emitInstr(x86_64Instr{
 		instrName: compOpsName[string(op)],
@@ -170,60 +387,83 @@ func (fasm *FunctionAsm) genAsmForCJump(v *tac.CJumpInstr) {
 	})
 }
 
-func (fasm *FunctionAsm) genAsmForMemStore(v *tac.MemStoreInstr) {
-	width := ""
-	switch v.NumBytes {
-	case 1:
-		width = "byte"
-	case 4:
-		width = "dword"
-	case 8:
-		width = "qword"
+// register holding the pointer in arg. A spilled pointer is first
+// brought into ADDRREG since it can't be dereferenced off the stack.
+func (fasm *FunctionAsm) addressReg(arg tac.TACOpArg, labels []string) (string, []string) {
+	if !fasm.isStackArg(arg) {
+		return fasm.instrParam(arg), labels
 	}
-	width += " ptr "
+	fasm.emitInstr(x86_64Instr{
+		instrName: MOV,
+		params:    []string{ADDRREG.NameForSize(8), fasm.instrParam(arg)},
+		labels:    labels,
+	})
+	return ADDRREG.NameForSize(8), nil
+}
+
+func (fasm *FunctionAsm) genAsmForMemStore(v *tac.MemStoreInstr) {
+	width := memWidth(v.NumBytes)
+	addr, labels := fasm.addressReg(v.StoreAt, v.Labels())
 
+	if f, ok := v.StoreWhat.(*tac.ImmFloatArg); ok {
+		// the bits go through the temp reg, there are no float immediates
+		fasm.emitInstr(x86_64Instr{
+			instrName: MOV,
+			params:    []string{TEMPREG.NameForSize(8), fmt.Sprint(floatBits(f.Num(), f.Category()))},
+			labels:    labels,
+		})
+		fasm.emitInstr(x86_64Instr{
+			instrName: MOV,
+			params:    []string{fmt.Sprintf("%s[%s]", width, addr), TEMPREG.NameForSize(v.NumBytes)},
+		})
+		return
+	}
 	if v.StoreWhat.LocType() == tac.Imm {
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
-			params:    []string{fmt.Sprintf("%s[%s]", width, fasm.instrParam(v.StoreAt)), fasm.instrParam(v.StoreWhat)},
-			labels:    v.Labels(),
+			params:    []string{fmt.Sprintf("%s[%s]", width, addr), fasm.instrParam(v.StoreWhat)},
+			labels:    labels,
 		})
 		return
 	}
 
 	dest := fasm.instrParam(v.StoreWhat)
-	destMapping := fasm.VRegMapping[(v.StoreWhat.(*tac.VRegArg)).RegNo]
-	if destMapping.offset != 0 {
+	if reg := fasm.regOf(v.StoreWhat); reg != nil && reg.isXmm() {
+		fasm.emitInstr(x86_64Instr{
+			instrName: sseName(sseMov, v.StoreWhat.Category()),
+			params:    []string{fmt.Sprintf("%s[%s]", width, addr), dest},
+			labels:    labels,
+		})
+	} else if fasm.isStackArg(v.StoreWhat) {
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
 			params:    []string{string(TEMPREG.NameForSize(v.NumBytes)), dest},
-			labels:    v.Labels(),
+			labels:    labels,
 		})
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
-			params:    []string{fmt.Sprintf("%s[%s]", width, fasm.instrParam(v.StoreAt)), TEMPREG.NameForSize(v.NumBytes)},
+			params:    []string{fmt.Sprintf("%s[%s]", width, addr), TEMPREG.NameForSize(v.NumBytes)},
 		})
 
 	} else {
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
-			params:    []string{fmt.Sprintf("%s[%s]", width, fasm.instrParam(v.StoreAt)), dest},
-			labels:    v.Labels(),
+			params:    []string{fmt.Sprintf("%s[%s]", width, addr), dest},
+			labels:    labels,
 		})
 
 	}
 }
 
 func (fasm *FunctionAsm) genAsmForMemLoad(v *tac.MemLoadInstr) {
-	lfrom := fasm.instrParam(v.LoadFrom)
-	sat := fasm.VRegMapping[(v.StoreAt.(*tac.VRegArg)).RegNo]
+	lfrom, labels := fasm.addressReg(v.LoadFrom, v.Labels())
 
-	if sat.offset != 0 {
+	if fasm.isStackArg(v.StoreAt) {
 		// first load into temp, then move to stack
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
 			params:    []string{TEMPREG.NameForSize(v.NumBytes), fmt.Sprintf("[%s]", lfrom)},
-			labels:    v.Labels(),
+			labels:    labels,
 		})
 		fasm.emitInstr(x86_64Instr{
 			instrName: MOV,
@@ -231,33 +471,72 @@ func (fasm *FunctionAsm) genAsmForMemLoad(v *tac.MemLoadInstr) {
 		})
 
 	} else {
+		name := MOV
+		if fasm.regOf(v.StoreAt).isXmm() {
+			name = sseName(sseMov, v.StoreAt.Category())
+		}
 		fasm.emitInstr(x86_64Instr{
-			instrName: MOV,
+			instrName: name,
 			params:    []string{fasm.instrParam(v.StoreAt), fmt.Sprintf("[%s]", lfrom)},
-			labels:    v.Labels(),
+			labels:    labels,
 		})
 	}
 
 }
 
-func (fasm *FunctionAsm) genAsmForFuncArgRecv(v *tac.FuncArgRecvInstr) {
-	// needs to be set by caller
-}
-
 func (fasm *FunctionAsm) genAsmForAlloc(v *tac.AllocInstr) {
-	if intSize, ok := v.SizeReg.(*tac.ImmIntArg); ok {
+	if offset, ok := fasm.allocOffsets[v.AllocNo]; ok {
+		dest := fasm.instrParam(v.PtrToAlloc)
+		if fasm.isStackArg(v.PtrToAlloc) {
+			dest = TEMPREG.NameForSize(8)
+		}
 		fasm.emitInstr(x86_64Instr{
 			instrName: LEA,
-			params:    []string{fasm.instrParam(v.PtrToAlloc), fmt.Sprintf("alloc_%d_size_%d", v.AllocNo, intSize.Num())},
+			params:    []string{dest, fmt.Sprintf("[rbp - %d]", offset)},
 			labels:    v.Labels(),
 		})
-	} else {
-		// todo
+		if fasm.isStackArg(v.PtrToAlloc) {
+			fasm.emitInstr(x86_64Instr{
+				instrName: MOV,
+				params:    []string{fasm.instrParam(v.PtrToAlloc), dest},
+			})
+		}
+		// every run of the alloc gets zeroed memory, like the interpreter's
+		fasm.zeroFrame(offset, v.SizeReg.(*tac.ImmIntArg).Num())
+		return
+	}
+	if v.AllocType != tac.STACK_ALLOC {
+		panic(fmt.Sprintf("can't lower %s, only stack allocations are supported", v))
 	}
+	fasm.genAsmForDynamicAlloc(v)
 }
 
-func (fasm *FunctionAsm) genAsmForCall(v *tac.CallInstr) {
-
+// the return value goes out in rax, or xmm0 for floating point ones.
+// last is set for the function's final instruction, which falls through
+// into the epilogue.
+func (fasm *FunctionAsm) genAsmForRet(v *tac.FuncRetInstr, last bool) {
+	_, retArg, _ := v.ThreeAdresses()
+	emitted := len(fasm.instrs)
+	switch dc := fasm.ftac.ReturnCategory(); {
+	case (*retArg).LocType() == tac.Null:
+	case dc.IsFloating():
+		fasm.parallelMove([]regMove{fasm.moveInto(XMM0, *retArg)})
+	default:
+		size := (*retArg).Category().SizeBytes()
+		if (*retArg).LocType() == tac.Imm {
+			size = dc.SizeBytes()
+		}
+		fasm.emitInstr(x86_64Instr{
+			instrName: MOV,
+			params:    []string{RAX.NameForSize(size), fasm.instrParam(*retArg)},
+		})
+	}
+	if !last {
+		fasm.emitInstr(x86_64Instr{instrName: JMP, params: []string{fasm.epilogueLabel()}})
+	}
+	if len(fasm.instrs) > emitted {
+		fasm.instrs[emitted].labels = v.Labels()
+	}
 }
 
 func (fasm *FunctionAsm) genAsmForLoopBoundary(v *tac.LoopBoundary) {
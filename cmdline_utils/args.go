@@ -1,21 +1,358 @@
 package cmdlineutils
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"he++/asm_gen"
+	"he++/diagnostics"
+	"he++/tac"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
-func ReadArgs()map[string]string {
+type Command string
+
+const (
+	CHECK  Command = "check"
+	BUILD  Command = "build"
+	RUN    Command = "run"
+	INTERP Command = "interp"
+	LSP    Command = "lsp"
+	FMT    Command = "fmt"
+	HELP   Command = "help"
+)
+
+var commands = map[Command]string{
+	CHECK:  "lex, parse and type check the source file",
+	BUILD:  "compile the source file",
+	RUN:    "compile the source file and execute it",
+	INTERP: "execute the source file on the TAC interpreter",
+	LSP:    "serve the language server protocol over stdio",
+	FMT:    "print the source files in canonical layout",
+	HELP:   "print this help, also --help or -h",
+}
+
+type EmitKind string
+
+const (
+	EMIT_TOKENS EmitKind = "tokens"
+	EMIT_AST    EmitKind = "ast"
+	EMIT_TAC    EmitKind = "tac"
+	EMIT_ASM    EmitKind = "asm"
+)
+
+// order in which the pipeline produces the stages
+var EmitKinds = []EmitKind{EMIT_TOKENS, EMIT_AST, EMIT_TAC, EMIT_ASM}
+
+// path used to denote stdout
+const STDOUT = "-"
+
+type Args struct {
+	Cmd Command
+	Src string
+	// stage dumps requested through --emit, mapped to their output paths
+	Emits map[EmitKind]string
+	// primary output of the command
+	Out string
+	// arguments after `--`, handed to the program by `run`
+	ProgArgs []string
+	// how diagnostics are written to stderr
+	DiagFormat diagnostics.Format
+	// every source file given, fmt takes several
+	Files []string
+	// fmt only lists the files it would change
+	Check bool
+	// fmt rewrites the files in place
+	Write bool
+	// register allocator used by the backend
+	RegAlloc asm_gen.RegAllocator
+	// optimization passes run over the TAC, in order
+	Passes []string
+	// passes after which the TAC is written to stderr
+	PrintAfter []string
+	// the time spent in every pass is written to stderr
+	TimePasses bool
+}
+
+// he++ <command> [flags] <file> [-- program args]
+// the source file falls back to SOURCE_FILE (read from .env too) if absent.
+func ReadArgs() (*Args, error) {
 	godotenv.Load()
-	args := make(map[string]string)
-	
-	// starts from index 2
-	// we first expect the source file
-	// then we expect the flags
-	args["src"] = os.Getenv("SOURCE_FILE")
-	if len(os.Args) > 1 {
-		args["src"] = os.Args[1]
+	return ParseArgs(os.Args[1:])
+}
+
+func ParseArgs(argv []string) (*Args, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("no command given")
+	}
+	args := &Args{Cmd: Command(argv[0]), Emits: make(map[EmitKind]string)}
+	if argv[0] == "--help" || argv[0] == "-h" {
+		args.Cmd = HELP
+	}
+	if _, ok := commands[args.Cmd]; !ok {
+		return nil, fmt.Errorf("unknown command %q", argv[0])
+	}
+	if args.Cmd == HELP {
+		return args, nil
+	}
+
+	fs := flag.NewFlagSet(string(args.Cmd), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	emit := fs.String("emit", "", "")
+	fs.StringVar(&args.Out, "o", "", "")
+	diagFormat := fs.String("diagnostics-format", string(diagnostics.TEXT), "")
+	fs.BoolVar(&args.Check, "check", false, "")
+	fs.BoolVar(&args.Write, "write", false, "")
+	regAlloc := fs.String("regalloc", string(asm_gen.LINEAR_SCAN), "")
+	// -O0, -O1, ... are flags of their own to the flag package
+	levels := make([]*bool, len(tac.OptLevels))
+	for i := range levels {
+		levels[i] = fs.Bool(fmt.Sprintf("O%d", i), false, "")
 	}
-	return args
+	passes := fs.String("passes", "", "")
+	disabled := fs.String("disable-pass", "", "")
+	printAfter := fs.String("print-after", "", "")
+	fs.BoolVar(&args.TimePasses, "time-passes", false, "")
+
+	argv = argv[1:]
+	for i := range argv {
+		if argv[i] == "--" {
+			args.ProgArgs = argv[i+1:]
+			argv = argv[:i]
+			break
+		}
+	}
+	positional, err := parseInterspersed(fs, argv)
+	// `he++ build --help` asks for the help as well
+	if errors.Is(err, flag.ErrHelp) {
+		return &Args{Cmd: HELP}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the language server is handed its files by the editor
+	if args.Cmd == LSP {
+		if len(positional) > 0 {
+			return nil, fmt.Errorf("lsp takes no source file, got %s", strings.Join(positional, " "))
+		}
+		return args, nil
+	}
+	switch {
+	case len(positional) == 0:
+		args.Src = os.Getenv("SOURCE_FILE")
+		if args.Src == "" {
+			return nil, errors.New("no source file given")
+		}
+		positional = []string{args.Src}
+	case len(positional) == 1 || args.Cmd == FMT:
+		args.Src = positional[0]
+	default:
+		return nil, fmt.Errorf("expected a single source file, got %s", strings.Join(positional, " "))
+	}
+	args.Files = positional
+	if (args.Check || args.Write) && args.Cmd != FMT {
+		return nil, errors.New("--check and --write only apply to fmt")
+	}
+	if args.Check && args.Write {
+		return nil, errors.New("--check and --write can't be used together")
+	}
+
+	if err := args.readEmits(*emit); err != nil {
+		return nil, err
+	}
+	args.DiagFormat = diagnostics.Format(*diagFormat)
+	if !isDiagFormat(args.DiagFormat) {
+		return nil, fmt.Errorf("unknown diagnostics format %q, expected one of %s", *diagFormat, diagFormatList())
+	}
+	args.RegAlloc = asm_gen.RegAllocator(*regAlloc)
+	if !slices.Contains(asm_gen.RegAllocators, args.RegAlloc) {
+		return nil, fmt.Errorf("unknown register allocator %q, expected one of %s", *regAlloc, regAllocList())
+	}
+	passesGiven, disableGiven := false, false
+	fs.Visit(func(f *flag.Flag) {
+		passesGiven = passesGiven || f.Name == "passes"
+		disableGiven = disableGiven || f.Name == "disable-pass"
+	})
+	// --passes lists every pass that runs, there is nothing to leave out
+	if passesGiven && disableGiven {
+		return nil, errors.New("--passes and --disable-pass can't be used together")
+	}
+	if err := args.readPasses(levels, passesGiven, *passes, *disabled, *printAfter); err != nil {
+		return nil, err
+	}
+	return args, nil
 }
+
+// the passes of the -O level, or those listed by --passes, less the
+// disabled ones
+func (a *Args) readPasses(levels []*bool, passesGiven bool, passes, disabled, printAfter string) error {
+	level := -1
+	for i, set := range levels {
+		if !*set {
+			continue
+		}
+		if level != -1 {
+			return fmt.Errorf("-O%d and -O%d can't be used together", level, i)
+		}
+		level = i
+	}
+	switch {
+	case passesGiven && level != -1:
+		return fmt.Errorf("--passes and -O%d can't be used together", level)
+	case passesGiven:
+		a.Passes = splitList(passes)
+	case level != -1:
+		a.Passes = slices.Clone(tac.OptLevels[level])
+	default:
+		a.Passes = slices.Clone(tac.OptLevels[tac.DEFAULT_OPT_LEVEL])
+	}
+	a.PrintAfter = splitList(printAfter)
+	off := splitList(disabled)
+	for _, name := range slices.Concat(a.Passes, a.PrintAfter, off) {
+		if tac.LookupPass(name) == nil {
+			return fmt.Errorf("unknown pass %q, expected one of %s", name, tac.PassList())
+		}
+	}
+	a.Passes = slices.DeleteFunc(a.Passes, func(name string) bool { return slices.Contains(off, name) })
+	return nil
+}
+
+// comma separated, empty entries left out
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// the flag package stops at the first positional arg, but we want
+// `he++ build foo.lg -o foo` to work as well.
+func parseInterspersed(fs *flag.FlagSet, argv []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(argv); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		argv = fs.Args()[1:]
+	}
+}
+
+// --emit=kind[=path][,kind[=path]...]
+// a kind without a path goes to stdout, except for `check` where the
+// single pathless kind may be directed with -o.
+func (a *Args) readEmits(emit string) error {
+	if emit == "" {
+		if a.Cmd == CHECK && a.Out != "" {
+			return errors.New("-o needs an --emit stage for check")
+		}
+		return nil
+	}
+	pathless := make([]EmitKind, 0)
+	for _, entry := range strings.Split(emit, ",") {
+		kind, path, hasPath := strings.Cut(entry, "=")
+		k := EmitKind(kind)
+		if !isEmitKind(k) {
+			return fmt.Errorf("unknown emit stage %q, expected one of %s", kind, emitKindList())
+		}
+		if !hasPath || path == "" {
+			path = STDOUT
+			pathless = append(pathless, k)
+		}
+		a.Emits[k] = path
+	}
+	if a.Cmd == CHECK && a.Out != "" {
+		if len(pathless) != 1 {
+			return errors.New("-o with check needs exactly one --emit stage without a path")
+		}
+		a.Emits[pathless[0]] = a.Out
+	}
+	return nil
+}
+
+func isEmitKind(k EmitKind) bool {
+	for _, kind := range EmitKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+func emitKindList() string {
+	names := make([]string, len(EmitKinds))
+	for i, k := range EmitKinds {
+		names[i] = string(k)
+	}
+	return strings.Join(names, "|")
+}
+
+func isDiagFormat(f diagnostics.Format) bool {
+	for _, format := range diagnostics.Formats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+func diagFormatList() string {
+	names := make([]string, len(diagnostics.Formats))
+	for i, f := range diagnostics.Formats {
+		names[i] = string(f)
+	}
+	return strings.Join(names, "|")
+}
+
+func regAllocList() string {
+	names := make([]string, len(asm_gen.RegAllocators))
+	for i, r := range asm_gen.RegAllocators {
+		names[i] = string(r)
+	}
+	return strings.Join(names, "|")
+}
+
+func PrintUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: he++ <command> [flags] <file> [-- program args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, c := range []Command{CHECK, BUILD, RUN, INTERP, FMT, LSP, HELP} {
+		fmt.Fprintf(w, "  %-8s%s\n", c, commands[c])
+	}
+	fmt.Fprintln(w, "\nflags:")
+	fmt.Fprintf(w, "  --emit=<stage>[=path],...  dump pipeline stages (%s), to stdout unless a path is given\n", emitKindList())
+	fmt.Fprintln(w, "  -o <path>                  output path of the command")
+	fmt.Fprintf(w, "  --diagnostics-format=<f>   how errors and warnings are written to stderr (%s)\n", diagFormatList())
+	fmt.Fprintf(w, "  --regalloc=<allocator>     register allocator of the backend (%s), linear by default\n", regAllocList())
+	fmt.Fprintf(w, "  -O0, -O1, -O2              how much the TAC is optimized, -O%d by default\n", tac.DEFAULT_OPT_LEVEL)
+	fmt.Fprintf(w, "  --passes=<pass>,...        run these passes instead of those of the -O level (%s)\n", tac.PassList())
+	fmt.Fprintln(w, "  --disable-pass=<pass>,...  leave these passes out of the -O level, not with --passes")
+	fmt.Fprintln(w, "  --print-after=<pass>,...   write the TAC to stderr after these passes")
+	fmt.Fprintln(w, "  --time-passes              write the time spent in every pass to stderr")
+	fmt.Fprintln(w, "  --check                    fmt: list the files that aren't formatted, failing if there are any")
+	fmt.Fprintln(w, "  --write                    fmt: rewrite the files in place instead of printing them")
+}
+
+// opens the path for writing, with "-" standing for stdout
+func OpenOutput(path string) (io.WriteCloser, error) {
+	if path == STDOUT || path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,103 @@
+package cmdlineutils
+
+import (
+	"he++/asm_gen"
+	"he++/diagnostics"
+	"he++/tac"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	t.Setenv("SOURCE_FILE", "")
+
+	t.Run("Accepted", func(t *testing.T) {
+		for _, c := range []struct {
+			argv string
+			ok   func(a *Args) bool
+		}{
+			// flags may come after the source file
+			{"build foo.lg -o foo", func(a *Args) bool { return a.Cmd == BUILD && a.Src == "foo.lg" && a.Out == "foo" }},
+			{"build -o foo foo.lg", func(a *Args) bool { return a.Src == "foo.lg" && a.Out == "foo" }},
+			// everything after -- goes to the program, flags included
+			{"run foo.lg -- a -o b", func(a *Args) bool {
+				return a.Src == "foo.lg" && a.Out == "" && slices.Equal(a.ProgArgs, []string{"a", "-o", "b"})
+			}},
+			{"run foo.lg --", func(a *Args) bool { return len(a.ProgArgs) == 0 }},
+			{"build foo.lg --emit=tokens,tac=foo.tac", func(a *Args) bool {
+				return len(a.Emits) == 2 && a.Emits[EMIT_TOKENS] == STDOUT && a.Emits[EMIT_TAC] == "foo.tac"
+			}},
+			// check sends its single stage without a path to -o
+			{"check foo.lg --emit=asm -o foo.s", func(a *Args) bool { return a.Emits[EMIT_ASM] == "foo.s" }},
+			{"check foo.lg --emit=ast,asm=foo.s -o foo.ast", func(a *Args) bool {
+				return a.Emits[EMIT_AST] == "foo.ast" && a.Emits[EMIT_ASM] == "foo.s"
+			}},
+			{"fmt a.lg b.lg --check", func(a *Args) bool { return a.Check && slices.Equal(a.Files, []string{"a.lg", "b.lg"}) }},
+			{"fmt a.lg --write", func(a *Args) bool { return a.Write }},
+			{"build foo.lg", func(a *Args) bool {
+				return a.RegAlloc == asm_gen.LINEAR_SCAN && a.DiagFormat == diagnostics.TEXT && slices.Equal(a.Passes, tac.OptLevels[tac.DEFAULT_OPT_LEVEL])
+			}},
+			{"build foo.lg --regalloc=graph", func(a *Args) bool { return a.RegAlloc == asm_gen.GRAPH_COLORING }},
+			{"check foo.lg --diagnostics-format=sarif", func(a *Args) bool { return a.DiagFormat == diagnostics.SARIF }},
+			{"build foo.lg -O1", func(a *Args) bool { return slices.Equal(a.Passes, tac.OptLevels[1]) }},
+			{"build foo.lg -O0", func(a *Args) bool { return len(a.Passes) == 0 }},
+			{"build foo.lg --passes=prune,propagate", func(a *Args) bool { return slices.Equal(a.Passes, []string{"prune", "propagate"}) }},
+			{"build foo.lg --passes=", func(a *Args) bool { return len(a.Passes) == 0 }},
+			{"build foo.lg -O1 --disable-pass=prune", func(a *Args) bool { return slices.Equal(a.Passes, []string{"simplify"}) }},
+			{"build foo.lg --print-after=prune --time-passes", func(a *Args) bool {
+				return slices.Equal(a.PrintAfter, []string{"prune"}) && a.TimePasses
+			}},
+			{"lsp", func(a *Args) bool { return a.Cmd == LSP }},
+			{"help", func(a *Args) bool { return a.Cmd == HELP }},
+			{"--help", func(a *Args) bool { return a.Cmd == HELP }},
+			{"-h", func(a *Args) bool { return a.Cmd == HELP }},
+			{"build foo.lg --help", func(a *Args) bool { return a.Cmd == HELP }},
+		} {
+			a, err := ParseArgs(strings.Fields(c.argv))
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.argv, err)
+			} else if !c.ok(a) {
+				t.Errorf("%s: unexpected args %+v", c.argv, a)
+			}
+		}
+	})
+
+	t.Run("Source file from the environment", func(t *testing.T) {
+		t.Setenv("SOURCE_FILE", "env.lg")
+		if a, err := ParseArgs([]string{"build"}); err != nil || a.Src != "env.lg" {
+			t.Errorf("expected env.lg, got %+v, %v", a, err)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		for _, c := range []struct {
+			argv, err string
+		}{
+			{"", "no command given"},
+			{"compile foo.lg", `unknown command "compile"`},
+			{"build", "no source file given"},
+			{"build a.lg b.lg", "expected a single source file"},
+			{"lsp foo.lg", "lsp takes no source file"},
+			{"build foo.lg --bogus", "flag provided but not defined"},
+			{"check foo.lg -o out", "-o needs an --emit stage for check"},
+			{"check foo.lg --emit=tokens,tac -o out", "exactly one --emit stage without a path"},
+			{"check foo.lg --emit=bytes", `unknown emit stage "bytes"`},
+			{"build foo.lg --check", "--check and --write only apply to fmt"},
+			{"fmt a.lg --check --write", "can't be used together"},
+			{"check foo.lg --diagnostics-format=xml", `unknown diagnostics format "xml"`},
+			{"build foo.lg --regalloc=magic", `unknown register allocator "magic"`},
+			{"build foo.lg -O1 -O2", "-O1 and -O2 can't be used together"},
+			{"build foo.lg --passes=prune -O1", "--passes and -O1 can't be used together"},
+			{"build foo.lg --passes=prune --disable-pass=prune", "--passes and --disable-pass can't be used together"},
+			{"build foo.lg --passes=inline", `unknown pass "inline"`},
+			{"build foo.lg --disable-pass=inline", `unknown pass "inline"`},
+			{"build foo.lg --print-after=inline", `unknown pass "inline"`},
+		} {
+			_, err := ParseArgs(strings.Fields(c.argv))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: expected an error with %q, got %v", c.argv, c.err, err)
+			}
+		}
+	})
+}
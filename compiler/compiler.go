@@ -0,0 +1,268 @@
+package compiler
+
+import (
+	"fmt"
+	"he++/asm_gen"
+	"he++/diagnostics"
+	"he++/lexer"
+	"he++/parser"
+	"he++/parser/node_types"
+	staticanalyzer "he++/static_analyzer"
+	"he++/tac"
+	"he++/utils"
+	"io"
+	"runtime/debug"
+	"strings"
+)
+
+// the pipeline, in order
+type Stage string
+
+const (
+	LEX     Stage = "lex"
+	PARSE   Stage = "parse"
+	ANALYZE Stage = "analyze"
+	TAC     Stage = "tac"
+	ASM     Stage = "asm"
+)
+
+var stageOrder = map[Stage]int{LEX: 0, PARSE: 1, ANALYZE: 2, TAC: 3, ASM: 4}
+
+type Options struct {
+	// name of the source, used in the AST and diagnostics
+	Path string
+	// last stage to run, all of them if empty
+	StopAfter Stage
+	// receives debug traces of the middle and back end, discarded if nil
+	Debug io.Writer
+	// register allocator of the backend, linear scan if empty
+	RegAlloc asm_gen.RegAllocator
+	// skips the TAC optimizations, the same as -O0
+	Unoptimized bool
+	// optimization passes run over the TAC in order, those of the default
+	// -O level if nil
+	Passes []string
+	// the TAC of every function is written to PassLog after these passes
+	PrintAfter []string
+	// the time spent in every pass is written to PassLog
+	TimePasses bool
+	PassLog    io.Writer
+}
+
+// panics on passes that don't exist, callers taking them from users
+// should check them with tac.LookupPass first
+func (o *Options) passManager() *tac.PassManager {
+	names := o.Passes
+	if o.Unoptimized {
+		names = tac.OptLevels[0]
+	} else if names == nil {
+		names = tac.OptLevels[tac.DEFAULT_OPT_LEVEL]
+	}
+	pm, err := tac.NewPassManager(names)
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range o.PrintAfter {
+		if tac.LookupPass(name) == nil {
+			panic(fmt.Sprintf("unknown pass %q to print after", name))
+		}
+	}
+	pm.PrintAfter = o.PrintAfter
+	if o.PassLog != nil {
+		pm.Log = o.PassLog
+	}
+	return pm
+}
+
+func (o *Options) runs(s Stage) bool {
+	if o.StopAfter == "" {
+		return true
+	}
+	return stageOrder[s] <= stageOrder[o.StopAfter]
+}
+
+// whatever the stages that ran produced
+type Result struct {
+	Tokens   []lexer.LexerToken
+	Comments []lexer.LexerToken
+	AST      *node_types.SourceFileNode
+	// symbols and types found by the analyzer, also when it reported errors
+	Info *staticanalyzer.SourceInfo
+	// per function, in source order
+	Functions []*tac.FunctionTAC
+	// complete assembly file
+	Asm string
+}
+
+func (r *Result) HasFunction(name string) bool {
+	for _, f := range r.Functions {
+		if f.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runs the pipeline over source up to opts.StopAfter. Compilation stops
+// at the first stage reporting errors; the result holds what was produced
+// until then. Panics inside a stage are reported as internal compiler
+// errors instead of crashing the caller.
+// Every diagnostic has its Stage set.
+func Compile(source string, opts Options) (*Result, []*diagnostics.Diagnostic) {
+	c := &compilation{opts: opts, res: &Result{}, diags: make([]*diagnostics.Diagnostic, 0)}
+	c.run(source)
+	return c.res, c.diags
+}
+
+type compilation struct {
+	opts  Options
+	res   *Result
+	diags []*diagnostics.Diagnostic
+}
+
+func (c *compilation) report(stage Stage, diags ...*diagnostics.Diagnostic) {
+	for _, d := range diags {
+		d.Stage = string(stage)
+		c.diags = append(c.diags, d)
+	}
+}
+
+func (c *compilation) run(source string) {
+	res, opts := c.res, c.opts
+
+	lex := lexer.NewLexer(opts.Path, source)
+	// written by the lexer goroutine before it closes TokChan
+	var lexPanic *diagnostics.Diagnostic
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				lexPanic = internalError(LEX, r, fmt.Sprintf("%s:%d", opts.Path, lex.CurrentLine()))
+				close(lex.TokChan)
+			}
+		}()
+		lex.Lexify()
+	}()
+
+	var ast *node_types.SourceFileNode
+	parsed := true
+	if opts.runs(PARSE) {
+		var p *parser.Parser
+		parsed = c.guard(PARSE, func() string {
+			return fmt.Sprintf("%s:%d", opts.Path, p.CurrentLine())
+		}, func() {
+			p = parser.NewParser(lex)
+			ast = p.ParseAST()
+			c.report(PARSE, p.Diagnostics()...)
+		})
+	}
+	// let the lexer run to completion instead of blocking on the channel
+	for range lex.TokChan {
+	}
+	res.Tokens = lex.GetTokens()
+	res.Comments = lex.Comments()
+
+	// whatever the parser made of a broken token stream isn't worth reporting
+	if lexPanic != nil {
+		c.diags = []*diagnostics.Diagnostic{lexPanic}
+		return
+	}
+	parseDiags := c.diags
+	c.diags = make([]*diagnostics.Diagnostic, 0)
+	c.report(LEX, lex.Diagnostics()...)
+	if diagnostics.HasErrors(c.diags) {
+		return
+	}
+	c.diags = append(c.diags, parseDiags...)
+	if !parsed || !opts.runs(PARSE) {
+		return
+	}
+	// with syntax errors, the AST has ErrorNodes in place of what couldn't
+	// be parsed. Analyzing it would only pile up follow-up errors.
+	res.AST = ast
+	if diagnostics.HasErrors(c.diags) || !opts.runs(ANALYZE) {
+		return
+	}
+
+	analyzer := staticanalyzer.MakeAnalyzer()
+	analyzed := c.guard(ANALYZE, func() string {
+		return fmt.Sprintf("%s:%d", opts.Path, analyzer.CurrentLine())
+	}, func() {
+		if ok := analyzer.AnalyzeAST(ast); !ok {
+			c.report(ANALYZE, analyzer.Errs...)
+		}
+		res.Info = &analyzer.Info
+	})
+	if !analyzed || diagnostics.HasErrors(c.diags) || !opts.runs(TAC) {
+		return
+	}
+
+	tacHandler := tac.NewTACGen(ast)
+	tacHandler.Debug = opts.Debug
+	generated := c.guard(TAC, func() string {
+		fname, line := tacHandler.CurrentLocation()
+		return fmt.Sprintf("%s:%d, in function %s", opts.Path, line, fname)
+	}, func() {
+		tacHandler.Passes = opts.passManager()
+		tacHandler.GenerateTac()
+		res.Functions = tacHandler.Functions()
+		if opts.TimePasses && opts.PassLog != nil {
+			tacHandler.Passes.WriteTimings(opts.PassLog)
+		}
+	})
+	if !generated || !opts.runs(ASM) {
+		return
+	}
+
+	asmGen := asm_gen.NewAsmGen(tacHandler)
+	asmGen.Debug = opts.Debug
+	asmGen.RegAlloc = opts.RegAlloc
+	c.guard(ASM, func() string {
+		return fmt.Sprintf("%s, in function %s", opts.Path, asmGen.CurrentFunction())
+	}, func() {
+		asmGen.GenerateAsm()
+		var sb strings.Builder
+		asmGen.WriteAsmFile(&sb)
+		res.Asm = sb.String()
+	})
+}
+
+// runs one stage and reports whether it completed. The parser reports
+// syntax errors by panicking with a *diagnostics.Diagnostic, any other panic
+// is a bug in the compiler. where is only called after a panic, to tell
+// how far into the source the stage got.
+func (c *compilation) guard(stage Stage, where func() string, run func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		ok = false
+		if d, isDiag := r.(*diagnostics.Diagnostic); isDiag {
+			c.report(stage, d)
+			return
+		}
+		if c.opts.Debug != nil {
+			c.opts.Debug.Write(debug.Stack())
+		}
+		c.report(stage, internalError(stage, r, where()))
+	}()
+	run()
+	return true
+}
+
+// TAC of every function in the textual format tac.ParseText reads
+func (r *Result) DumpTAC(w io.Writer) {
+	tac.WriteText(w, r.Functions)
+}
+
+// the tree as printed by the nodes themselves
+func (r *Result) DumpAST(w io.Writer) {
+	p := utils.MakeASTPrinter()
+	r.AST.String(&p)
+	fmt.Fprint(w, p.Builder.String())
+}
+
+// one token per line, as produced by Lexer.DumpTokens
+func (r *Result) DumpTokens(w io.Writer) {
+	lexer.WriteTokens(w, r.Tokens)
+}
@@ -0,0 +1,430 @@
+package compiler_test
+
+import (
+	"fmt"
+	"he++/asm_gen"
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/difftest"
+	"he++/parser/node_types"
+	"he++/tac"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	t.Run("All stages", func(t *testing.T) {
+		res, diags := compiler.Compile("funcion principal() int {\n definir int a = 4\n devolver a * 2\n}", compiler.Options{Path: "test.lg"})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		if len(res.Tokens) == 0 || res.AST == nil {
+			t.Fatalf("front end results missing")
+		}
+		if !res.HasFunction("principal") {
+			t.Errorf("expected TAC for principal")
+		}
+		if !strings.Contains(res.Asm, "principal:") {
+			t.Errorf("expected asm for principal, got\n%s", res.Asm)
+		}
+	})
+
+	t.Run("Stops after requested stage", func(t *testing.T) {
+		res, _ := compiler.Compile("funcion principal() int {\n devolver 1\n}", compiler.Options{StopAfter: compiler.ANALYZE})
+		if res.AST == nil || res.Functions != nil || res.Asm != "" {
+			t.Errorf("expected only the front end to run")
+		}
+	})
+
+	t.Run("Syntax error", func(t *testing.T) {
+		res, diags := compiler.Compile("funcion principal() int {\n definir int a = \n}", compiler.Options{})
+		if !diagnostics.HasErrors(diags) || diags[len(diags)-1].Stage != string(compiler.PARSE) {
+			t.Fatalf("expected a parse diagnostic, got %v", diags)
+		}
+		if res.AST == nil || res.Functions != nil {
+			t.Fatalf("expected only the AST after a syntax error")
+		}
+	})
+
+	t.Run("Missing type", func(t *testing.T) {
+		// at the end of the file, right after definir, and a number in its place
+		for _, c := range []struct {
+			source, msg string
+			col         int
+		}{
+			{"funcion principal() int {\n definir", "Unexpected end of file while parsing a type", 9},
+			{"funcion principal() int {\n definir 3 a = 2\n}", "Couldn't parse type: 3", 10},
+		} {
+			_, diags := compiler.Compile(c.source, compiler.Options{StopAfter: compiler.PARSE})
+			if len(diags) == 0 || diags[0].Msg != c.msg || diags[0].Line() != 2 || diags[0].Span.Col != c.col {
+				t.Errorf("expected %q at 2:%d, got %v", c.msg, c.col, diags)
+			}
+		}
+	})
+
+	t.Run("Reports every syntax error", func(t *testing.T) {
+		source := "funcion f() int {\n definir int a = \n devolver 1\n}\n" +
+			"funcion g() int {\n si 1 + entonces { devolver 2 }\n devolver 3\n}\n" +
+			"}\n" +
+			"funcion principal() int {\n devolver 4\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		lines := make([]int, 0)
+		for _, d := range diags {
+			lines = append(lines, d.Line())
+		}
+		if len(lines) != 3 || lines[0] != 3 || lines[1] != 6 || lines[2] != 9 {
+			t.Fatalf("expected syntax errors at lines 3, 6 and 9, got %v", diags)
+		}
+		if len(res.AST.Children) != 3 {
+			t.Fatalf("expected all three functions in the AST, got %d nodes", len(res.AST.Children))
+		}
+		g := res.AST.Children[1].(*node_types.FuncNode)
+		if _, ok := g.Scope.Children[0].(*node_types.ErrorNode); !ok || len(g.Scope.Children) != 2 {
+			t.Errorf("expected an error node followed by the return in g, got %v", g.Scope.Children)
+		}
+	})
+
+	t.Run("Spans", func(t *testing.T) {
+		res, diags := compiler.Compile("funcion principal() int {\n devolver 1 + x\n}", compiler.Options{Path: "test.lg", StopAfter: compiler.ANALYZE})
+		if len(diags) == 0 {
+			t.Fatalf("expected a diagnostic for x")
+		}
+		if span := diags[0].Span; span.File != "test.lg" || span.Line != 2 || span.Col != 15 || span.Start != 40 || span.End != 41 {
+			t.Errorf("expected the diagnostic at x, got %+v", span)
+		}
+		fn := res.AST.Children[0]
+		if span := fn.Span(); span.Line != 1 || span.Col != 1 || span.EndLine != 3 || span.End != 43 {
+			t.Errorf("expected the function to span the whole source, got %+v", span)
+		}
+	})
+
+	t.Run("Type error", func(t *testing.T) {
+		_, diags := compiler.Compile("funcion principal() int {\n devolver verdad\n}", compiler.Options{})
+		if !diagnostics.HasErrors(diags) || diags[0].Stage != string(compiler.ANALYZE) {
+			t.Fatalf("expected an analyzer diagnostic, got %v", diags)
+		}
+	})
+
+	t.Run("Ternary", func(t *testing.T) {
+		res, diags := compiler.Compile("funcion principal() int {\n definir int a = 4\n devolver a > 2 ? a : 0\n}", compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		var sb strings.Builder
+		res.Functions[0].Dump(&sb)
+		if !strings.Contains(sb.String(), "jmp_if_false") || !strings.Contains(res.Asm, "_else:") {
+			t.Errorf("expected the ternary to branch, got\n%s\n%s", sb.String(), res.Asm)
+		}
+
+		for _, expr := range []string{"verdad ? 1 : falso", "1 ? 1 : 2"} {
+			_, diags := compiler.Compile("funcion principal() int {\n devolver "+expr+"\n}", compiler.Options{StopAfter: compiler.ANALYZE})
+			if len(diags) == 0 || diags[0].Kind != diagnostics.TypeError {
+				t.Errorf("expected a type error for %s, got %v", expr, diags)
+			}
+		}
+		// the ternary in error isn't reported again by the declaration
+		_, diags = compiler.Compile("funcion principal() int {\n definir int a = verdad ? 1 : falso\n devolver a\n}", compiler.Options{StopAfter: compiler.ANALYZE})
+		if len(diags) != 1 {
+			t.Errorf("expected a single type error, got %v", diags)
+		}
+
+		// an int arm is converted when the other is a float
+		source := "funcion f(c bool, n int) float {\n devolver c ? n : 2.5\n}\n" +
+			"funcion principal() int {\n definir bool c = verdad\n definir float x = c ? 1 : 2.5, y = !c ? 1 : 2.5\n" +
+			" definir int r = 0\n r = (x + y) * 2 + f(verdad, 3) * 10 + f(falso, 3) * 2\n devolver r\n}"
+		expectReturns(t, source, (1+2.5)*2+3*10+2.5*2)
+	})
+
+	t.Run("Unary operators", func(t *testing.T) {
+		source := "funcion principal() int {\n definir int a = 5\n definir &int p = &a\n" +
+			" *p = *p + 2\n definir int b = a++\n --a\n" +
+			" definir bool t = !(a < 3) && !falso\n devolver t ? -a * b : 0\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		var sb strings.Builder
+		res.Functions[0].Dump(&sb)
+		// a lives in memory since p points at it
+		if !strings.Contains(sb.String(), "store") || !strings.Contains(sb.String(), "loadfrom") {
+			t.Errorf("expected a to be read and written through memory, got\n%s", sb.String())
+		}
+		for _, instr := range []string{"neg ", "lea "} {
+			if !strings.Contains(res.Asm, instr) {
+				t.Errorf("expected %s in\n%s", instr, res.Asm)
+			}
+		}
+
+		for _, stmt := range []string{"definir bool b = !1", "definir int b = 3++", "definir &int b = &3"} {
+			_, diags := compiler.Compile("funcion principal() int {\n "+stmt+"\n devolver 0\n}", compiler.Options{StopAfter: compiler.ANALYZE})
+			if len(diags) == 0 || diags[0].Kind != diagnostics.TypeError {
+				t.Errorf("expected a type error for %s, got %v", stmt, diags)
+			}
+		}
+	})
+
+	t.Run("Short circuit", func(t *testing.T) {
+		// f adds to what p points at, so the calls that are skipped show in
+		// the result. Each operator skips a call to f before the last one.
+		source := "funcion f(p &int, n int) int {\n (*p) = (*p) + n\n devolver n\n}\n" +
+			"funcion principal() int {\n definir int a = 4, calls = 0\n definir &int p = &calls\n" +
+			" definir bool b = a < 0 && f(p, 1) > 0\n" +
+			" si b || f(p, 2) == 2 entonces {\n  calls = calls + 10\n }\n" +
+			" si verdad || f(p, 100) > 0 entonces {\n  calls = calls + 20\n }\n" +
+			" definir int c = a > 0 ? f(p, 3) : f(p, 1000)\n" +
+			" devolver calls + f(p, 40)\n}"
+		// calls is read before the last call adds to it
+		expectReturns(t, source, 2+10+20+3+40)
+	})
+
+	t.Run("Arrays sized when running", func(t *testing.T) {
+		// a fresh, zeroed array on every turn of the loop, passed to a call
+		source := "funcion suma(a [int], n int) int {\n definir int s = 0\n" +
+			" para definir int i = 0; i < n; i++ {\n  s = s + a[i]\n }\n devolver s\n}\n" +
+			"funcion principal() int {\n definir int t = 0\n" +
+			" para definir int n = 1; n < 6; n++ {\n  definir [int] a = [int][n * 3]\n  a[n] = n\n  t = t + suma(a, n * 3)\n }\n" +
+			" devolver t\n}"
+		expectReturns(t, source, 1+2+3+4+5)
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		source := "funcion f(a int, b int, c int, d int, e int, g int, h int, i int) int {\n devolver a + i\n}\n" +
+			"funcion principal() int {\n definir int x = 5\n devolver f(1, 2, 3, 4, 5, 6, x, f(x, 1, 1, 1, 1, 1, 1, 1))\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		for _, instr := range []string{"call rax", "mov rdi, 1", "mov r9, 6", "ptr [rsp + 8]", "ptr [rbp + 24]"} {
+			if !strings.Contains(res.Asm, instr) {
+				t.Errorf("expected %s in\n%s", instr, res.Asm)
+			}
+		}
+		// the prologue aligns rsp and the stack arguments keep it so
+		frames := regexp.MustCompile(`sub rsp, (\d+)`).FindAllStringSubmatch(res.Asm, -1)
+		if len(frames) < 2 {
+			t.Fatalf("expected the stack arguments to get room in\n%s", res.Asm)
+		}
+		for _, frame := range frames {
+			if n, _ := strconv.Atoi(frame[1]); n%16 != 0 {
+				t.Errorf("frame of %d bytes misaligns the stack", n)
+			}
+		}
+	})
+
+	t.Run("Frames", func(t *testing.T) {
+		source := "funcion f(a int) int {\n si a > 0 entonces {\n devolver 1\n }\n devolver 2\n}\n" +
+			"funcion principal() int {\n devolver f(3) * 10 + f(0)\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		for _, fn := range []string{"f", "principal"} {
+			body := res.Asm[strings.Index(res.Asm, fn+":\n"):]
+			if !strings.HasPrefix(body, fn+":\n\tpush rbp\n\tmov rbp, rsp\n") {
+				t.Errorf("expected %s to set up rbp, got\n%s", fn, body)
+			}
+			if !strings.Contains(body, fn+"_epilogue:") {
+				t.Errorf("expected an epilogue for %s in\n%s", fn, body)
+			}
+		}
+		// the early return jumps to the epilogue, the last one falls into it
+		if n := strings.Count(res.Asm, "jmp f_epilogue"); n != 1 {
+			t.Errorf("expected one jump to the epilogue, got %d in\n%s", n, res.Asm)
+		}
+		if n := strings.Count(res.Asm, "\tret"); n != 2 {
+			t.Errorf("expected a single ret per function, got %d in\n%s", n, res.Asm)
+		}
+	})
+
+	t.Run("Floating point", func(t *testing.T) {
+		source := "funcion f(a float, n int) float {\n definir float x = 0.0\n x = n\n" +
+			" si a < x entonces {\n devolver a * 1.5\n }\n devolver x - a\n}\n" +
+			"funcion principal() int {\n definir int r = 0\n r = f(2.5, 4)\n devolver r\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		var sb strings.Builder
+		res.Functions[0].Dump(&sb)
+		if !strings.Contains(sb.String(), "conv f32") {
+			t.Errorf("expected n to be converted, got\n%s", sb.String())
+		}
+		for _, instr := range []string{"cvtsi2ss xmm", "ucomiss xmm", "mulss xmm", "subss xmm", "cvttss2si", "movss xmm", "dword ptr [rip + principal_float_", ".section .rodata"} {
+			if !strings.Contains(res.Asm, instr) {
+				t.Errorf("expected %s in\n%s", instr, res.Asm)
+			}
+		}
+		// the constant 1.5 as a float32
+		if !strings.Contains(res.Asm, ".long 0x3fc00000") {
+			t.Errorf("expected 1.5 in .rodata, got\n%s", res.Asm)
+		}
+	})
+
+	t.Run("Division and shifts", func(t *testing.T) {
+		source := "funcion f(a int, b int, c int) int {\n definir int q = a / b, r = a % c\n" +
+			" devolver (q << c) + (r >> b) + a + b + c\n}\n" +
+			"funcion principal() int {\n devolver f(0 - 17, 5, 3) + 7 / 2 + 7 % 3 + (1 << 4) + (0 - 9) / 2\n}"
+		res, diags := compiler.Compile(source, compiler.Options{})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		for _, instr := range []string{"cdq", "idiv"} {
+			if !strings.Contains(res.Asm, instr) {
+				t.Errorf("expected %s in\n%s", instr, res.Asm)
+			}
+		}
+		// the count of a shift has to be in cl
+		if !regexp.MustCompile(`shl \w+, cl`).MatchString(res.Asm) || !regexp.MustCompile(`sar \w+, cl`).MatchString(res.Asm) {
+			t.Errorf("expected shifts by cl in\n%s", res.Asm)
+		}
+		body := res.Asm[strings.Index(res.Asm, "f:\n"):strings.Index(res.Asm, "f_epilogue:")]
+		if strings.Count(body, "idiv") != 2 {
+			t.Errorf("expected a division for each of / and %%, got\n%s", body)
+		}
+		var sb strings.Builder
+		res.Functions[1].Dump(&sb)
+		// constants fold with integer division, rounding towards zero
+		if !strings.Contains(sb.String(), "#-4") || strings.Contains(sb.String(), "#3.5") {
+			t.Errorf("expected the constants to be folded as integers, got\n%s", sb.String())
+		}
+	})
+
+	t.Run("Graph coloring", func(t *testing.T) {
+		phase := func(v, from string) string {
+			decl, sum := make([]string, 16), ""
+			for i := range decl {
+				name := strings.Repeat(string(rune('b'+i)), 2) + v
+				decl[i] = fmt.Sprintf("%s = %s + %d", name, from, i+1)
+				if i%2 == 0 {
+					sum += " + " + name
+				} else {
+					sum += " - " + name
+				}
+			}
+			return " definir int " + strings.Join(decl, ", ") + "\n definir int " + v + " = 0" + sum + "\n"
+		}
+		source := "funcion fases(a int) int {\n" + phase("x", "a") + phase("y", "x") + " devolver y\n}\n" +
+			"funcion principal() int {\n devolver fases(1)\n}"
+		frame := regexp.MustCompile(`fases:\n\tpush rbp\n\tmov rbp, rsp\n\tsub rsp, (\d+)`)
+		sizes := make(map[asm_gen.RegAllocator]int)
+		for _, ra := range asm_gen.RegAllocators {
+			res, diags := compiler.Compile(source, compiler.Options{RegAlloc: ra})
+			if diagnostics.HasErrors(diags) {
+				t.Fatalf("unexpected diagnostics %v", diags)
+			}
+			m := frame.FindStringSubmatch(res.Asm)
+			if m == nil {
+				t.Fatalf("no frame for fases in\n%s", res.Asm)
+			}
+			sizes[ra], _ = strconv.Atoi(m[1])
+		}
+		// the values of the two phases share their spill slots
+		if sizes[asm_gen.GRAPH_COLORING] >= sizes[asm_gen.LINEAR_SCAN] {
+			t.Errorf("expected a smaller frame than the linear scan's %d, got %d", sizes[asm_gen.LINEAR_SCAN], sizes[asm_gen.GRAPH_COLORING])
+		}
+	})
+
+	t.Run("Optimized like unoptimized", func(t *testing.T) {
+		// folded in 32 bits, a phi copy on an edge of its own that is
+		// pruned, and an index ending a statement
+		source := "funcion f(p int) int {\n si !verdad entonces {\n  p = 2\n }\n" +
+			" para definir int i = 0; i < 2; i++ {\n }\n si verdad entonces {\n  p++\n }\n devolver 9\n}\n" +
+			"funcion principal() int {\n definir [int] a = [int]{1, 2}\n definir int x = a[1]\n" +
+			" a[0] = ((99 * -33) << 31) + f(1)\n devolver a[0] + x - 2\n}"
+		for _, unoptimized := range []bool{true, false} {
+			res, diags := compiler.Compile(source, compiler.Options{StopAfter: compiler.TAC, Unoptimized: unoptimized})
+			if diagnostics.HasErrors(diags) {
+				t.Fatalf("unexpected diagnostics %v", diags)
+			}
+			ret, err := tac.NewInterpreter(res.Functions).Run("principal")
+			if want := int64(math.MinInt32 + 9); err != nil || ret != want {
+				t.Errorf("expected %d unoptimized: %v, got %d, %v", want, unoptimized, ret, err)
+			}
+		}
+	})
+
+	t.Run("Pass manager", func(t *testing.T) {
+		source := "funcion principal() int {\n definir int x = 6\n definir int y = x * 7\n devolver y\n}"
+		for level, passes := range tac.OptLevels {
+			res, diags := compiler.Compile(source, compiler.Options{StopAfter: compiler.TAC, Passes: passes})
+			if diagnostics.HasErrors(diags) {
+				t.Fatalf("unexpected diagnostics %v", diags)
+			}
+			if ret, err := tac.NewInterpreter(res.Functions).Run("principal"); err != nil || ret != 42 {
+				t.Errorf("expected 42 at -O%d, got %d, %v", level, ret, err)
+			}
+		}
+
+		var log strings.Builder
+		_, diags := compiler.Compile(source, compiler.Options{
+			StopAfter:  compiler.TAC,
+			Passes:     []string{"propagate", "prune"},
+			PrintAfter: []string{"prune"},
+			TimePasses: true,
+			PassLog:    &log,
+		})
+		if diagnostics.HasErrors(diags) {
+			t.Fatalf("unexpected diagnostics %v", diags)
+		}
+		dump, timings, _ := strings.Cut(log.String(), "}\n")
+		if !strings.HasPrefix(dump, "// after prune\n") {
+			t.Fatalf("expected the TAC after prune, got\n%s", log.String())
+		}
+		handler, err := tac.ParseText(dump + "}\n")
+		if err != nil {
+			t.Fatalf("expected the dump to read back: %v\n%s", err, dump)
+		}
+		if ret, err := tac.NewInterpreter(handler.Functions()).Run("principal"); err != nil || ret != 42 {
+			t.Errorf("expected the dump to return 42, got %d, %v", ret, err)
+		}
+		for _, line := range []string{"propagate", "prune", "total"} {
+			if !regexp.MustCompile(`(?m)^ *` + line + ` +\S+$`).MatchString(timings) {
+				t.Errorf("expected a timing for %s, got\n%s", line, timings)
+			}
+		}
+
+		_, diags = compiler.Compile(source, compiler.Options{StopAfter: compiler.TAC, Passes: []string{"inline"}})
+		if len(diags) != 1 || diags[0].Kind != diagnostics.InternalError || !strings.Contains(diags[0].Msg, `unknown pass "inline"`) {
+			t.Errorf("expected an unknown pass to be an internal error, got %v", diags)
+		}
+	})
+}
+
+// runs source on the interpreter at every -O level and natively built
+// where there's a toolchain, expecting each run to return want
+func expectReturns(t *testing.T, source string, want int) {
+	t.Helper()
+	runs, err := difftest.NewHarness(t.TempDir()).Run(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range runs {
+		if run.Outcome.Trap != "" || run.Outcome.Status != want {
+			t.Errorf("%s: expected exit status %d, got %s", run.Way, want, run.Outcome)
+		}
+	}
+}
+
+// the language server compiles every change while others may be running
+func TestConcurrentCompiles(t *testing.T) {
+	source := "funcion f(a int) int {\n si a > 2 && a < 9 || a == 0 entonces {\n  devolver a > 4 ? 1 : 2\n }\n" +
+		" definir int s = 0\n para definir int i = 0; i < a; i++ {\n  s = s + i\n }\n devolver s\n}\n" +
+		"funcion principal() int {\n definir bool b = f(3) > 0 || f(1) > 0\n devolver b ? f(5) : 0\n}"
+	want, diags := compiler.Compile(source, compiler.Options{})
+	if diagnostics.HasErrors(diags) {
+		t.Fatalf("unexpected diagnostics %v", diags)
+	}
+	for i := 0; i < 8; i++ {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			// labels are numbered per function, not per process
+			res, _ := compiler.Compile(source, compiler.Options{})
+			if res.Asm != want.Asm {
+				t.Errorf("expected the same assembly every time, got\n%s\ninstead of\n%s", res.Asm, want.Asm)
+			}
+		})
+	}
+}
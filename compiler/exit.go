@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"fmt"
+	"he++/diagnostics"
+	"he++/utils"
+	"runtime"
+	"strings"
+)
+
+// exit statuses of the he++ driver
+const (
+	EXIT_OK = 0
+	// the compiler itself couldn't do its job: unreadable files,
+	// failing assembler or linker
+	EXIT_FAILURE  = 1
+	EXIT_USAGE    = 2
+	EXIT_LEXICAL  = 3
+	EXIT_SYNTAX   = 4
+	EXIT_SEMANTIC = 5
+	// same as EX_SOFTWARE from sysexits.h
+	EXIT_INTERNAL = 70
+)
+
+// status for a compilation that produced diags. When several kinds of
+// errors are present the one of the earliest stage wins, with internal
+// compiler errors above all.
+func ExitCode(diags []*diagnostics.Diagnostic) int {
+	code := EXIT_OK
+	for _, d := range diags {
+		if !d.IsError() {
+			continue
+		}
+		var c int
+		switch {
+		case d.Kind == diagnostics.InternalError:
+			return EXIT_INTERNAL
+		case d.Stage == string(LEX):
+			c = EXIT_LEXICAL
+		case d.Stage == string(PARSE):
+			c = EXIT_SYNTAX
+		default:
+			c = EXIT_SEMANTIC
+		}
+		if code == EXIT_OK || c < code {
+			code = c
+		}
+	}
+	return code
+}
+
+// report of a panic in stage, location tells how far into the he++
+// source the stage got
+func internalError(stage Stage, r any, location string) *diagnostics.Diagnostic {
+	d := diagnostics.Error(utils.Span{}, diagnostics.InternalError, fmt.Sprintf("internal compiler error: %v", r)).
+		WithNote("stage: " + string(stage)).
+		WithNote("source: " + location).
+		WithNote("at: " + panicSite())
+	d.Stage = string(stage)
+	return d
+}
+
+// the Go function that panicked, meant to be called from the deferred
+// function that recovered
+func panicSite() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	panicking := false
+	for {
+		f, more := frames.Next()
+		if f.Function == "runtime.gopanic" {
+			panicking = true
+		} else if panicking && !strings.HasPrefix(f.Function, "runtime.") {
+			return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package compiler
+
+import (
+	"he++/diagnostics"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Run("Per failure kind", func(t *testing.T) {
+		cases := []struct {
+			source string
+			code   int
+		}{
+			{"funcion principal() int {\n devolver 1\n}", EXIT_OK},
+			{"funcion principal() int {\n devolver 1 $ 2\n}", EXIT_LEXICAL},
+			{"funcion principal() int {\n devolver \"uno\n}", EXIT_LEXICAL},
+			{"funcion principal() int {\n definir int a = \n}", EXIT_SYNTAX},
+			{"funcion principal() int {\n devolver x\n}", EXIT_SEMANTIC},
+		}
+		for _, c := range cases {
+			_, diags := Compile(c.source, Options{StopAfter: ANALYZE})
+			if code := ExitCode(diags); code != c.code {
+				t.Errorf("expected %d for %q, got %d: %v", c.code, c.source, code, diags)
+			}
+		}
+	})
+
+	t.Run("Internal error", func(t *testing.T) {
+		c := &compilation{}
+		var empty []int
+		ok := c.guard(TAC, func() string { return "test.lg:3" }, func() {
+			_ = empty[1]
+		})
+		if ok || len(c.diags) != 1 {
+			t.Fatalf("expected the panic to be reported, got %v", c.diags)
+		}
+		d := c.diags[0]
+		if d.Kind != diagnostics.InternalError || d.Stage != string(TAC) || len(d.Notes) != 3 {
+			t.Fatalf("unexpected diagnostic %+v", d)
+		}
+		if d.Notes[0] != "stage: tac" || d.Notes[1] != "source: test.lg:3" {
+			t.Errorf("expected the stage and source location in the notes, got %v", d.Notes)
+		}
+		if !strings.Contains(d.Notes[2], "TestExitCode") {
+			t.Errorf("expected the panicking function in the notes, got %s", d.Notes[2])
+		}
+		if ExitCode(c.diags) != EXIT_INTERNAL {
+			t.Errorf("expected exit code %d", EXIT_INTERNAL)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package diagnostics
+
+import (
+	"fmt"
+	"he++/utils"
+)
+
+type Severity int
+
+const (
+	ERROR Severity = iota
+	WARNING
+	NOTE
+)
+
+func (s Severity) String() string {
+	switch s {
+	case WARNING:
+		return "warning"
+	case NOTE:
+		return "note"
+	}
+	return "error"
+}
+
+// what went wrong, shown next to the severity as in `error[TypeError]`
+type Kind string
+
+const (
+	LexicalError   Kind = "LexicalError"
+	SyntaxError    Kind = "SyntaxError"
+	TypeError      Kind = "TypeError"
+	UndefinedError Kind = "UndefinedError"
+	NotAllowed     Kind = "NotAllowed"
+	InternalError  Kind = "InternalError"
+)
+
+// secondary location that helps explain the diagnostic
+type Label struct {
+	Span utils.Span
+	Msg  string
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Kind     Kind
+	Msg      string
+	// primary location, the zero span if there is none
+	Span   utils.Span
+	Labels []Label
+	Notes  []string
+	// pipeline stage that reported it, set by the compiler
+	Stage string
+}
+
+func New(severity Severity, kind Kind, span utils.Span, msg string) *Diagnostic {
+	return &Diagnostic{Severity: severity, Kind: kind, Msg: msg, Span: span}
+}
+
+func Error(span utils.Span, kind Kind, msg string) *Diagnostic {
+	return New(ERROR, kind, span, msg)
+}
+
+func Warning(span utils.Span, msg string) *Diagnostic {
+	return New(WARNING, "", span, msg)
+}
+
+func (d *Diagnostic) WithLabel(span utils.Span, msg string) *Diagnostic {
+	d.Labels = append(d.Labels, Label{Span: span, Msg: msg})
+	return d
+}
+
+func (d *Diagnostic) WithNote(msg string) *Diagnostic {
+	d.Notes = append(d.Notes, msg)
+	return d
+}
+
+// line of the primary span, -1 if unknown
+func (d *Diagnostic) Line() int {
+	if !d.Span.IsValid() {
+		return -1
+	}
+	return d.Span.Line
+}
+
+func (d *Diagnostic) IsError() bool {
+	return d.Severity == ERROR
+}
+
+// one line summary, `file:line:col: error[Kind]: msg`
+func (d *Diagnostic) Error() string {
+	head := d.Severity.String()
+	if d.Kind != "" {
+		head += fmt.Sprintf("[%s]", d.Kind)
+	}
+	if d.Span.IsValid() {
+		return fmt.Sprintf("%s: %s: %s", d.Span, head, d.Msg)
+	}
+	return fmt.Sprintf("%s: %s", head, d.Msg)
+}
+
+func HasErrors(diags []*Diagnostic) bool {
+	for _, d := range diags {
+		if d.IsError() {
+			return true
+		}
+	}
+	return false
+}
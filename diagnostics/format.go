@@ -0,0 +1,87 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"he++/utils"
+	"io"
+	"regexp"
+)
+
+// how diagnostics are written out
+type Format string
+
+const (
+	TEXT  Format = "text"
+	JSON  Format = "json"
+	SARIF Format = "sarif"
+)
+
+var Formats = []Format{TEXT, JSON, SARIF}
+
+// messages may carry the colors of utils.pretty_console
+var ansiCodes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// s without color codes
+func Plain(s string) string {
+	return ansiCodes.ReplaceAllString(s, "")
+}
+
+type jsonSpan struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	// byte offsets, end exclusive
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type jsonLabel struct {
+	Span    *jsonSpan `json:"span"`
+	Message string    `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	Severity string      `json:"severity"`
+	Kind     string      `json:"kind,omitempty"`
+	Stage    string      `json:"stage,omitempty"`
+	Message  string      `json:"message"`
+	Span     *jsonSpan   `json:"span"`
+	Labels   []jsonLabel `json:"labels"`
+	Notes    []string    `json:"notes"`
+}
+
+// An array with one object per diagnostic. Spans are null when unknown,
+// lines and columns start at 1 and columns count bytes.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	out := make([]jsonDiagnostic, 0, len(diags))
+	for _, d := range diags {
+		jd := jsonDiagnostic{
+			Severity: d.Severity.String(),
+			Kind:     string(d.Kind),
+			Stage:    d.Stage,
+			Message:  Plain(d.Msg),
+			Span:     toJSONSpan(d.Span),
+			Labels:   make([]jsonLabel, 0, len(d.Labels)),
+			Notes:    make([]string, 0, len(d.Notes)),
+		}
+		for _, l := range d.Labels {
+			jd.Labels = append(jd.Labels, jsonLabel{Span: toJSONSpan(l.Span), Message: Plain(l.Msg)})
+		}
+		for _, n := range d.Notes {
+			jd.Notes = append(jd.Notes, Plain(n))
+		}
+		out = append(out, jd)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func toJSONSpan(s utils.Span) *jsonSpan {
+	if !s.IsValid() {
+		return nil
+	}
+	return &jsonSpan{File: s.File, Line: s.Line, Column: s.Col, EndLine: s.EndLine, EndColumn: s.EndCol, Start: s.Start, End: s.End}
+}
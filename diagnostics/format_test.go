@@ -0,0 +1,96 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"he++/utils"
+	"strings"
+	"testing"
+)
+
+func TestMachineFormats(t *testing.T) {
+	span := utils.Span{File: "a.lg", Line: 2, Col: 3, EndLine: 2, EndCol: 5, Start: 10, End: 12}
+	diags := []*Diagnostic{
+		Error(span, TypeError, "\033[36mint\033[0m expected").WithLabel(span, "here").WithNote("see docs"),
+		Warning(utils.Span{}, "no location"),
+	}
+	diags[0].Stage = "analyze"
+
+	t.Run("JSON", func(t *testing.T) {
+		var sb strings.Builder
+		if err := WriteJSON(&sb, diags); err != nil {
+			t.Fatal(err)
+		}
+		var out []map[string]any
+		if err := json.Unmarshal([]byte(sb.String()), &out); err != nil {
+			t.Fatalf("invalid json %v:\n%s", err, sb.String())
+		}
+		if len(out) != 2 {
+			t.Fatalf("expected 2 diagnostics, got %d", len(out))
+		}
+		first := out[0]
+		if first["severity"] != "error" || first["kind"] != "TypeError" || first["stage"] != "analyze" || first["message"] != "int expected" {
+			t.Errorf("unexpected diagnostic %v", first)
+		}
+		sp := first["span"].(map[string]any)
+		if sp["file"] != "a.lg" || sp["line"] != 2.0 || sp["column"] != 3.0 || sp["start"] != 10.0 || sp["end"] != 12.0 {
+			t.Errorf("unexpected span %v", sp)
+		}
+		if len(first["labels"].([]any)) != 1 || first["notes"].([]any)[0] != "see docs" {
+			t.Errorf("expected the label and note, got %v", first)
+		}
+		if out[1]["span"] != nil || out[1]["severity"] != "warning" {
+			t.Errorf("expected a warning without span, got %v", out[1])
+		}
+	})
+
+	t.Run("SARIF", func(t *testing.T) {
+		var sb strings.Builder
+		if err := WriteSARIF(&sb, diags, nil); err != nil {
+			t.Fatal(err)
+		}
+		var log sarifLog
+		if err := json.Unmarshal([]byte(sb.String()), &log); err != nil {
+			t.Fatalf("invalid sarif %v:\n%s", err, sb.String())
+		}
+		if log.Version != "2.1.0" || len(log.Runs) != 1 {
+			t.Fatalf("unexpected log %+v", log)
+		}
+		run := log.Runs[0]
+		if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].Id != "TypeError" {
+			t.Errorf("expected a rule per kind, got %+v", run.Tool.Driver.Rules)
+		}
+		if len(run.Results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(run.Results))
+		}
+		res := run.Results[0]
+		if res.Level != "error" || res.Message.Text != "int expected" || len(res.Locations) != 1 || len(res.RelatedLocations) != 1 {
+			t.Errorf("unexpected result %+v", res)
+		}
+		region := res.Locations[0].PhysicalLocation.Region
+		if region.StartLine != 2 || region.StartColumn != 3 || region.EndColumn != 5 || region.CharOffset != 10 || region.CharLength != 2 {
+			t.Errorf("unexpected region %+v", region)
+		}
+		if run.Results[1].Level != "warning" || run.Results[1].Locations != nil {
+			t.Errorf("unexpected result %+v", run.Results[1])
+		}
+	})
+
+	t.Run("SARIF code points", func(t *testing.T) {
+		// ñ and ú take two bytes each
+		src := "// ñandú\n definir int año = verdad\n"
+		start := strings.Index(src, "verdad")
+		span := utils.Span{File: "a.lg", Line: 2, Col: 21, EndLine: 2, EndCol: 27, Start: start, End: start + 6}
+		var sb strings.Builder
+		if err := WriteSARIF(&sb, []*Diagnostic{Error(span, TypeError, "int expected")}, map[string]string{"a.lg": src}); err != nil {
+			t.Fatal(err)
+		}
+		var log sarifLog
+		if err := json.Unmarshal([]byte(sb.String()), &log); err != nil {
+			t.Fatalf("invalid sarif %v:\n%s", err, sb.String())
+		}
+		region := log.Runs[0].Results[0].Locations[0].PhysicalLocation.Region
+		if region.StartColumn != 20 || region.EndColumn != 26 || region.CharOffset != 28 || region.CharLength != 6 {
+			t.Errorf("expected the region in code points, got %+v", region)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package diagnostics
+
+import (
+	"fmt"
+	"he++/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Renders diagnostics the way rustc does: a header, the location, then
+// the offending source lines with the spans underlined.
+//
+//	error[TypeError]: Cannot assign bool to variable of type int
+//	 --> main.lg:2:10
+//	  |
+//	2 |  definir int a = verdad
+//	  |              ^^^^^^^^^^
+//	  = note: ...
+//
+// Colors follow utils.ColorEnabled.
+type Renderer struct {
+	w io.Writer
+	// source text by file path, files missing here get no snippets
+	sources map[string][]string
+}
+
+func NewRenderer(w io.Writer) *Renderer {
+	return &Renderer{w: w, sources: make(map[string][]string)}
+}
+
+func (r *Renderer) AddSource(path string, src string) {
+	r.sources[path] = strings.Split(src, "\n")
+}
+
+func (r *Renderer) RenderAll(diags []*Diagnostic) {
+	for _, d := range diags {
+		r.Render(d)
+	}
+}
+
+func (r *Renderer) Render(d *Diagnostic) {
+	head := d.Severity.String()
+	if d.Kind != "" {
+		head += fmt.Sprintf("[%s]", d.Kind)
+	}
+	fmt.Fprintf(r.w, "%s: %s\n", severityColor(d.Severity, true)(head), utils.Bold(d.Msg))
+
+	spans := make([]Label, 0, len(d.Labels)+1)
+	if d.Span.IsValid() {
+		spans = append(spans, Label{Span: d.Span})
+	}
+	for _, l := range d.Labels {
+		if l.Span.IsValid() {
+			spans = append(spans, l)
+		}
+	}
+	gutter := 0
+	for _, l := range spans {
+		gutter = max(gutter, len(fmt.Sprint(l.Span.Line)))
+	}
+	pad := strings.Repeat(" ", gutter)
+	bar := utils.Blue("|")
+
+	if len(spans) > 0 {
+		fmt.Fprintf(r.w, "%s%s %s\n", pad, utils.Blue("-->"), spans[0].Span)
+		if lines, ok := r.sources[spans[0].Span.File]; ok {
+			fmt.Fprintf(r.w, "%s %s\n", pad, bar)
+			r.snippet(lines, spans, d.Severity, gutter)
+		}
+	}
+	for _, note := range d.Notes {
+		fmt.Fprintf(r.w, "%s %s %s: %s\n", pad, utils.Blue("="), utils.Bold("note"), note)
+	}
+	fmt.Fprintln(r.w)
+}
+
+// every line touched by a span of the primary file, each followed by
+// its underlines: `^` for the primary span, `-` for labels
+func (r *Renderer) snippet(lines []string, spans []Label, sev Severity, gutter int) {
+	byLine := make(map[int][]int)
+	order := make([]int, 0)
+	for i, l := range spans {
+		if l.Span.File != spans[0].Span.File || l.Span.Line > len(lines) {
+			continue
+		}
+		if _, ok := byLine[l.Span.Line]; !ok {
+			order = append(order, l.Span.Line)
+		}
+		byLine[l.Span.Line] = append(byLine[l.Span.Line], i)
+	}
+	sort.Ints(order)
+
+	bar := utils.Blue("|")
+	pad := strings.Repeat(" ", gutter)
+	for n, line := range order {
+		if n > 0 && line > order[n-1]+1 {
+			fmt.Fprintln(r.w, utils.Blue("..."))
+		}
+		text := strings.TrimRight(lines[line-1], "\r")
+		fmt.Fprintf(r.w, "%s %s %s\n", utils.Blue(fmt.Sprintf("%*d", gutter, line)), bar, text)
+		for _, i := range byLine[line] {
+			l := spans[i]
+			mark, color := "-", utils.Blue
+			if i == 0 {
+				mark, color = "^", severityColor(sev, false)
+			}
+			under := indentFor(text, l.Span.Col) + strings.Repeat(mark, underlineWidth(text, l.Span))
+			if l.Msg != "" {
+				under += " " + l.Msg
+			}
+			fmt.Fprintf(r.w, "%s %s %s\n", pad, bar, color(under))
+		}
+	}
+}
+
+// whitespace lining up with column col of text, tabs kept as tabs
+func indentFor(text string, col int) string {
+	var sb strings.Builder
+	for i := 0; i < col-1 && i < len(text); i++ {
+		if text[i] == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+// spans over several lines are underlined up to the end of the first
+func underlineWidth(text string, span utils.Span) int {
+	width := span.EndCol - span.Col
+	if span.EndLine != span.Line {
+		width = len(text) - span.Col + 1
+	}
+	return max(width, 1)
+}
+
+func severityColor(sev Severity, bold bool) func(string) string {
+	switch {
+	case sev == WARNING && bold:
+		return utils.BoldYellow
+	case sev == WARNING:
+		return utils.Yellow
+	case sev == NOTE && bold:
+		return utils.BoldCyan
+	case sev == NOTE:
+		return utils.Cyan
+	case bold:
+		return utils.BoldRed
+	}
+	return utils.Red
+}
@@ -0,0 +1,56 @@
+package diagnostics
+
+import (
+	"he++/utils"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	defer utils.SetColor(utils.SetColor(false))
+	src := "funcion f() int {\n\tdevolver 1\n\tdevolver 2\n}\n"
+	span := func(line, col, endCol int) utils.Span {
+		return utils.Span{File: "a.lg", Line: line, Col: col, EndLine: line, EndCol: endCol}
+	}
+
+	t.Run("Snippet with label and note", func(t *testing.T) {
+		var sb strings.Builder
+		r := NewRenderer(&sb)
+		r.AddSource("a.lg", src)
+		r.Render(Error(span(2, 2, 12), SyntaxError, "return must come last").
+			WithLabel(span(3, 2, 12), "never reached").
+			WithNote("remove it"))
+		expected := "error[SyntaxError]: return must come last\n" +
+			" --> a.lg:2:2\n" +
+			"  |\n" +
+			"2 | \tdevolver 1\n" +
+			"  | \t^^^^^^^^^^\n" +
+			"3 | \tdevolver 2\n" +
+			"  | \t---------- never reached\n" +
+			"  = note: remove it\n\n"
+		if sb.String() != expected {
+			t.Errorf("expected\n%s\ngot\n%s", expected, sb.String())
+		}
+	})
+
+	t.Run("Without source or span", func(t *testing.T) {
+		var sb strings.Builder
+		r := NewRenderer(&sb)
+		r.Render(Warning(span(1, 9, 10), "odd name"))
+		r.Render(Error(utils.Span{}, InternalError, "boom"))
+		expected := "warning: odd name\n --> a.lg:1:9\n\nerror[InternalError]: boom\n\n"
+		if sb.String() != expected {
+			t.Errorf("expected\n%s\ngot\n%s", expected, sb.String())
+		}
+	})
+
+	t.Run("No color", func(t *testing.T) {
+		var sb strings.Builder
+		r := NewRenderer(&sb)
+		r.AddSource("a.lg", src)
+		r.Render(Error(span(1, 9, 10), TypeError, utils.Cyan("int")+" expected"))
+		if strings.Contains(sb.String(), "\033[") {
+			t.Errorf("expected no escape codes, got %q", sb.String())
+		}
+	})
+}
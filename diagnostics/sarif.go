@@ -0,0 +1,166 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"he++/utils"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Minimal SARIF 2.1.0 (https://docs.oasis-open.org/sarif/sarif/v2.1.0/),
+// enough for CI annotations: one run, one result per diagnostic and a
+// rule per Kind.
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool sarifTool `json:"tool"`
+	// our spans count bytes, they are converted with the source text
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	Id string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Properties       *sarifProps     `json:"properties,omitempty"`
+}
+
+type sarifProps struct {
+	Stage string   `json:"stage,omitempty"`
+	Notes []string `json:"notes,omitempty"`
+}
+
+type sarifLocation struct {
+	Id               *int                  `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	Uri string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+	CharOffset  int `json:"charOffset"`
+	CharLength  int `json:"charLength"`
+}
+
+// sources holds the text of the files by path, to count columns and
+// offsets in code points. Spans in files missing there keep their byte
+// counts, which are the same for ascii source.
+func WriteSARIF(w io.Writer, diags []*Diagnostic, sources map[string]string) error {
+	rules := make(map[string]bool)
+	results := make([]sarifResult, 0, len(diags))
+	for _, d := range diags {
+		res := sarifResult{
+			RuleId:  string(d.Kind),
+			Level:   d.Severity.String(),
+			Message: sarifMessage{Text: Plain(d.Msg)},
+		}
+		if d.Kind != "" {
+			rules[string(d.Kind)] = true
+		}
+		if d.Span.IsValid() {
+			res.Locations = []sarifLocation{{PhysicalLocation: toSarifLocation(d.Span, sources[d.Span.File])}}
+		}
+		for i, l := range d.Labels {
+			if !l.Span.IsValid() {
+				continue
+			}
+			id := i
+			res.RelatedLocations = append(res.RelatedLocations, sarifLocation{
+				Id:               &id,
+				PhysicalLocation: toSarifLocation(l.Span, sources[l.Span.File]),
+				Message:          &sarifMessage{Text: Plain(l.Msg)},
+			})
+		}
+		if d.Stage != "" || len(d.Notes) > 0 {
+			res.Properties = &sarifProps{Stage: d.Stage}
+			for _, n := range d.Notes {
+				res.Properties.Notes = append(res.Properties.Notes, Plain(n))
+			}
+		}
+		results = append(results, res)
+	}
+
+	ruleIds := make([]string, 0, len(rules))
+	for id := range rules {
+		ruleIds = append(ruleIds, id)
+	}
+	sort.Strings(ruleIds)
+	driver := sarifDriver{Name: "he++", Rules: make([]sarifRule, 0, len(ruleIds))}
+	for _, id := range ruleIds {
+		driver.Rules = append(driver.Rules, sarifRule{Id: id})
+	}
+
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, ColumnKind: "unicodeCodePoints", Results: results}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func toSarifLocation(s utils.Span, src string) sarifPhysicalLocation {
+	region := sarifRegion{
+		StartLine:   s.Line,
+		StartColumn: s.Col,
+		EndLine:     s.EndLine,
+		EndColumn:   s.EndCol,
+		CharOffset:  s.Start,
+		CharLength:  s.End - s.Start,
+	}
+	if 0 <= s.Start && s.Start <= s.End && s.End <= len(src) {
+		region.StartColumn = codePointColumn(src, s.Start)
+		region.EndColumn = codePointColumn(src, s.End)
+		region.CharOffset = utf8.RuneCountInString(src[:s.Start])
+		region.CharLength = utf8.RuneCountInString(src[s.Start:s.End])
+	}
+	return sarifPhysicalLocation{ArtifactLocation: sarifArtifact{Uri: s.File}, Region: region}
+}
+
+// the 1 based column of the byte offset, in code points
+func codePointColumn(src string, offset int) int {
+	lineStart := strings.LastIndexByte(src[:offset], '\n') + 1
+	return utf8.RuneCountInString(src[lineStart:offset]) + 1
+}
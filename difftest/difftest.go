@@ -0,0 +1,198 @@
+package difftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"he++/asm_gen"
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/tac"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Runs a program several ways: its TAC at every -O level on the
+// interpreter, and natively built at -O0 and the default level with each
+// register allocator. The TAC at -O0, as generated, is the reference the
+// others must agree with. A program the reference traps on has no
+// defined outcome and isn't compared; one that runs to its end must end
+// the same way everywhere, without traps. The only output of a program
+// is its exit status.
+
+type Way string
+
+func tacWay(level int) Way {
+	return Way(fmt.Sprintf("TAC at -O%d", level))
+}
+
+func nativeWay(level int, regAlloc asm_gen.RegAllocator) Way {
+	return Way(fmt.Sprintf("native at -O%d, %s allocator", level, regAlloc))
+}
+
+// how a run of a program ended
+type Outcome struct {
+	// the low byte of what principal returned
+	Status int
+	// what stopped the program, empty if it ran to its end
+	Trap tac.TrapKind
+	// the runtime error or signal
+	Detail string
+}
+
+func (o Outcome) String() string {
+	if o.Trap != "" {
+		return fmt.Sprintf("trapped (%s)", o.Detail)
+	}
+	return fmt.Sprintf("exit status %d", o.Status)
+}
+
+type Run struct {
+	Way     Way
+	Outcome Outcome
+}
+
+type Harness struct {
+	// interpreted programs are stopped after this many instructions, and
+	// natively built ones after Timeout
+	MaxSteps int
+	Timeout  time.Duration
+	// allocators the program is built with, none to only interpret it
+	RegAllocs []asm_gen.RegAllocator
+	// binaries are built here
+	Dir string
+}
+
+// a harness building with every allocator, if there is a toolchain to
+// build with
+func NewHarness(dir string) *Harness {
+	h := &Harness{MaxSteps: 10_000_000, Timeout: 10 * time.Second, Dir: dir}
+	if HasToolchain() {
+		h.RegAllocs = asm_gen.RegAllocators
+	}
+	return h
+}
+
+// whether programs can be assembled and linked here
+func HasToolchain() bool {
+	_, asErr := exec.LookPath("as")
+	_, ldErr := exec.LookPath("ld")
+	_, ccErr := exec.LookPath("cc")
+	return asErr == nil && ldErr == nil || ccErr == nil
+}
+
+// the reference run first. Natively built programs aren't run when the
+// reference ran out of steps, they would only run into the timeout.
+func (h *Harness) Run(source string) ([]Run, error) {
+	runs := make([]Run, 0)
+	for level, passes := range tac.OptLevels {
+		res, err := compile(source, compiler.Options{StopAfter: compiler.TAC, Passes: passes})
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, Run{tacWay(level), h.interpret(res)})
+	}
+	if runs[0].Outcome.Trap == tac.TRAP_LIMIT {
+		return runs, nil
+	}
+	for _, level := range []int{0, tac.DEFAULT_OPT_LEVEL} {
+		for i, regAlloc := range h.RegAllocs {
+			opts := compiler.Options{RegAlloc: regAlloc, Passes: tac.OptLevels[level]}
+			res, err := compile(source, opts)
+			if err != nil {
+				return nil, err
+			}
+			bin := filepath.Join(h.Dir, fmt.Sprintf("prog_%d_%d", level, i))
+			if err := asm_gen.BuildExecutable(res.Asm, h.Dir, bin); err != nil {
+				return nil, err
+			}
+			outcome, err := h.execute(bin)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, Run{nativeWay(level, regAlloc), outcome})
+		}
+	}
+	return runs, nil
+}
+
+// runs the program every way and reports the runs that disagree with
+// the reference
+func (h *Harness) Check(source string) error {
+	runs, err := h.Run(source)
+	if err != nil {
+		return err
+	}
+	return Compare(runs)
+}
+
+func Compare(runs []Run) error {
+	ref := runs[0]
+	if ref.Outcome.Trap != "" {
+		return nil
+	}
+	diffs := make([]string, 0)
+	for _, run := range runs[1:] {
+		if run.Outcome.Trap != "" || run.Outcome.Status != ref.Outcome.Status {
+			diffs = append(diffs, fmt.Sprintf("%s: %s", run.Way, run.Outcome))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %s, but\n%s", ref.Way, ref.Outcome, strings.Join(diffs, "\n"))
+}
+
+func compile(source string, opts compiler.Options) (*compiler.Result, error) {
+	res, diags := compiler.Compile(source, opts)
+	if diagnostics.HasErrors(diags) {
+		msgs := make([]string, len(diags))
+		for i, d := range diags {
+			msgs[i] = d.Error()
+		}
+		return nil, fmt.Errorf("doesn't compile:\n%s", strings.Join(msgs, "\n"))
+	}
+	if !res.HasFunction(asm_gen.ENTRY_FUNC) {
+		return nil, fmt.Errorf("no %s function to start the program at", asm_gen.ENTRY_FUNC)
+	}
+	return res, nil
+}
+
+func (h *Harness) interpret(res *compiler.Result) Outcome {
+	in := tac.NewInterpreter(res.Functions)
+	in.MaxSteps = h.MaxSteps
+	ret, err := in.Run(asm_gen.ENTRY_FUNC)
+	var rerr *tac.RuntimeError
+	if errors.As(err, &rerr) {
+		return Outcome{Trap: rerr.Kind, Detail: rerr.Error()}
+	}
+	return Outcome{Status: int(uint8(ret))}
+}
+
+func (h *Harness) execute(bin string) (Outcome, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	err := exec.CommandContext(ctx, bin).Run()
+	if ctx.Err() != nil {
+		return Outcome{Trap: tac.TRAP_LIMIT, Detail: fmt.Sprintf("ran longer than %v", h.Timeout)}, nil
+	}
+	if err == nil {
+		return Outcome{}, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return Outcome{}, err
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return Outcome{Status: exitErr.ExitCode()}, nil
+	}
+	trap := tac.TRAP_MEMORY
+	if status.Signal() == syscall.SIGFPE {
+		trap = tac.TRAP_ARITH
+	}
+	return Outcome{Trap: trap, Detail: fmt.Sprintf("killed by signal: %v", status.Signal())}, nil
+}
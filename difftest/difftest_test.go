@@ -0,0 +1,65 @@
+package difftest
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDifferential(t *testing.T) {
+	t.Run("Samples", func(t *testing.T) {
+		paths, err := filepath.Glob("../samples/programs/*.lg")
+		if err != nil || len(paths) == 0 {
+			t.Fatalf("no sample programs: %v", err)
+		}
+		h := NewHarness(t.TempDir())
+		for _, path := range paths {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs, err := h.Run(string(src))
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			if ref := runs[0].Outcome; ref.Trap != "" {
+				t.Errorf("%s: the samples should run to their end, %s", path, ref)
+			}
+			if err := Compare(runs); err != nil {
+				t.Errorf("%s: %v", path, err)
+			}
+		}
+	})
+
+	t.Run("Generated programs", func(t *testing.T) {
+		seeds := 200
+		if testing.Short() {
+			seeds = 20
+		}
+		h := NewHarness(t.TempDir())
+		for seed := range seeds {
+			src := Generate(rand.New(rand.NewSource(int64(seed))))
+			runs, err := h.Run(src)
+			if err != nil {
+				t.Errorf("seed %d: %v\n%s", seed, err, src)
+				continue
+			}
+			// the generated programs never trap, so the reference doesn't
+			// either unless the TAC is wrong
+			if ref := runs[0].Outcome; ref.Trap != "" {
+				t.Errorf("seed %d: the reference %s\n%s", seed, ref, src)
+			} else if err := Compare(runs); err != nil {
+				t.Errorf("seed %d: %v\n%s", seed, err, src)
+			}
+		}
+	})
+
+	t.Run("Generated programs are reproducible", func(t *testing.T) {
+		a := Generate(rand.New(rand.NewSource(7)))
+		b := Generate(rand.New(rand.NewSource(7)))
+		if a != b {
+			t.Errorf("same seed, different programs:\n%s\n%s", a, b)
+		}
+	})
+}
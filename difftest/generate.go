@@ -0,0 +1,371 @@
+package difftest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Random programs for the harness. They are well typed, end, and have
+// the same outcome however they are run: nothing is divided by zero or
+// read out of bounds, loops run a bounded number of times and floats
+// stay small enough not to overflow when converted to ints.
+
+const (
+	maxHelpers    = 3
+	maxStmts      = 6
+	maxBlockDepth = 2
+	maxExprDepth  = 3
+	maxArrayLen   = 4
+)
+
+type typ string
+
+const (
+	INT   typ = "int"
+	FLOAT typ = "float"
+	BOOL  typ = "bool"
+)
+
+type variable struct {
+	name string
+	typ  typ
+	// for arrays, the number of elements. Array elements are ints.
+	length int
+	// pointers point at ints
+	pointer bool
+	// loop counters are only read
+	readOnly bool
+}
+
+type helper struct {
+	name   string
+	params []typ
+	ret    typ
+}
+
+type generator struct {
+	r *rand.Rand
+	b strings.Builder
+	// helpers the function being generated can call
+	helpers []helper
+	// variables in scope, innermost last
+	vars   []variable
+	names  int
+	indent int
+	depth  int
+}
+
+// a program with a few helper functions and a principal calling them,
+// the same for the same r
+func Generate(r *rand.Rand) string {
+	g := &generator{r: r}
+	for i := range 1 + r.Intn(maxHelpers) {
+		h := helper{name: "f" + letters(i), ret: g.scalar()}
+		for range r.Intn(4) {
+			h.params = append(h.params, g.scalar())
+		}
+		g.function(h)
+		g.helpers = append(g.helpers, h)
+	}
+	g.function(helper{name: "principal", ret: INT})
+	return g.b.String()
+}
+
+// identifiers can't have digits
+func letters(n int) string {
+	s := ""
+	for {
+		s = string(rune('a'+n%26)) + s
+		n /= 26
+		if n == 0 {
+			return s
+		}
+		n--
+	}
+}
+
+func (g *generator) scalar() typ {
+	if g.r.Intn(2) == 0 {
+		return INT
+	}
+	return FLOAT
+}
+
+func (g *generator) line(format string, args ...any) {
+	g.b.WriteString(strings.Repeat("    ", g.indent))
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteString("\n")
+}
+
+func (g *generator) fresh() string {
+	g.names++
+	return "v" + letters(g.names-1)
+}
+
+func (g *generator) function(h helper) {
+	g.vars = g.vars[:0]
+	params := make([]string, len(h.params))
+	for i, t := range h.params {
+		v := variable{name: "p" + letters(i), typ: t}
+		g.vars = append(g.vars, v)
+		params[i] = fmt.Sprintf("%s %s", v.name, t)
+	}
+	g.line("funcion %s(%s) %s {", h.name, strings.Join(params, ", "), h.ret)
+	g.indent++
+	for range 1 + g.r.Intn(maxStmts) {
+		g.stmt()
+	}
+	g.line("devolver %s", g.expr(h.ret, 0))
+	g.indent--
+	g.line("}")
+	g.line("")
+}
+
+// the statements of a block, its braces left to the caller
+func (g *generator) block() {
+	g.indent++
+	g.depth++
+	scope := len(g.vars)
+	for range 1 + g.r.Intn(maxStmts/2) {
+		g.stmt()
+	}
+	g.vars = g.vars[:scope]
+	g.depth--
+	g.indent--
+}
+
+func (g *generator) stmt() {
+	switch n := g.r.Intn(10); {
+	case n < 3:
+		g.declare()
+	case n < 6:
+		if !g.assign() {
+			g.declare()
+		}
+	case n < 7 && g.depth < maxBlockDepth:
+		g.line("si %s entonces {", g.expr(BOOL, 0))
+		g.block()
+		if g.r.Intn(2) == 0 {
+			g.line("} o {")
+			g.block()
+		}
+		g.line("}")
+	case n < 8 && g.depth < maxBlockDepth:
+		i := g.fresh()
+		g.line("para definir int %s = 0; %s < %d; %s++ {", i, i, 1+g.r.Intn(4), i)
+		g.vars = append(g.vars, variable{name: i, typ: INT, readOnly: true})
+		g.block()
+		g.vars = g.vars[:len(g.vars)-1]
+		g.line("}")
+	case n < 9 && g.depth < maxBlockDepth:
+		c := g.fresh()
+		g.line("definir int %s = %d", c, g.r.Intn(4))
+		g.vars = append(g.vars, variable{name: c, typ: INT, readOnly: true})
+		g.line("mientras que %s > 0 {", c)
+		g.block()
+		g.indent++
+		g.line("%s--", c)
+		g.indent--
+		g.line("}")
+	default:
+		if v := g.pick(func(v variable) bool { return v.typ == INT && !v.readOnly && v.length == 0 }); v != nil {
+			g.line("%s++", g.operand(*v))
+		} else {
+			g.declare()
+		}
+	}
+}
+
+func (g *generator) declare() {
+	name := g.fresh()
+	switch n := g.r.Intn(10); {
+	case n < 4:
+		g.line("definir int %s = %s", name, g.expr(INT, 0))
+		g.vars = append(g.vars, variable{name: name, typ: INT})
+	case n < 6:
+		g.line("definir float %s = %s", name, g.expr(FLOAT, 0))
+		g.vars = append(g.vars, variable{name: name, typ: FLOAT})
+	case n < 7:
+		g.line("definir bool %s = %s", name, g.expr(BOOL, 0))
+		g.vars = append(g.vars, variable{name: name, typ: BOOL})
+	case n < 9:
+		length := 1 + g.r.Intn(maxArrayLen)
+		if g.r.Intn(2) == 0 {
+			g.line("definir [int] %s = [int][%d]", name, length)
+		} else {
+			elems := make([]string, length)
+			for i := range elems {
+				elems[i] = g.expr(INT, maxExprDepth-1)
+			}
+			g.line("definir [int] %s = [int]{%s}", name, strings.Join(elems, ", "))
+		}
+		g.vars = append(g.vars, variable{name: name, typ: INT, length: length})
+	default:
+		// loop counters are left alone, their loops must end
+		target := g.pick(func(v variable) bool { return v.typ == INT && !v.pointer && !v.readOnly })
+		if target == nil {
+			g.line("definir int %s = %s", name, g.expr(INT, 0))
+			g.vars = append(g.vars, variable{name: name, typ: INT})
+			return
+		}
+		g.line("definir &int %s = &%s", name, g.place(*target))
+		g.vars = append(g.vars, variable{name: name, typ: INT, pointer: true})
+	}
+}
+
+// false if there is nothing to assign to
+func (g *generator) assign() bool {
+	v := g.pick(func(v variable) bool { return !v.readOnly && v.typ != BOOL })
+	if v == nil {
+		return false
+	}
+	// a float assigned to an int is converted, and the other way around
+	g.line("%s = %s", g.lvalue(*v), g.expr(g.scalar(), 0))
+	return true
+}
+
+// what is written when assigning to v
+func (g *generator) lvalue(v variable) string {
+	if v.pointer {
+		return "*" + v.name
+	}
+	return g.place(v)
+}
+
+// like lvalue, with what a pointer points at parenthesized
+func (g *generator) operand(v variable) string {
+	if v.pointer {
+		return "(*" + v.name + ")"
+	}
+	return g.place(v)
+}
+
+// v itself or one of its elements, where a pointer can point
+func (g *generator) place(v variable) string {
+	if v.length > 0 {
+		return fmt.Sprintf("%s[%d]", v.name, g.r.Intn(v.length))
+	}
+	return v.name
+}
+
+func (g *generator) pick(ok func(v variable) bool) *variable {
+	found := make([]variable, 0)
+	for _, v := range g.vars {
+		if ok(v) {
+			found = append(found, v)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	v := found[g.r.Intn(len(found))]
+	return &v
+}
+
+// every subexpression is parenthesized, there's no precedence to get
+// wrong
+func (g *generator) expr(t typ, depth int) string {
+	leaf := depth >= maxExprDepth || g.r.Intn(3) == 0
+	switch t {
+	case BOOL:
+		if leaf {
+			if v := g.pick(func(v variable) bool { return v.typ == BOOL }); v != nil && g.r.Intn(2) == 0 {
+				return v.name
+			}
+			return []string{"verdad", "falso"}[g.r.Intn(2)]
+		}
+		switch g.r.Intn(4) {
+		case 0:
+			return fmt.Sprintf("!(%s)", g.expr(BOOL, depth+1))
+		case 1:
+			op := []string{"&&", "||"}[g.r.Intn(2)]
+			return fmt.Sprintf("(%s) %s (%s)", g.expr(BOOL, depth+1), op, g.expr(BOOL, depth+1))
+		default:
+			op := []string{"<", "<=", ">", ">=", "==", "!="}[g.r.Intn(6)]
+			// floats are compared with ints, which are converted
+			return fmt.Sprintf("(%s) %s (%s)", g.expr(INT, depth+1), op, g.expr(g.scalar(), depth+1))
+		}
+	case INT:
+		if leaf {
+			return g.intLeaf()
+		}
+		switch n := g.r.Intn(10); {
+		case n < 5:
+			op := []string{"+", "-", "*"}[g.r.Intn(3)]
+			return fmt.Sprintf("(%s) %s (%s)", g.expr(INT, depth+1), op, g.expr(INT, depth+1))
+		case n < 6:
+			// never 0 nor -1, so neither traps
+			op := []string{"/", "%"}[g.r.Intn(2)]
+			d := g.expr(INT, depth+1)
+			return fmt.Sprintf("(%s) %s (((%s) * (%s)) + 1)", g.expr(INT, depth+1), op, d, d)
+		case n < 7:
+			op := []string{"<<", ">>"}[g.r.Intn(2)]
+			return fmt.Sprintf("(%s) %s %d", g.expr(INT, depth+1), op, g.r.Intn(32))
+		case n < 8:
+			return fmt.Sprintf("-(%s)", g.expr(INT, depth+1))
+		case n < 9:
+			return fmt.Sprintf("(%s) ? (%s) : (%s)", g.expr(BOOL, depth+1), g.expr(INT, depth+1), g.expr(INT, depth+1))
+		default:
+			return g.call(INT, depth)
+		}
+	default:
+		if leaf {
+			return g.floatLeaf()
+		}
+		switch n := g.r.Intn(10); {
+		case n < 4:
+			op := []string{"+", "-"}[g.r.Intn(2)]
+			return fmt.Sprintf("(%s) %s (%s)", g.expr(FLOAT, depth+1), op, g.expr(FLOAT, depth+1))
+		case n < 6:
+			// growing slowly enough not to overflow in the loops
+			return fmt.Sprintf("(%s) * %s", g.expr(FLOAT, depth+1), g.floatLit(1.5))
+		case n < 7:
+			return fmt.Sprintf("(%s) / %s", g.expr(FLOAT, depth+1), g.floatLit(8.0))
+		case n < 8:
+			return fmt.Sprintf("-(%s)", g.expr(FLOAT, depth+1))
+		default:
+			return g.call(FLOAT, depth)
+		}
+	}
+}
+
+func (g *generator) intLeaf() string {
+	v := g.pick(func(v variable) bool { return v.typ == INT })
+	if v == nil || g.r.Intn(3) == 0 {
+		return fmt.Sprint(g.r.Intn(200) - 50)
+	}
+	return g.operand(*v)
+}
+
+func (g *generator) floatLeaf() string {
+	v := g.pick(func(v variable) bool { return v.typ == FLOAT })
+	if v == nil || g.r.Intn(3) == 0 {
+		return g.floatLit(100)
+	}
+	return v.name
+}
+
+// a positive multiple of a quarter up to max, never 0 so it can divide
+func (g *generator) floatLit(max float64) string {
+	return fmt.Sprintf("%.2f", float64(1+g.r.Intn(int(max*4)))/4)
+}
+
+// a call to a helper returning t, or a leaf if there is none
+func (g *generator) call(t typ, depth int) string {
+	found := make([]helper, 0)
+	for _, h := range g.helpers {
+		if h.ret == t {
+			found = append(found, h)
+		}
+	}
+	if len(found) == 0 {
+		return g.expr(t, maxExprDepth)
+	}
+	h := found[g.r.Intn(len(found))]
+	args := make([]string, len(h.params))
+	for i, p := range h.params {
+		args[i] = g.expr(p, depth+1)
+	}
+	return fmt.Sprintf("%s(%s)", h.name, strings.Join(args, ", "))
+}
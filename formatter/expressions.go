@@ -0,0 +1,118 @@
+package formatter
+
+import (
+	"he++/lexer"
+	"he++/parser"
+	nodes "he++/parser/node_types"
+	"math"
+	"strings"
+)
+
+// The parser drops parentheses, so they are put back wherever leaving
+// them out would make the printed expression parse into another tree.
+
+// how tightly the operator at the root of n binds, atoms bind tightest
+func precedence(n nodes.TreeNode) float32 {
+	switch n := n.(type) {
+	case *nodes.InfixOperatorNode:
+		return parser.Precedence(n.Op)
+	case *nodes.TernaryOperatorNode:
+		return parser.Precedence(lexer.TERN_IF)
+	case *nodes.PrePostOperatorNode:
+		if n.OpType == nodes.PREFIX {
+			return parser.PREFIX_PRECEDENCE
+		}
+		return parser.Precedence(n.Op)
+	case *nodes.FuncCallNode:
+		return parser.Precedence(lexer.OPEN_PAREN)
+	case *nodes.ArrIndNode:
+		return parser.Precedence(lexer.OPEN_SQUARE)
+	}
+	return math.MaxFloat32
+}
+
+// postfix operators apply to whatever was parsed before them, so they
+// never need parentheses on the left of another operator
+func isPostfix(n nodes.TreeNode) bool {
+	switch n := n.(type) {
+	case *nodes.FuncCallNode, *nodes.ArrIndNode:
+		return true
+	case *nodes.PrePostOperatorNode:
+		return n.OpType == nodes.POSTFIX
+	}
+	return false
+}
+
+func parens(s string) string {
+	return lexer.OPEN_PAREN + s + lexer.CLOSE_PAREN
+}
+
+// operators are left associative
+func (p *printer) left(n nodes.TreeNode, prec float32) string {
+	if !isPostfix(n) && precedence(n) < prec {
+		return parens(p.expr(n))
+	}
+	return p.expr(n)
+}
+
+func (p *printer) right(n nodes.TreeNode, prec float32) string {
+	if precedence(n) <= prec {
+		return parens(p.expr(n))
+	}
+	return p.expr(n)
+}
+
+func (p *printer) expr(expr nodes.TreeNode) string {
+	switch n := expr.(type) {
+	case *nodes.IdentifierNode:
+		return n.Name()
+	case *nodes.NumberNode, *nodes.StringNode, *nodes.BooleanNode:
+		return p.text(n.Span())
+	case *nodes.InfixOperatorNode:
+		prec := parser.Precedence(n.Op)
+		op := " " + n.Op + " "
+		if n.Op == lexer.DOT {
+			op = n.Op
+		}
+		return p.left(n.Left, prec) + op + p.right(n.Right, prec)
+	case *nodes.TernaryOperatorNode:
+		// right associative, the last operand binds like an assignment's
+		cond := p.right(n.Condition, parser.Precedence(lexer.TERN_IF))
+		ifFalse := p.right(n.IfFalse, parser.Precedence(lexer.ASSN))
+		return cond + " " + lexer.TERN_IF + " " + p.expr(n.IfTrue) + " " + lexer.COLON + " " + ifFalse
+	case *nodes.PrePostOperatorNode:
+		if n.OpType == nodes.POSTFIX {
+			return p.left(n.Operand, parser.Precedence(n.Op)) + n.Op
+		}
+		// a prefix operand is put in parentheses too, so that `- -a`
+		// doesn't turn into `--a`
+		return n.Op + p.right(n.Operand, parser.PREFIX_PRECEDENCE)
+	case *nodes.FuncCallNode:
+		return p.left(n.Callee, parser.Precedence(lexer.OPEN_PAREN)) + parens(p.list(n.Args))
+	case *nodes.ArrIndNode:
+		return p.left(n.ArrProvider, parser.Precedence(lexer.OPEN_SQUARE)) + lexer.OPEN_SQUARE + p.expr(n.Indexer) + lexer.CLOSE_SQUARE
+	case *nodes.ArrayDeclarationNode:
+		s := lexer.OPEN_SQUARE + dataType(n.DataT) + lexer.CLOSE_SQUARE
+		if n.Elems == nil {
+			return s + lexer.OPEN_SQUARE + p.expr(n.SizeNode) + lexer.CLOSE_SQUARE
+		}
+		return s + lexer.LPAREN + p.list(n.Elems) + lexer.RPAREN
+	case *nodes.StructValueNode:
+		names := n.FieldNames()
+		fields := make([]string, len(names))
+		for i, name := range names {
+			fields[i] = name + lexer.COLON + " " + p.expr(n.FieldValues[name])
+		}
+		return lexer.LPAREN + strings.Join(fields, lexer.COMMA+" ") + lexer.RPAREN
+	}
+	// the parser makes nothing else out of expressions
+	return p.text(expr.Span())
+}
+
+func (p *printer) list(exprs []nodes.TreeNode) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = p.expr(e)
+	}
+	return strings.Join(parts, lexer.COMMA+" ")
+}
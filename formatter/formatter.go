@@ -0,0 +1,243 @@
+// Package formatter prints he++ source in its canonical layout: four
+// space indentation, one statement per line, spaces around binary
+// operators and at most one blank line in a row. Comments stay next to
+// the code they were written beside.
+package formatter
+
+import (
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/lexer"
+	nodes "he++/parser/node_types"
+	"he++/utils"
+	"strings"
+)
+
+const INDENT = "    "
+
+// formats src, named path in diagnostics. Source with lexical or syntax
+// errors isn't formatted, the errors are returned instead.
+func Format(path string, src string) (string, []*diagnostics.Diagnostic) {
+	res, diags := compiler.Compile(src, compiler.Options{Path: path, StopAfter: compiler.PARSE})
+	var stmts []nodes.TreeNode
+	if res.AST != nil {
+		stmts = res.AST.Children
+	} else if len(res.Tokens) == 0 && !hasStage(diags, compiler.LEX) {
+		// nothing but comments and whitespace, the parser refuses that
+		diags = nil
+	}
+	if diagnostics.HasErrors(diags) {
+		return "", diags
+	}
+	p := &printer{src: src, comments: res.Comments}
+	p.statements(stmts, len(src))
+	return p.sb.String(), diags
+}
+
+func hasStage(diags []*diagnostics.Diagnostic, stage compiler.Stage) bool {
+	for _, d := range diags {
+		if d.Stage == string(stage) {
+			return true
+		}
+	}
+	return false
+}
+
+type printer struct {
+	src string
+	// the ones not printed yet
+	comments []lexer.LexerToken
+	sb       strings.Builder
+	depth    int
+	// source line of what was printed last, 0 at the start of a block
+	lastLine int
+	// separates top level definitions whatever the source did
+	forceBlank bool
+}
+
+// source text of a node, literals are printed as written
+func (p *printer) text(span utils.Span) string {
+	return p.src[span.Start:span.End]
+}
+
+func (p *printer) indent() {
+	for range p.depth {
+		p.sb.WriteString(INDENT)
+	}
+}
+
+// starts an output line for what the source has at line, after a blank
+// line if the source had any before it
+func (p *printer) newLine(line int) {
+	if p.forceBlank || (p.lastLine > 0 && line > p.lastLine+1) {
+		p.sb.WriteString("\n")
+	}
+	p.forceBlank = false
+	p.indent()
+}
+
+// ends the output line, taking along the comment closing the source line
+func (p *printer) endLine(line int) {
+	if len(p.comments) > 0 && p.comments[0].Span().Line == line {
+		p.sb.WriteString(" " + p.comment())
+	}
+	p.sb.WriteString("\n")
+	p.lastLine = line
+}
+
+func (p *printer) comment() string {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+	return strings.TrimRight(c.Text(), " \t")
+}
+
+// prints the comments before offset on lines of their own. Comments
+// inside an expression end up after its statement.
+func (p *printer) commentsBefore(offset int) {
+	for len(p.comments) > 0 && p.comments[0].Span().Start < offset {
+		span := p.comments[0].Span()
+		p.newLine(span.Line)
+		p.sb.WriteString(p.comment())
+		p.endLine(span.EndLine)
+	}
+}
+
+// a statement or struct field with the comments leading up to it
+func (p *printer) item(span utils.Span, print func()) {
+	p.commentsBefore(span.Start)
+	p.newLine(span.Line)
+	print()
+	p.endLine(span.EndLine)
+}
+
+// prints `{` found at line and indents what follows
+func (p *printer) open(line int) {
+	p.sb.WriteString("{")
+	p.endLine(line)
+	p.lastLine = 0
+	p.depth++
+}
+
+// prints the `}` ending span, after the comments left in the block
+func (p *printer) close(span utils.Span) {
+	p.commentsBefore(span.End - 1)
+	p.depth--
+	p.indent()
+	p.sb.WriteString("}")
+}
+
+// end is the offset the statements' block ends at
+func (p *printer) statements(stmts []nodes.TreeNode, end int) {
+	for i, stmt := range stmts {
+		if i > 0 && p.depth == 0 && (isDefinition(stmt) || isDefinition(stmts[i-1])) {
+			p.forceBlank = true
+		}
+		p.item(stmt.Span(), func() { p.statement(stmt) })
+	}
+	p.commentsBefore(end)
+}
+
+func isDefinition(n nodes.TreeNode) bool {
+	switch n.(type) {
+	case *nodes.FuncNode, *nodes.StructDefnNode:
+		return true
+	}
+	return false
+}
+
+func (p *printer) block(scope *nodes.ScopeNode) {
+	p.open(scope.Span().Line)
+	p.statements(scope.Children, scope.Span().End-1)
+	p.close(scope.Span())
+}
+
+func (p *printer) statement(stmt nodes.TreeNode) {
+	switch n := stmt.(type) {
+	case *nodes.FuncNode:
+		args := make([]string, len(n.ArgList))
+		for i, arg := range n.ArgList {
+			args[i] = arg.Name + " " + dataType(arg.DataT)
+		}
+		p.sb.WriteString(lexer.FUNCTION + " " + n.Name + "(" + strings.Join(args, ", ") + ") " + dataType(n.ReturnType) + " ")
+		p.block(n.Scope)
+	case *nodes.StructDefnNode:
+		p.sb.WriteString(lexer.STRUCT + " " + n.Name + " ")
+		p.open(n.NameSpan.Line)
+		for _, field := range n.StructDef.Fields {
+			p.item(field.Span, func() {
+				p.sb.WriteString(field.Name + " " + dataType(field.Type))
+			})
+		}
+		p.close(n.Span())
+	case *nodes.IfNode:
+		for i, branch := range n.Branches {
+			switch {
+			case i == 0:
+				p.sb.WriteString(lexer.IF + " " + p.expr(branch.Condition) + " " + lexer.THEN + " ")
+			case p.isElse(branch):
+				p.sb.WriteString(" " + lexer.ELSE + " ")
+			default:
+				p.sb.WriteString(" " + lexer.ELSE + " " + lexer.IF + " " + p.expr(branch.Condition) + " " + lexer.THEN + " ")
+			}
+			p.block(branch.Scope)
+		}
+	case *nodes.LoopNode:
+		if _, ok := n.Initializer.(*nodes.EmptyPlaceholderNode); ok {
+			p.sb.WriteString(lexer.WHILE + " " + lexer.THAT + " " + p.expr(n.Condition) + " ")
+		} else {
+			init := p.varDecl(n.Initializer.(*nodes.VariableDeclarationNode))
+			p.sb.WriteString(lexer.FOR + " " + init + lexer.SEMICOLON + " " + p.expr(n.Condition) + lexer.SEMICOLON + " " + p.expr(n.Updater) + " ")
+		}
+		p.block(n.Scope)
+	case *nodes.ScopeNode:
+		p.block(n)
+	case *nodes.VariableDeclarationNode:
+		p.sb.WriteString(p.varDecl(n))
+	case *nodes.ReturnNode:
+		p.sb.WriteString(lexer.RETURN + " " + p.expr(n.Value))
+	default:
+		p.sb.WriteString(p.expr(stmt))
+	}
+}
+
+// the parser stands in a `verdad` for the missing condition of `o { ... }`
+func (p *printer) isElse(branch nodes.ConditionalBranch) bool {
+	b, ok := branch.Condition.(*nodes.BooleanNode)
+	return ok && b.BoolVal && p.text(b.Span()) == lexer.ELSE
+}
+
+func (p *printer) varDecl(n *nodes.VariableDeclarationNode) string {
+	decls := make([]string, len(n.Declarations))
+	for i, decl := range n.Declarations {
+		decls[i] = p.expr(decl)
+	}
+	s := lexer.LET + " "
+	if _, inferred := n.DataT.(*nodes.UnspecifiedType); !inferred {
+		s += dataType(n.DataT) + " "
+	}
+	return s + strings.Join(decls, lexer.COMMA+" ")
+}
+
+// types as they are written, DataType.Text is meant for messages
+func dataType(dt nodes.DataType) string {
+	switch dt := dt.(type) {
+	case *nodes.PrefixOfType:
+		if dt.Prefix == nodes.ArrayOf {
+			return lexer.OPEN_SQUARE + dataType(dt.OfType) + lexer.CLOSE_SQUARE
+		}
+		return dt.Prefix.String() + dataType(dt.OfType)
+	case *nodes.StructType:
+		fields := make([]string, len(dt.Fields))
+		for i, f := range dt.Fields {
+			fields[i] = f.Name + " " + dataType(f.Type)
+		}
+		return lexer.LPAREN + strings.Join(fields, lexer.COMMA+" ") + lexer.RPAREN
+	case *nodes.FuncType:
+		args := make([]string, len(dt.ArgTypes))
+		for i, a := range dt.ArgTypes {
+			args[i] = dataType(a)
+		}
+		return lexer.FUNCTION + "(" + strings.Join(args, ", ") + ") " + dataType(dt.ReturnType)
+	}
+	return dt.Text()
+}
@@ -0,0 +1,144 @@
+package lexer_test
+
+import (
+	"he++/compiler"
+	"he++/formatter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// formats src twice, the second pass must leave the first one's output
+// alone and both must parse into the tree src parses into
+func testRoundTrip(t *testing.T, src string) string {
+	t.Helper()
+	once, diags := formatter.Format("test", src)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics %v", diags)
+	}
+	twice, _ := formatter.Format("test", once)
+	if twice != once {
+		t.Errorf("formatting isn't stable, first pass:\n%s\nsecond pass:\n%s", once, twice)
+	}
+	if ast(t, once) != ast(t, src) {
+		t.Errorf("formatting changed the meaning, got\n%s", once)
+	}
+	return once
+}
+
+func ast(t *testing.T, src string) string {
+	res, _ := compiler.Compile(src, compiler.Options{Path: "test", StopAfter: compiler.PARSE})
+	if res.AST == nil {
+		t.Fatalf("no AST for\n%s", src)
+	}
+	var sb strings.Builder
+	res.DumpAST(&sb)
+	return sb.String()
+}
+
+func TestFormat(t *testing.T) {
+	t.Run("Layout", func(t *testing.T) {
+		src := "// header\n\n\n" +
+			"definir c = 8,d=5 // trailing\n" +
+			"estructura P {\n  a int // the a\n b {x int, y [int]}\n}\n" +
+			"funcion f(a int,b &int) int { // after brace\n" +
+			"  definir int x=(a+b)*2\n\n\n\n   definir y = (-a) + *b\n" +
+			"mientras que a<5 { a=a+1 }\n" +
+			"si a==1 entonces { devolver 1 } o si b[0]>2 entonces { devolver f(a, b) } o {\n" +
+			"devolver a > 0 ? x : 0 // three\n}\n" +
+			"  // end of f\n}\n" +
+			"funcion g() vacio {\n para definir i = 0; i < 3; i++ {\n {\n // just a comment\n }\n }\n}\n"
+		want := `// header
+
+definir c = 8, d = 5 // trailing
+
+estructura P {
+    a int // the a
+    b {x int, y [int]}
+}
+
+funcion f(a int, b &int) int { // after brace
+    definir int x = (a + b) * 2
+
+    definir y = -a + *b
+    mientras que a < 5 {
+        a = a + 1
+    }
+    si a == 1 entonces {
+        devolver 1
+    } o si b[0] > 2 entonces {
+        devolver f(a, b)
+    } o {
+        devolver a > 0 ? x : 0 // three
+    }
+    // end of f
+}
+
+funcion g() vacio {
+    para definir i = 0; i < 3; i++ {
+        {
+            // just a comment
+        }
+    }
+}
+`
+		if got := testRoundTrip(t, src); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+	})
+
+	t.Run("Parentheses", func(t *testing.T) {
+		for _, expr := range []string{
+			"a - (b - c)",
+			"(a + b) * c",
+			"a.b.c[2].d()",
+			"(a + b)[1]",
+			"a + -b - c",
+			"-(a + b)",
+			"-(-a)",
+			"(-a)[0] % -a.b",
+			"!(a && b) || !a && b",
+			"*p++",
+			"x = &a",
+			"(a > b ? a : b) + 1",
+			"x = a ? b : c ? d : e",
+			"x = (a ? b : c) ? d : e",
+			"a ? b ? c : d : e",
+			"f(a)(b)",
+			"0x1F + 010",
+			`s = "tab\t"`,
+		} {
+			src := "funcion f() int {\n    " + expr + "\n}\n"
+			if got := testRoundTrip(t, src); got != src {
+				t.Errorf("expected %s to be kept as is, got\n%s", expr, got)
+			}
+		}
+	})
+
+	t.Run("Samples", func(t *testing.T) {
+		paths, _ := filepath.Glob("../samples/*")
+		programs, _ := filepath.Glob("../samples/programs/*.lg")
+		for _, path := range append(paths, programs...) {
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				continue
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, diags := formatter.Format(path, string(src)); len(diags) != 0 {
+				// not all samples are valid he++
+				continue
+			}
+			testRoundTrip(t, string(src))
+		}
+	})
+
+	t.Run("Syntax errors", func(t *testing.T) {
+		out, diags := formatter.Format("test", "funcion f( {\n")
+		if out != "" || len(diags) == 0 {
+			t.Errorf("expected a syntax error instead of %q", out)
+		}
+	})
+}
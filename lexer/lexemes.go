@@ -13,6 +13,9 @@ var FLOATINGPT = LexerTokenType("floatingpt")
 var STRING_LITERAL = LexerTokenType("string_literal")
 var BOOLEAN_LITERAL = LexerTokenType("boolean_literal")
 
+// never sent to the parser, see Lexer.Comments
+var COMMENT = LexerTokenType("comment")
+
 // keywords
 var IF = "si"
 var THEN = "entonces"
@@ -62,8 +65,8 @@ var MODULO = "%"
 var NOT = "!"
 var PIPE = "|"
 var AMP = "&"
-var LSHIFT = "<<" // todo: not recognized by lexer
-var RSHIFT = ">>" // not recognized by lexer
+var LSHIFT = "<<"
+var RSHIFT = ">>"
 
 // logical operators
 var LESS = "<"
@@ -111,6 +114,8 @@ var Operators = map[string]bool{
 	MUL:     true,
 	DIV:     true,
 	MODULO:  true,
+	LSHIFT:  true,
+	RSHIFT:  true,
 	LESS:    true,
 	GREATER: true,
 	NOT:     true,
@@ -217,13 +222,19 @@ func isBracket(c string) bool {
 }
 
 func isPunctuation(c string) bool {
-	return c == SEMICOLON
+	return c == SEMICOLON || c == COLON
 }
 
 func isKeyword(c string) bool {
 	return KwTrie.Search(c)
 }
 
+// letters, digits, underscores and any non ascii byte, so that
+// identifiers like `año` work
+func isIdentifierPart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c) || c == byte(MATH_COMMA) || c >= 0x80
+}
+
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
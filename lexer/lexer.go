@@ -1,159 +1,245 @@
-package lexer
-
-import (
-	"fmt"
-	"he++/utils"
-	"strings"
-)
-
-type LexerTokenType string
-
-func (m LexerTokenType) String() string {
-	return string(m)
-}
-
-type LexerToken struct {
-	tokenType LexerTokenType
-	ref       string
-	lineNo    int
-}
-
-func NewLexerToken(tokenType LexerTokenType, ref string, lineNo int) LexerToken {
-	return LexerToken{tokenType, ref, lineNo}
-}
-
-func (m LexerToken) String() string {
-	return fmt.Sprintf("%s %s %s", utils.Blue(string(m.tokenType)), utils.Yellow(m.ref), utils.Red(fmt.Sprint(m.lineNo)))
-}
-
-func (l LexerToken) Text() string {
-	return l.ref
-}
-
-func (l LexerToken) Type() LexerTokenType {
-	return l.tokenType
-}
-
-func (l LexerToken) LineNo() int {
-	return l.lineNo
-}
-
-type Warning struct {
-	Msg  string
-	Line int
-}
-
-type Lexer struct {
-	Path       string
-	sourceCode string
-	i          int
-	lineCnt    int
-	TokChan    chan LexerToken
-	tokens     []LexerToken
-	word       strings.Builder
-	warnings   []Warning
-}
-
-func (l *Lexer) CharAtOffset(offset int) byte {
-	i := l.i + offset
-	if i >= len(l.sourceCode) || i < 0 {
-		return 0
-	}
-	return l.sourceCode[i]
-}
-
-func LexerOf(srcPath string) *Lexer {
-	// read the file piece by piece instead of reading all at once.
-	src := utils.ReadFileContent(srcPath)
-	return &Lexer{Path: srcPath, sourceCode: string(src), i: 0, lineCnt: 1, TokChan: make(chan LexerToken, 1000), word: strings.Builder{}}
-}
-
-func (l *Lexer) addWarning(err string, line int) {
-	l.warnings = append(l.warnings, Warning{Line: line, Msg: err})
-}
-
-func (l *Lexer) PrintLexemes() {
-	for _, token := range l.tokens {
-		fmt.Println(token)
-	}
-
-	if len(l.warnings) == 0 {
-		return
-	}
-	fmt.Println("Warnings:")
-	for _, err := range l.warnings {
-		fmt.Println(err)
-	}
-}
-
-func (l *Lexer) makeToken(word string) LexerToken {
-	if isKeyword(word) {
-		return LexerToken{KEYWORD, word, l.lineCnt}
-	}
-	return LexerToken{IDENTIFIER, word, l.lineCnt}
-}
-
-func (l *Lexer) GetTokens() []LexerToken {
-	return l.tokens
-}
-
-func (l *Lexer) addTokenAndClearWord(token LexerToken) {
-	// todo: only append to l.tokens if in debug mode
-	l.tokens = append(l.tokens, token)
-	l.TokChan <- token
-	l.word.Reset()
-}
-
-func (l *Lexer) addTokenIfCan() {
-	if l.word.Len() != 0 {
-		l.addTokenAndClearWord(l.makeToken(l.word.String()))
-	}
-}
-
-func (l *Lexer) addOperatorToken(op string) {
-	l.addTokenAndClearWord(NewLexerToken(OPERATOR, op, l.lineCnt))
-}
-
-func (l *Lexer) tryOperator() bool {
-	if l.i+1 >= len(l.sourceCode) {
-		return false
-	}
-	offset := OpTrie.MatchLongest(l.sourceCode, l.i)
-	if offset != -1 {
-		l.addTokenIfCan()
-		l.addOperatorToken(l.sourceCode[l.i : l.i+1+offset])
-		l.i += offset
-	} else {
-		return false
-	}
-	return true
-}
-
-func (l *Lexer) escapeSequence(c byte) string {
-	ret := ""
-	switch c {
-	case 'n':
-		ret += "\n"
-	case 't':
-		ret += "\t"
-	case 'r':
-		ret += "\r"
-	case 'b':
-		ret += "\b"
-	case 'f':
-		ret += "\f"
-	case '\\':
-		ret += "\\"
-	case '\'':
-		ret += "`"
-	case '"':
-		ret += "\""
-	default:
-		l.addWarning(fmt.Sprintf("Ignored escape sequence %s at line %d", utils.Blue(fmt.Sprintf("\"\\%c\"", c)), l.lineCnt), l.lineCnt)
-	}
-	return ret
-}
-
-func (l *Lexer) isThisLexicalQuote() bool {
-	return isQuote(l.CharAtOffset(0)) && (l.CharAtOffset(-1) != '\\')
-}
+package lexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"he++/diagnostics"
+	"he++/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+type LexerTokenType string
+
+func (m LexerTokenType) String() string {
+	return string(m)
+}
+
+type LexerToken struct {
+	tokenType LexerTokenType
+	ref       string
+	span      utils.Span
+}
+
+func NewLexerToken(tokenType LexerTokenType, ref string, span utils.Span) LexerToken {
+	return LexerToken{tokenType, ref, span}
+}
+
+func (m LexerToken) String() string {
+	return fmt.Sprintf("%s %s %s", utils.Blue(string(m.tokenType)), utils.Yellow(m.ref), utils.Red(fmt.Sprintf("%d:%d", m.span.Line, m.span.Col)))
+}
+
+func (l LexerToken) Text() string {
+	return l.ref
+}
+
+func (l LexerToken) Type() LexerTokenType {
+	return l.tokenType
+}
+
+func (l LexerToken) LineNo() int {
+	return l.span.Line
+}
+
+// where the token's text sits in the source, quotes included for strings
+func (l LexerToken) Span() utils.Span {
+	return l.span
+}
+
+// numbers are stored as their big endian encoding, this decodes them
+// back into something readable.
+func (l LexerToken) DisplayText() string {
+	switch l.tokenType {
+	case INTEGER:
+		var num int64
+		binary.Read(bytes.NewReader([]byte(l.ref)), binary.BigEndian, &num)
+		return fmt.Sprint(num)
+	case FLOATINGPT:
+		var num float64
+		binary.Read(bytes.NewReader([]byte(l.ref)), binary.BigEndian, &num)
+		return fmt.Sprint(num)
+	case STRING_LITERAL:
+		return fmt.Sprintf("%q", l.ref)
+	}
+	return l.ref
+}
+
+type Lexer struct {
+	Path       string
+	sourceCode string
+	i          int
+	lineCnt    int
+	// offsets at which each line begins
+	lineStarts []int
+	// offset of the first byte of word
+	wordStart int
+	TokChan   chan LexerToken
+	tokens    []LexerToken
+	// `//` comments, kept apart so that the parser doesn't have to skip them
+	comments []LexerToken
+	word     strings.Builder
+	// malformed input is reported and skipped
+	diags []*diagnostics.Diagnostic
+}
+
+func (l *Lexer) CharAtOffset(offset int) byte {
+	i := l.i + offset
+	if i >= len(l.sourceCode) || i < 0 {
+		return 0
+	}
+	return l.sourceCode[i]
+}
+
+func LexerOf(srcPath string) *Lexer {
+	// read the file piece by piece instead of reading all at once.
+	src := utils.ReadFileContent(srcPath)
+	return NewLexer(srcPath, string(src))
+}
+
+// lexer over in-memory source, path is only used for reporting
+func NewLexer(path string, src string) *Lexer {
+	lineStarts := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return &Lexer{Path: path, sourceCode: src, i: 0, lineCnt: 1, lineStarts: lineStarts, TokChan: make(chan LexerToken, 1000), word: strings.Builder{}}
+}
+
+// line and column of a byte offset
+func (l *Lexer) position(offset int) (int, int) {
+	line := sort.Search(len(l.lineStarts), func(i int) bool { return l.lineStarts[i] > offset })
+	return line, offset - l.lineStarts[line-1] + 1
+}
+
+// span of the source bytes [start, end)
+func (l *Lexer) spanOf(start int, end int) utils.Span {
+	end = min(end, len(l.sourceCode))
+	span := utils.Span{File: l.Path, Start: start, End: end}
+	span.Line, span.Col = l.position(start)
+	span.EndLine, span.EndCol = l.position(end)
+	return span
+}
+
+func (l *Lexer) addWarning(err string, span utils.Span) {
+	l.diags = append(l.diags, diagnostics.Warning(span, err))
+}
+
+func (l *Lexer) addError(err string, span utils.Span) {
+	l.diags = append(l.diags, diagnostics.Error(span, diagnostics.LexicalError, err))
+}
+
+func (l *Lexer) Diagnostics() []*diagnostics.Diagnostic {
+	return l.diags
+}
+
+// line the lexer has reached
+func (l *Lexer) CurrentLine() int {
+	return l.lineCnt
+}
+
+func (l *Lexer) PrintLexemes() {
+	for _, token := range l.tokens {
+		fmt.Println(token)
+	}
+
+	if len(l.diags) == 0 {
+		return
+	}
+	fmt.Println("Diagnostics:")
+	for _, d := range l.diags {
+		fmt.Println(d.Error())
+	}
+}
+
+// uncolored, one token per line
+func (l *Lexer) DumpTokens(w io.Writer) {
+	WriteTokens(w, l.tokens)
+}
+
+func WriteTokens(w io.Writer, tokens []LexerToken) {
+	for _, token := range tokens {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", token.span.Line, token.tokenType, token.DisplayText())
+	}
+}
+
+func (l *Lexer) makeToken(word string) LexerToken {
+	span := l.spanOf(l.wordStart, l.wordStart+len(word))
+	if isKeyword(word) {
+		return LexerToken{KEYWORD, word, span}
+	}
+	return LexerToken{IDENTIFIER, word, span}
+}
+
+func (l *Lexer) GetTokens() []LexerToken {
+	return l.tokens
+}
+
+// comments in source order, with the leading `//`. Complete once TokChan
+// is closed.
+func (l *Lexer) Comments() []LexerToken {
+	return l.comments
+}
+
+func (l *Lexer) addTokenAndClearWord(token LexerToken) {
+	// todo: only append to l.tokens if in debug mode
+	l.tokens = append(l.tokens, token)
+	l.TokChan <- token
+	l.word.Reset()
+}
+
+func (l *Lexer) addTokenIfCan() {
+	if l.word.Len() != 0 {
+		l.addTokenAndClearWord(l.makeToken(l.word.String()))
+	}
+}
+
+func (l *Lexer) addOperatorToken(op string) {
+	l.addTokenAndClearWord(NewLexerToken(OPERATOR, op, l.spanOf(l.i, l.i+len(op))))
+}
+
+// MatchLongest stops at the end of the source, so an operator ending it
+// is matched as well
+func (l *Lexer) tryOperator() bool {
+	offset := OpTrie.MatchLongest(l.sourceCode, l.i)
+	if offset != -1 {
+		l.addTokenIfCan()
+		l.addOperatorToken(l.sourceCode[l.i : l.i+1+offset])
+		l.i += offset
+	} else {
+		return false
+	}
+	return true
+}
+
+func (l *Lexer) escapeSequence(c byte) string {
+	ret := ""
+	switch c {
+	case 'n':
+		ret += "\n"
+	case 't':
+		ret += "\t"
+	case 'r':
+		ret += "\r"
+	case 'b':
+		ret += "\b"
+	case 'f':
+		ret += "\f"
+	case '\\':
+		ret += "\\"
+	case '\'':
+		ret += "`"
+	case '"':
+		ret += "\""
+	default:
+		l.addWarning(fmt.Sprintf("Ignored escape sequence %s", utils.Blue(fmt.Sprintf("\"\\%c\"", c))), l.spanOf(l.i-1, l.i+1))
+	}
+	return ret
+}
+
+func (l *Lexer) isThisLexicalQuote() bool {
+	return isQuote(l.CharAtOffset(0)) && (l.CharAtOffset(-1) != '\\')
+}
@@ -1,12 +1,14 @@
 package lexer
 
 import (
+	"he++/diagnostics"
+	"he++/utils"
 	"testing"
 )
 
 func TestLexify(t *testing.T) {
 	t.Run("Basic assignment", func(t *testing.T) {
-		testLexerExpectTokens(t, "definir x = 10", []LexerToken{
+		testLexerExpectTokens(t, "definir x = 10", []expectedToken{
 			{"keyword", "definir", 1},
 			{"identifier", "x", 1},
 			{"operator", "=", 1},
@@ -15,7 +17,7 @@ func TestLexify(t *testing.T) {
 	})
 
 	t.Run("Array in object declaration", func(t *testing.T) {
-		testLexerExpectTokens(t, "definir a = { name: \" Kislay \" ,\n roll: [ 12, 13 ] };", []LexerToken{
+		testLexerExpectTokens(t, "definir a = { name: \" Kislay \" ,\n roll: [ 12, 13 ] };", []expectedToken{
 			{"keyword", "definir", 1},
 			{"identifier", "a", 1},
 			{"operator", "=", 1},
@@ -23,12 +25,12 @@ func TestLexify(t *testing.T) {
 			{"identifier", "name", 1},
 			{"punctuation", ":", 1},
 			{"string_literal", " Kislay ", 1},
-			{"punctuation", ",", 1},
+			{"operator", ",", 1},
 			{"identifier", "roll", 2},
 			{"punctuation", ":", 2},
 			{"bracket", "[", 2},
 			{"int", "12", 2},
-			{"punctuation", ",", 2},
+			{"operator", ",", 2},
 			{"int", "13", 2},
 			{"bracket", "]", 2},
 			{"bracket", "}", 2},
@@ -37,7 +39,7 @@ func TestLexify(t *testing.T) {
 	})
 
 	t.Run("Expressions: Unary operators", func(t *testing.T) {
-		testLexerExpectTokens(t, "a+++b--*c/d%e", []LexerToken{
+		testLexerExpectTokens(t, "a+++b--*c/d%e", []expectedToken{
 			{"identifier", "a", 1},
 			{"operator", "++", 1},
 			{"operator", "+", 1},
@@ -53,7 +55,7 @@ func TestLexify(t *testing.T) {
 	})
 
 	t.Run("Expressions: Binary operators", func(t *testing.T) {
-		testLexerExpectTokens(t, "a+3*b(4,5--) == 0", []LexerToken{
+		testLexerExpectTokens(t, "a+3*b(4,5--) == 0", []expectedToken{
 			{"identifier", "a", 1},
 			{"operator", "+", 1},
 			{"int", "3", 1},
@@ -61,7 +63,7 @@ func TestLexify(t *testing.T) {
 			{"identifier", "b", 1},
 			{"bracket", "(", 1},
 			{"int", "4", 1},
-			{"punctuation", ",", 1},
+			{"operator", ",", 1},
 			{"int", "5", 1},
 			{"operator", "--", 1},
 			{"bracket", ")", 1},
@@ -70,20 +72,20 @@ func TestLexify(t *testing.T) {
 		})
 	})
 	t.Run("String with escape sequences", func(t *testing.T) {
-		testLexerExpectTokens(t, "\"Hello,\\f \\nWor\\tld!\\z\"", []LexerToken{
+		testLexerExpectTokens(t, "\"Hello,\\f \\nWor\\tld!\\z\"", []expectedToken{
 			{"string_literal", "Hello,\f \nWor\tld!", 1},
 		})
 	})
 
 	t.Run("Floating point numbers", func(t *testing.T) {
-		testLexerExpectTokens(t, "definir x = 10.5", []LexerToken{
+		testLexerExpectTokens(t, "definir x = 10.5", []expectedToken{
 			{"keyword", "definir", 1},
 			{"identifier", "x", 1},
 			{"operator", "=", 1},
 			{"floatingpt", "10.5", 1},
 		})
 
-		testLexerExpectTokens(t, "definir x = 5.56.78", []LexerToken{
+		testLexerExpectTokens(t, "definir x = 5.56.78", []expectedToken{
 			{"keyword", "definir", 1},
 			{"identifier", "x", 1},
 			{"operator", "=", 1},
@@ -95,29 +97,106 @@ func TestLexify(t *testing.T) {
 	})
 
 	t.Run("Numbers in different bases", func(t *testing.T) {
-		testLexerExpectTokens(t, "0xDEADBEEF 0123 012389 0xA.5.076  0xYEAH 099 009DEH", []LexerToken{
-			{"int", "DEADBEEF", 1},
-			{"int", "0123", 1},
-			{"int", "0123", 1},
+		// a prefix without digits after it is a 0
+		testLexerExpectTokens(t, "0xDEADBEEF 0123 012389 0xA.5.076  0xYEAH 099 009DEH", []expectedToken{
+			{"int", "3735928559", 1},
+			{"int", "83", 1},
+			{"int", "83", 1},
 			{"int", "89", 1},
-			{"floatingpt", "A.5", 1},
+			{"floatingpt", "10.3125", 1},
 			{"operator", ".", 1},
-			{"int", "076", 1},
+			{"int", "62", 1},
+			{"int", "0", 1},
 			{"identifier", "YEAH", 1},
 			{"int", "0", 1},
 			{"int", "99", 1},
-			{"int", "00", 1},
+			{"int", "0", 1},
 			{"int", "9", 1},
 			{"identifier", "DEH", 1},
 		})
 
-		testLexerExpectTokens(t, " 0xYEAH 099 0b301", []LexerToken{
+		testLexerExpectTokens(t, " 0xYEAH 099 0b301 0xbeef", []expectedToken{
+			{"int", "0", 1},
 			{"identifier", "YEAH", 1},
 			{"int", "0", 1},
 			{"int", "99", 1},
+			{"int", "0", 1},
 			{"int", "301", 1},
+			{"int", "48879", 1},
+		})
+
+	})
+
+	t.Run("Spans", func(t *testing.T) {
+		lexer := NewLexer("test", "definir x = \"ab\"\n  f(10)")
+		go lexer.Lexify()
+		for range lexer.TokChan {
+		}
+		expected := []utils.Span{
+			{File: "test", Line: 1, Col: 1, EndLine: 1, EndCol: 8, Start: 0, End: 7},
+			{File: "test", Line: 1, Col: 9, EndLine: 1, EndCol: 10, Start: 8, End: 9},
+			{File: "test", Line: 1, Col: 11, EndLine: 1, EndCol: 12, Start: 10, End: 11},
+			{File: "test", Line: 1, Col: 13, EndLine: 1, EndCol: 17, Start: 12, End: 16},
+			{File: "test", Line: 2, Col: 3, EndLine: 2, EndCol: 4, Start: 19, End: 20},
+			{File: "test", Line: 2, Col: 4, EndLine: 2, EndCol: 5, Start: 20, End: 21},
+			{File: "test", Line: 2, Col: 5, EndLine: 2, EndCol: 7, Start: 21, End: 23},
+			{File: "test", Line: 2, Col: 7, EndLine: 2, EndCol: 8, Start: 23, End: 24},
+		}
+		if len(lexer.tokens) != len(expected) {
+			t.Fatalf("expected %d tokens, got %v", len(expected), lexer.tokens)
+		}
+		for i, tok := range lexer.tokens {
+			if tok.Span() != expected[i] {
+				t.Errorf("expected span %+v for %s, got %+v", expected[i], tok.Text(), tok.Span())
+			}
+		}
+	})
+
+	t.Run("Comments", func(t *testing.T) {
+		src := "// head\r\ndefinir x = 1 // tail\r\nx"
+		testLexerExpectTokens(t, src, []expectedToken{
+			{"keyword", "definir", 2},
+			{"identifier", "x", 2},
+			{"operator", "=", 2},
+			{"int", "1", 2},
+			{"identifier", "x", 3},
+		})
+		lexer := NewLexer("test", src)
+		go lexer.Lexify()
+		for range lexer.TokChan {
+		}
+		comments := lexer.Comments()
+		if len(comments) != 2 || comments[0].Text() != "// head" || comments[1].Text() != "// tail" {
+			t.Fatalf("expected both comments without line endings, got %v", comments)
+		}
+		if span := comments[1].Span(); span.Line != 2 || span.Col != 15 || span.End != 30 {
+			t.Errorf("unexpected span %+v", span)
+		}
+	})
+
+	t.Run("Operator ending the source", func(t *testing.T) {
+		testLexerExpectTokens(t, "a +", []expectedToken{
+			{"identifier", "a", 1},
+			{"operator", "+", 1},
 		})
+		testLexerExpectTokens(t, "a<=", []expectedToken{
+			{"identifier", "a", 1},
+			{"operator", "<=", 1},
+		})
+	})
 
+	t.Run("Unterminated strings", func(t *testing.T) {
+		// the last one ends in the middle of an escape sequence
+		for _, src := range []string{"s = \"abc", "s = \"abc\\"} {
+			lexer := NewLexer("test", src)
+			go lexer.Lexify()
+			for range lexer.TokChan {
+			}
+			diags := lexer.Diagnostics()
+			if len(diags) != 1 || diags[0].Kind != diagnostics.LexicalError || diags[0].Msg != "Unterminated string literal" {
+				t.Errorf("expected an unterminated string in %q, got %v", src, diags)
+			}
+		}
 	})
 
 	// todo: Add tests for warnings
@@ -126,9 +205,18 @@ func TestLexify(t *testing.T) {
 	}
 }
 
-func testLexerExpectTokens(t *testing.T, sourceCode string, expectedTokens []LexerToken) {
-	lexer := LexerOf(sourceCode)
+// tokens are compared by type, text and line, numbers by their value
+type expectedToken struct {
+	tokenType LexerTokenType
+	ref       string
+	lineNo    int
+}
+
+func testLexerExpectTokens(t *testing.T, sourceCode string, expectedTokens []expectedToken) {
+	lexer := NewLexer("test", sourceCode)
 	go lexer.Lexify()
+	for range lexer.TokChan {
+	}
 	tokens := lexer.tokens
 
 	if len(tokens) != len(expectedTokens) {
@@ -137,7 +225,12 @@ func testLexerExpectTokens(t *testing.T, sourceCode string, expectedTokens []Lex
 	}
 
 	for i, token := range tokens {
-		if token != expectedTokens[i] {
+		e := expectedTokens[i]
+		text := token.ref
+		if token.tokenType == INTEGER || token.tokenType == FLOATINGPT {
+			text = token.DisplayText()
+		}
+		if token.tokenType != e.tokenType || text != e.ref || token.LineNo() != e.lineNo {
 			t.Log("\033[31mFailed!\033[0m Tokens:", tokens)
 			t.Errorf("expected token %v, got %v", expectedTokens[i], token)
 		}
@@ -0,0 +1,115 @@
+package lsp
+
+import (
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/utils"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+)
+
+// an open file and what the compiler made of it
+type document struct {
+	uri  string
+	path string
+	text string
+	// offsets at which each line begins
+	lineStarts []int
+	res        *compiler.Result
+	diags      []*diagnostics.Diagnostic
+}
+
+func newDocument(uri string, text string) *document {
+	d := &document{uri: uri, path: uriToPath(uri)}
+	d.update(text)
+	return d
+}
+
+// runs the front end over the new text
+func (d *document) update(text string) {
+	d.text = text
+	d.lineStarts = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	d.res, d.diags = compiler.Compile(text, compiler.Options{Path: d.path, StopAfter: compiler.ANALYZE})
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// byte offset of an LSP position, clamped to the line
+func (d *document) offsetAt(pos Position) int {
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	offset := d.lineStarts[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+func (d *document) positionAt(offset int) Position {
+	offset = min(max(offset, 0), len(d.text))
+	line := sort.Search(len(d.lineStarts), func(i int) bool { return d.lineStarts[i] > offset }) - 1
+	units := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		units += utf16Len(r)
+	}
+	return Position{Line: line, Character: units}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func (d *document) rangeOf(span utils.Span) Range {
+	if !span.IsValid() {
+		return Range{}
+	}
+	return Range{Start: d.positionAt(span.Start), End: d.positionAt(span.End)}
+}
+
+func (d *document) lspDiagnostics() []Diagnostic {
+	out := make([]Diagnostic, 0, len(d.diags))
+	for _, diag := range d.diags {
+		ld := Diagnostic{
+			Range:    d.rangeOf(diag.Span),
+			Severity: SEVERITY_ERROR,
+			Code:     string(diag.Kind),
+			Source:   "he++",
+			Message:  diagnostics.Plain(diag.Msg),
+		}
+		switch diag.Severity {
+		case diagnostics.WARNING:
+			ld.Severity = SEVERITY_WARNING
+		case diagnostics.NOTE:
+			ld.Severity = SEVERITY_INFORMATION
+		}
+		for _, l := range diag.Labels {
+			ld.RelatedInformation = append(ld.RelatedInformation, DiagnosticRelatedInformation{
+				Location: Location{URI: d.uri, Range: d.rangeOf(l.Span)},
+				Message:  diagnostics.Plain(l.Msg),
+			})
+		}
+		for _, n := range diag.Notes {
+			ld.Message += "\nnote: " + diagnostics.Plain(n)
+		}
+		out = append(out, ld)
+	}
+	return out
+}
@@ -0,0 +1,316 @@
+// Package lsp serves he++ sources to editors over the Language Server
+// Protocol. It runs the same lexer, parser and analyzer as the compiler on
+// every change and answers from what they found.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"he++/parser/node_types"
+	staticanalyzer "he++/static_analyzer"
+	"io"
+	"sync"
+)
+
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+	// serializes writes to out
+	mu   sync.Mutex
+	docs map[string]*document
+	// receives protocol errors, discarded if nil
+	Log      io.Writer
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: make(map[string]*document)}
+}
+
+var errExit = errors.New("exit")
+
+// serves requests until the client sends `exit` or closes the input
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.logf("malformed message: %v", err)
+			s.reply(nil, nil, &rpcError{Code: PARSE_ERROR, Message: err.Error()})
+			continue
+		}
+		if err := s.handle(&req); err != nil {
+			if errors.Is(err, errExit) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+type handler func(s *Server, params json.RawMessage) (any, error)
+
+var requests = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"shutdown":                    (*Server).shutdownRequest,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+}
+
+var notifications = map[string]handler{
+	"initialized":            func(*Server, json.RawMessage) (any, error) { return nil, nil },
+	"textDocument/didOpen":   (*Server).didOpen,
+	"textDocument/didChange": (*Server).didChange,
+	"textDocument/didClose":  (*Server).didClose,
+}
+
+func (s *Server) handle(req *request) error {
+	if req.ID == nil {
+		if req.Method == "exit" {
+			return errExit
+		}
+		if h, ok := notifications[req.Method]; ok {
+			if _, err := s.call(h, req.Params); err != nil {
+				s.logf("%s: %v", req.Method, err)
+			}
+		}
+		// unknown notifications are to be ignored
+		return nil
+	}
+	if s.shutdown {
+		return s.reply(req.ID, nil, &rpcError{Code: INVALID_REQUEST, Message: "server is shutting down"})
+	}
+	h, ok := requests[req.Method]
+	if !ok {
+		return s.reply(req.ID, nil, &rpcError{Code: METHOD_NOT_FOUND, Message: "method not found: " + req.Method})
+	}
+	result, err := s.call(h, req.Params)
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = &rpcError{Code: INTERNAL_ERROR, Message: err.Error()}
+		}
+		return s.reply(req.ID, nil, rerr)
+	}
+	return s.reply(req.ID, result, nil)
+}
+
+// a crashing handler must not take the editor session down with it
+func (s *Server) call(h handler, params json.RawMessage) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &rpcError{Code: INTERNAL_ERROR, Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+	return h(s, params)
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, rerr *rpcError) error {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg := json.RawMessage(raw)
+		resp.Result = &msg
+	}
+	return s.send(resp)
+}
+
+func (s *Server) notify(method string, params any) error {
+	return s.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) send(msg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeMessage(s.out, msg)
+}
+
+func (s *Server) logf(format string, a ...any) {
+	if s.Log != nil {
+		fmt.Fprintf(s.Log, "he++ lsp: "+format+"\n", a...)
+	}
+}
+
+func decode[T any](params json.RawMessage) (*T, error) {
+	var p T
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{Code: INVALID_PARAMS, Message: err.Error()}
+	}
+	return &p, nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &rpcError{Code: INVALID_PARAMS, Message: "document not open: " + uri}
+	}
+	return doc, nil
+}
+
+func (s *Server) initialize(json.RawMessage) (any, error) {
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:       TextDocumentSyncOptions{OpenClose: true, Change: SYNC_FULL},
+			HoverProvider:          true,
+			DefinitionProvider:     true,
+			DocumentSymbolProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "he++"},
+	}, nil
+}
+
+func (s *Server) shutdownRequest(json.RawMessage) (any, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (any, error) {
+	p, err := decode[DidOpenTextDocumentParams](params)
+	if err != nil {
+		return nil, err
+	}
+	doc := newDocument(p.TextDocument.URI, p.TextDocument.Text)
+	s.docs[doc.uri] = doc
+	return nil, s.publish(doc)
+}
+
+func (s *Server) didChange(params json.RawMessage) (any, error) {
+	p, err := decode[DidChangeTextDocumentParams](params)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil || len(p.ContentChanges) == 0 {
+		return nil, err
+	}
+	doc.update(p.ContentChanges[len(p.ContentChanges)-1].Text)
+	return nil, s.publish(doc)
+}
+
+func (s *Server) didClose(params json.RawMessage) (any, error) {
+	p, err := decode[DidCloseTextDocumentParams](params)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.docs, p.TextDocument.URI)
+	return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI: p.TextDocument.URI, Diagnostics: []Diagnostic{},
+	})
+}
+
+func (s *Server) publish(doc *document) error {
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI: doc.uri, Diagnostics: doc.lspDiagnostics(),
+	})
+}
+
+// the analyzer's findings at a position, nil when analysis didn't run
+func (s *Server) lookup(params json.RawMessage) (*document, *staticanalyzer.SourceInfo, int, error) {
+	p, err := decode[TextDocumentPositionParams](params)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return doc, doc.res.Info, doc.offsetAt(p.Position), nil
+}
+
+func (s *Server) hover(params json.RawMessage) (any, error) {
+	doc, info, offset, err := s.lookup(params)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	if sym, ok := info.SymbolAt(offset); ok && sym.Type != nil {
+		r := doc.rangeOf(sym.Span)
+		for _, ref := range info.Refs {
+			if ref.Sym == sym && ref.Span.Start <= offset && offset < ref.Span.End {
+				r = doc.rangeOf(ref.Span)
+			}
+		}
+		return Hover{Contents: codeBlock(sym.Name + ": " + sym.Type.Text()), Range: &r}, nil
+	}
+	if t, ok := info.TypeAt(offset); ok && t.Type != nil {
+		r := doc.rangeOf(t.Node.Span())
+		return Hover{Contents: codeBlock(t.Type.Text()), Range: &r}, nil
+	}
+	return nil, nil
+}
+
+func codeBlock(s string) MarkupContent {
+	return MarkupContent{Kind: "markdown", Value: "```he++\n" + s + "\n```"}
+}
+
+func (s *Server) definition(params json.RawMessage) (any, error) {
+	doc, info, offset, err := s.lookup(params)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	sym, ok := info.SymbolAt(offset)
+	if !ok || !sym.Span.IsValid() {
+		return nil, nil
+	}
+	return Location{URI: doc.uri, Range: doc.rangeOf(sym.Span)}, nil
+}
+
+// functions with their arguments, and structs. Works off the AST alone,
+// so an outline is there even when analysis failed.
+func (s *Server) documentSymbol(params json.RawMessage) (any, error) {
+	p, err := decode[DocumentSymbolParams](params)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]DocumentSymbol, 0)
+	if doc.res.AST == nil {
+		return symbols, nil
+	}
+	for _, child := range doc.res.AST.Children {
+		switch n := child.(type) {
+		case *node_types.FuncNode:
+			fn := DocumentSymbol{
+				Name:           n.Name,
+				Kind:           SYMBOL_FUNCTION,
+				Range:          doc.rangeOf(n.Span()),
+				SelectionRange: doc.rangeOf(n.NameSpan),
+			}
+			if n.ReturnType != nil {
+				fn.Detail = n.ReturnType.Text()
+			}
+			for _, arg := range n.ArgList {
+				fn.Children = append(fn.Children, DocumentSymbol{
+					Name:           arg.Name,
+					Detail:         arg.DataT.Text(),
+					Kind:           SYMBOL_VARIABLE,
+					Range:          doc.rangeOf(arg.Span),
+					SelectionRange: doc.rangeOf(arg.Span),
+				})
+			}
+			symbols = append(symbols, fn)
+		case *node_types.StructDefnNode:
+			symbols = append(symbols, DocumentSymbol{
+				Name:           n.Name,
+				Kind:           SYMBOL_STRUCT,
+				Range:          doc.rangeOf(n.Span()),
+				SelectionRange: doc.rangeOf(n.NameSpan),
+			})
+		}
+	}
+	return symbols, nil
+}
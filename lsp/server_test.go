@@ -0,0 +1,274 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"he++/lsp"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const uri = "file:///tmp/test.lg"
+
+const source = `estructura Punto {
+    x int
+    y int
+}
+
+funcion doble(n int) int {
+    devolver n * 2
+}
+
+funcion principal() int {
+    definir int a = doble(4)
+    devolver a
+}
+`
+
+// drives a Server through pipes like an editor would
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	nextID int
+	// notifications received while waiting for a response
+	pending []message
+	done    chan error
+}
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, w: clientOut, r: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		c.done <- lsp.NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+	}()
+	t.Cleanup(func() {
+		clientOut.Close()
+		clientIn.Close()
+	})
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("initialized", map[string]any{})
+	return c
+}
+
+func (c *client) write(msg map[string]any) {
+	msg["jsonrpc"] = "2.0"
+	body, _ := json.Marshal(msg)
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *client) read() message {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read header: %v", err)
+	}
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("read body: %v", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("bad message %s: %v", body, err)
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params any) {
+	c.write(map[string]any{"method": method, "params": params})
+}
+
+// sends a request and decodes its result into result, returns the response
+func (c *client) call(method string, params any, result any) message {
+	c.nextID++
+	id := c.nextID
+	c.write(map[string]any{"id": id, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if msg.ID == nil {
+			c.pending = append(c.pending, msg)
+			continue
+		}
+		if *msg.ID != id {
+			c.t.Fatalf("response to %d while waiting for %d", *msg.ID, id)
+		}
+		if result != nil && msg.Error == nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("%s result %s: %v", method, msg.Result, err)
+			}
+		}
+		return msg
+	}
+}
+
+func (c *client) diagnostics() lsp.PublishDiagnosticsParams {
+	var msg message
+	if len(c.pending) > 0 {
+		msg, c.pending = c.pending[0], c.pending[1:]
+	} else {
+		msg = c.read()
+	}
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %q", msg.Method)
+	}
+	var p lsp.PublishDiagnosticsParams
+	json.Unmarshal(msg.Params, &p)
+	return p
+}
+
+func (c *client) open(text string) lsp.PublishDiagnosticsParams {
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "he++", "version": 1, "text": text},
+	})
+	return c.diagnostics()
+}
+
+func at(line, char int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": char},
+	}
+}
+
+func pos(line, char int) lsp.Position {
+	return lsp.Position{Line: line, Character: char}
+}
+
+func TestServer(t *testing.T) {
+	t.Run("Initialize", func(t *testing.T) {
+		c := newClient(t)
+		var res lsp.InitializeResult
+		c.call("initialize", map[string]any{}, &res)
+		caps := res.Capabilities
+		if caps.TextDocumentSync.Change != lsp.SYNC_FULL || !caps.HoverProvider || !caps.DefinitionProvider || !caps.DocumentSymbolProvider {
+			t.Errorf("missing capabilities %+v", caps)
+		}
+	})
+
+	t.Run("Diagnostics on open and change", func(t *testing.T) {
+		c := newClient(t)
+		p := c.open("funcion principal() int {\n    devolver b\n}\n")
+		if p.URI != uri || len(p.Diagnostics) == 0 {
+			t.Fatalf("expected a diagnostic, got %+v", p)
+		}
+		d := p.Diagnostics[0]
+		if d.Severity != lsp.SEVERITY_ERROR || d.Source != "he++" || d.Range.Start != pos(1, 13) || d.Range.End != pos(1, 14) {
+			t.Errorf("unexpected diagnostic %+v", d)
+		}
+		if strings.Contains(d.Message, "\x1b[") {
+			t.Errorf("message has color codes: %q", d.Message)
+		}
+
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []any{map[string]any{"text": source}},
+		})
+		if p := c.diagnostics(); len(p.Diagnostics) != 0 {
+			t.Errorf("expected the fix to clear diagnostics, got %+v", p.Diagnostics)
+		}
+
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 3},
+			"contentChanges": []any{map[string]any{"text": "funcion principal() int {\n    definir int a = \n}\n"}},
+		})
+		if p := c.diagnostics(); len(p.Diagnostics) == 0 || p.Diagnostics[0].Code != "SyntaxError" {
+			t.Errorf("expected a syntax error, got %+v", p.Diagnostics)
+		}
+
+		c.notify("textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}})
+		if p := c.diagnostics(); len(p.Diagnostics) != 0 {
+			t.Errorf("expected close to clear diagnostics, got %+v", p.Diagnostics)
+		}
+	})
+
+	t.Run("Hover", func(t *testing.T) {
+		c := newClient(t)
+		c.open(source)
+		var h lsp.Hover
+		c.call("textDocument/hover", at(11, 13), &h)
+		if !strings.Contains(h.Contents.Value, "a: int") || h.Range == nil || h.Range.Start != pos(11, 13) {
+			t.Errorf("unexpected hover over a variable %+v", h)
+		}
+		c.call("textDocument/hover", at(6, 15), &h)
+		if !strings.Contains(h.Contents.Value, "int") || h.Range == nil || h.Range.Start != pos(6, 13) || h.Range.End != pos(6, 18) {
+			t.Errorf("unexpected hover over an expression %+v", h)
+		}
+		msg := c.call("textDocument/hover", at(4, 0), nil)
+		if string(msg.Result) != "null" {
+			t.Errorf("expected no hover on a blank line, got %s", msg.Result)
+		}
+	})
+
+	t.Run("Definition", func(t *testing.T) {
+		c := newClient(t)
+		c.open(source)
+		var loc lsp.Location
+		c.call("textDocument/definition", at(10, 22), &loc)
+		if loc.URI != uri || loc.Range.Start != pos(5, 8) || loc.Range.End != pos(5, 13) {
+			t.Errorf("expected the definition of doble, got %+v", loc)
+		}
+		c.call("textDocument/definition", at(6, 13), &loc)
+		if loc.Range.Start != pos(5, 14) {
+			t.Errorf("expected the argument n, got %+v", loc)
+		}
+		c.call("textDocument/definition", at(11, 13), &loc)
+		if loc.Range.Start != pos(10, 16) {
+			t.Errorf("expected the variable a, got %+v", loc)
+		}
+	})
+
+	t.Run("Document symbols", func(t *testing.T) {
+		c := newClient(t)
+		c.open(source)
+		var symbols []lsp.DocumentSymbol
+		c.call("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}, &symbols)
+		got := make([]string, 0)
+		for _, s := range symbols {
+			got = append(got, fmt.Sprintf("%s/%d@%d:%d", s.Name, s.Kind, s.SelectionRange.Start.Line, s.SelectionRange.Start.Character))
+		}
+		want := []string{"Punto/23@0:11", "doble/12@5:8", "principal/12@9:8"}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("expected symbols %v, got %v", want, got)
+		}
+		if len(symbols) == 3 && (len(symbols[1].Children) != 1 || symbols[1].Children[0].Name != "n") {
+			t.Errorf("expected doble to list its argument, got %+v", symbols[1].Children)
+		}
+	})
+
+	t.Run("Unknown method and exit", func(t *testing.T) {
+		c := newClient(t)
+		if msg := c.call("textDocument/rename", at(0, 0), nil); msg.Error == nil || msg.Error.Code != -32601 {
+			t.Errorf("expected method not found, got %+v", msg)
+		}
+		c.call("shutdown", nil, nil)
+		c.notify("exit", nil)
+		select {
+		case err := <-c.done:
+			if err != nil {
+				t.Errorf("Run returned %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server didn't exit")
+		}
+	})
+}
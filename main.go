@@ -1,44 +1,270 @@
-package main
-
-import (
-	"fmt"
-	"he++/asm_gen"
-	cmdlineutils "he++/cmdline_utils"
-	"he++/lexer"
-	"he++/parser"
-	staticanalyzer "he++/static_analyzer"
-	"he++/tac"
-	"he++/utils"
-	"os"
-)
-
-// "runtime/pprof"
-
-func main() {
-	args := cmdlineutils.ReadArgs()
-	lexer := lexer.LexerOf(args["src"])
-	go lexer.Lexify()
-
-	astParser := parser.NewParser(lexer)
-	node := astParser.ParseAST()
-
-	if os.Getenv("DEBUG_LEXER") == "1" {
-		fmt.Println("Lexemes:")
-		lexer.PrintLexemes()
-	}
-	if os.Getenv("DEBUG_AST") == "1" {
-		p := utils.MakeASTPrinter()
-		node.String(&p)
-		fmt.Println(p.Builder.String())
-	}
-	analyzer := staticanalyzer.MakeAnalyzer()
-	ok := analyzer.AnalyzeAST(node)
-	if !ok {
-		println("Cannot proceed due to these errors")
-		return
-	}
-	tac := tac.NewTACGen(node)
-	tac.GenerateTac()
-	asm_gen := asm_gen.NewAsmGen(tac)
-	asm_gen.GenerateAsm()
-}
+package main
+
+import (
+	"errors"
+	"fmt"
+	"he++/asm_gen"
+	cmdlineutils "he++/cmdline_utils"
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/formatter"
+	"he++/lsp"
+	"he++/tac"
+	"he++/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// "runtime/pprof"
+
+func main() {
+	args, err := cmdlineutils.ReadArgs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "he++:", err)
+		cmdlineutils.PrintUsage(os.Stderr)
+		os.Exit(compiler.EXIT_USAGE)
+	}
+	if args.Cmd == cmdlineutils.HELP {
+		cmdlineutils.PrintUsage(os.Stdout)
+		return
+	}
+	if args.Cmd == cmdlineutils.LSP {
+		server := lsp.NewServer(os.Stdin, os.Stdout)
+		server.Log = os.Stderr
+		if err := server.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "he++:", err)
+			os.Exit(compiler.EXIT_FAILURE)
+		}
+		return
+	}
+	if args.Cmd == cmdlineutils.FMT {
+		os.Exit(formatFiles(args))
+	}
+
+	opts := compiler.Options{
+		Path:       args.Src,
+		RegAlloc:   args.RegAlloc,
+		Passes:     args.Passes,
+		PrintAfter: args.PrintAfter,
+		TimePasses: args.TimePasses,
+		PassLog:    os.Stderr,
+	}
+	if args.Cmd == cmdlineutils.INTERP && !wantsEmit(args, cmdlineutils.EMIT_ASM) {
+		opts.StopAfter = compiler.TAC
+	}
+	if args.Cmd == cmdlineutils.CHECK {
+		opts.StopAfter = compiler.ANALYZE
+		if wantsEmit(args, cmdlineutils.EMIT_TAC) {
+			opts.StopAfter = compiler.TAC
+		}
+		if wantsEmit(args, cmdlineutils.EMIT_ASM) {
+			opts.StopAfter = compiler.ASM
+		}
+	}
+	source, err := os.ReadFile(args.Src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "he++:", err)
+		os.Exit(compiler.EXIT_FAILURE)
+	}
+	res, diags := compiler.Compile(string(source), opts)
+
+	emit(args, cmdlineutils.EMIT_TOKENS, res.DumpTokens)
+	if res.AST != nil {
+		emit(args, cmdlineutils.EMIT_AST, res.DumpAST)
+	}
+	reportDiagnostics(args, args.Src, string(source), diags)
+	if diagnostics.HasErrors(diags) {
+		if args.DiagFormat == diagnostics.TEXT {
+			fmt.Fprintln(os.Stderr, "Cannot proceed due to these errors")
+		}
+		os.Exit(compiler.ExitCode(diags))
+	}
+	emit(args, cmdlineutils.EMIT_TAC, res.DumpTAC)
+	emit(args, cmdlineutils.EMIT_ASM, func(w io.Writer) {
+		io.WriteString(w, res.Asm)
+	})
+
+	switch args.Cmd {
+	case cmdlineutils.BUILD:
+		out := args.Out
+		if out == "" {
+			out = strings.TrimSuffix(filepath.Base(args.Src), filepath.Ext(args.Src))
+		}
+		if err := buildExecutable(res, out); err != nil {
+			fmt.Fprintln(os.Stderr, "he++:", err)
+			os.Exit(compiler.EXIT_FAILURE)
+		}
+	case cmdlineutils.RUN:
+		code, err := runProgram(res, args.ProgArgs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "he++:", err)
+			os.Exit(compiler.EXIT_FAILURE)
+		}
+		os.Exit(code)
+	case cmdlineutils.INTERP:
+		code, err := interpret(res)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "he++:", err)
+			os.Exit(compiler.EXIT_FAILURE)
+		}
+		os.Exit(code)
+	}
+}
+
+// machine readable formats are written even without diagnostics, so
+// that consumers always get a document to parse
+func reportDiagnostics(args *cmdlineutils.Args, path string, source string, diags []*diagnostics.Diagnostic) {
+	var err error
+	switch args.DiagFormat {
+	case diagnostics.JSON:
+		err = diagnostics.WriteJSON(os.Stderr, diags)
+	case diagnostics.SARIF:
+		err = diagnostics.WriteSARIF(os.Stderr, diags, map[string]string{path: source})
+	default:
+		renderer := diagnostics.NewRenderer(os.Stderr)
+		renderer.AddSource(path, source)
+		renderer.RenderAll(diags)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "he++:", err)
+		os.Exit(compiler.EXIT_FAILURE)
+	}
+}
+
+// prints the files formatted, or with --check lists those that aren't
+// and with --write rewrites them. Files with syntax errors are reported
+// and left alone. Returns the exit status.
+func formatFiles(args *cmdlineutils.Args) int {
+	status := compiler.EXIT_OK
+	fail := func(code int) {
+		if status == compiler.EXIT_OK {
+			status = code
+		}
+	}
+	for _, path := range args.Files {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "he++:", err)
+			fail(compiler.EXIT_FAILURE)
+			continue
+		}
+		formatted, diags := formatter.Format(path, string(source))
+		if diagnostics.HasErrors(diags) {
+			reportDiagnostics(args, path, string(source), diags)
+			fail(compiler.ExitCode(diags))
+			continue
+		}
+		switch {
+		case args.Check:
+			if formatted != string(source) {
+				fmt.Println(path)
+				fail(compiler.EXIT_FAILURE)
+			}
+		case args.Write:
+			if formatted == string(source) {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err == nil {
+				err = os.WriteFile(path, []byte(formatted), info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "he++:", err)
+				fail(compiler.EXIT_FAILURE)
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	return status
+}
+
+func buildExecutable(res *compiler.Result, out string) error {
+	if !res.HasFunction(asm_gen.ENTRY_FUNC) {
+		return fmt.Errorf("no %s function to start the program at", asm_gen.ENTRY_FUNC)
+	}
+	dir, err := os.MkdirTemp("", "he++-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return asm_gen.BuildExecutable(res.Asm, dir, out)
+}
+
+// builds into a temp dir and executes the binary with our stdio.
+// Returns the exit status of the program.
+func runProgram(res *compiler.Result, progArgs []string) (int, error) {
+	dir, err := os.MkdirTemp("", "he++-run-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "prog")
+	if err := buildExecutable(res, bin); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(bin, progArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			fmt.Fprintf(os.Stderr, "he++: program killed by signal: %v\n", status.Signal())
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// runs the program's TAC, exiting with what principal returns like a
+// native build would
+func interpret(res *compiler.Result) (int, error) {
+	if !res.HasFunction(asm_gen.ENTRY_FUNC) {
+		return 0, fmt.Errorf("no %s function to start the program at", asm_gen.ENTRY_FUNC)
+	}
+	ret, err := tac.NewInterpreter(res.Functions).Run(asm_gen.ENTRY_FUNC)
+	if err != nil {
+		return 0, err
+	}
+	return int(uint8(ret)), nil
+}
+
+func wantsEmit(args *cmdlineutils.Args, kinds ...cmdlineutils.EmitKind) bool {
+	for _, k := range kinds {
+		if _, ok := args.Emits[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func emit(args *cmdlineutils.Args, kind cmdlineutils.EmitKind, dump func(io.Writer)) {
+	if path, ok := args.Emits[kind]; ok {
+		writeOutput(path, dump)
+	}
+}
+
+func writeOutput(path string, dump func(io.Writer)) {
+	w, err := cmdlineutils.OpenOutput(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "he++:", err)
+		os.Exit(compiler.EXIT_FAILURE)
+	}
+	defer w.Close()
+	// dumps are colored like diagnostics, but only on a terminal
+	if path != cmdlineutils.STDOUT || !utils.IsTerminal(os.Stdout) {
+		defer utils.SetColor(utils.SetColor(false))
+	}
+	dump(w)
+}
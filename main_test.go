@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"he++/compiler"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// builds he++ once into a temporary directory
+func buildHepp(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "he++")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("building he++: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// runs he++ in dir, returning its exit status and what it wrote
+func runHepp(t *testing.T, bin string, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stdout.String(), stderr.String()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+func needToolchain(t *testing.T) {
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s to build with", tool)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	bin := buildHepp(t)
+	fib, err := filepath.Abs(filepath.Join("samples", "programs", "fibonacci.lg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Usage errors", func(t *testing.T) {
+		for _, args := range [][]string{
+			{},
+			{"compile", fib},
+			{"build", fib, "--passes=prune", "--disable-pass=prune"},
+			{"check", fib, "-o", "out"},
+		} {
+			code, _, stderr := runHepp(t, bin, t.TempDir(), args...)
+			if code != compiler.EXIT_USAGE || !strings.Contains(stderr, "usage: he++") {
+				t.Errorf("%v: expected the usage and exit status %d, got %d\n%s", args, compiler.EXIT_USAGE, code, stderr)
+			}
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		for _, arg := range []string{"help", "--help", "-h"} {
+			code, stdout, _ := runHepp(t, bin, t.TempDir(), arg)
+			if code != compiler.EXIT_OK || !strings.HasPrefix(stdout, "usage: he++") {
+				t.Errorf("%s: expected the usage on stdout, got %d\n%s", arg, code, stdout)
+			}
+		}
+	})
+
+	t.Run("Build", func(t *testing.T) {
+		needToolchain(t)
+		// named after the source file in the working directory without -o
+		dir := t.TempDir()
+		if code, _, stderr := runHepp(t, bin, dir, "build", fib, "--emit=asm=fib.s"); code != compiler.EXIT_OK {
+			t.Fatalf("build failed with %d\n%s", code, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "fib.s")); err != nil {
+			t.Errorf("expected the assembly kept: %v", err)
+		}
+		err := exec.Command(filepath.Join(dir, "fibonacci")).Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 44 {
+			t.Errorf("expected the executable to exit with 44, got %v", err)
+		}
+
+		if code, _, stderr := runHepp(t, bin, dir, "build", fib, "-o", "otro"); code != compiler.EXIT_OK {
+			t.Fatalf("build failed with %d\n%s", code, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "otro")); err != nil {
+			t.Errorf("expected the executable at -o: %v", err)
+		}
+	})
+
+	t.Run("Failed link", func(t *testing.T) {
+		needToolchain(t)
+		dir := t.TempDir()
+		out := filepath.Join(dir, "missing", "fib")
+		code, _, stderr := runHepp(t, bin, dir, "build", fib, "-o", out)
+		if code != compiler.EXIT_FAILURE || !strings.Contains(stderr, "ld failed") {
+			t.Errorf("expected ld to fail with exit status %d, got %d\n%s", compiler.EXIT_FAILURE, code, stderr)
+		}
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		needToolchain(t)
+		dir := t.TempDir()
+		if code, _, stderr := runHepp(t, bin, dir, "run", fib, "--", "a", "b"); code != 44 {
+			t.Errorf("expected principal's 44 as the exit status, got %d\n%s", code, stderr)
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Errorf("expected run to leave nothing behind, got %v", entries)
+		}
+
+		// a division by zero kills the program with SIGFPE
+		src := filepath.Join(dir, "cero.lg")
+		prog := "funcion f(a int) int {\n devolver 7 / a\n}\nfuncion principal() int {\n devolver f(0)\n}\n"
+		if err := os.WriteFile(src, []byte(prog), 0644); err != nil {
+			t.Fatal(err)
+		}
+		code, _, stderr := runHepp(t, bin, dir, "run", "-O0", src)
+		if code != 128+8 || !strings.Contains(stderr, "killed by signal") {
+			t.Errorf("expected 128 + SIGFPE, got %d\n%s", code, stderr)
+		}
+	})
+}
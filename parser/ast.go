@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	"he++/lexer"
 	"he++/parser/node_types"
 	"he++/utils"
@@ -10,71 +11,129 @@ import (
 
 func (p *Parser) ParseAST() *node_types.SourceFileNode {
 	if !p.tokenStream.HasTokens() {
-		parsingError("No tokens to parse!", -1)
+		parsingError("No tokens to parse!", utils.Span{})
 		return nil
 	}
 	root := node_types.MakeSourceFileNode(p.Path)
+	first := p.tokenStream.Current().Span()
 	parseStatements(p, root)
+	root.NodeMetadata = *node_types.MakeMetadata(first, p.tokenStream.Previous().Span())
 
 	return root
 }
 
 func parseScope(p *Parser) node_types.TreeNode {
 	t := p.tokenStream
-	ls := t.ConsumeOnlyIf(lexer.LPAREN).LineNo()
+	ls := t.ConsumeOnlyIf(lexer.LPAREN).Span()
 	scopeNode := node_types.MakeScopeNode()
 	parseStatements(p, scopeNode)
-	le := t.ConsumeOnlyIf(lexer.RPAREN).LineNo()
+	le := t.ConsumeOnlyIf(lexer.RPAREN).Span()
 	scopeNode.NodeMetadata = *node_types.MakeMetadata(ls, le)
 	return scopeNode
 }
 
 func parseStatements(p *Parser, scope node_types.StatementsContainer) node_types.StatementsContainer {
-OUT:
+	_, topLevel := scope.(*node_types.SourceFileNode)
 	for p.tokenStream.HasTokens() {
 		curr := p.tokenStream.Current()
 		if curr.Type() == lexer.BRACKET && curr.Text() == lexer.RPAREN {
-			break OUT
-		}
-		parselet, exists := p.scopeParselets[curr.Text()]
-		if !exists {
-			expr := parseExpression(p, 0)
-			if expr != nil {
-				scope.AddChild(expr)
-			} else {
-				parsingError(fmt.Sprintf("Cannot parse %s", utils.Red(curr.Text())), curr.LineNo())
-			}
-			if !p.tokenStream.HasTokens() {
-				break OUT
+			if !topLevel {
+				break
 			}
-		} else {
-			scope.AddChild(parselet(p))
+			p.errs = append(p.errs, diagnostics.Error(curr.Span(), diagnostics.SyntaxError, fmt.Sprintf("Unmatched %s", lexer.RPAREN)))
+			p.tokenStream.Consume()
+			continue
 		}
+		scope.AddChild(parseStatement(p))
 	}
 	return scope
 }
 
+// A syntax error inside the statement is recorded and the statement
+// replaced by an ErrorNode, parsing resumes where the next statement
+// seems to start.
+func parseStatement(p *Parser) (stmt node_types.TreeNode) {
+	t := p.tokenStream
+	start := t.Current()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		d, ok := r.(*diagnostics.Diagnostic)
+		if !ok {
+			panic(r)
+		}
+		p.errs = append(p.errs, d)
+		if t.Current() == start {
+			// make progress, else we would fail on the same token forever
+			t.Consume()
+		}
+		p.synchronize()
+		stmt = node_types.MakeErrorNode(node_types.MakeMetadata(start.Span(), t.Previous().Span()))
+	}()
+
+	parselet, exists := p.scopeParselets[start.Text()]
+	if exists {
+		return parselet(p)
+	}
+	expr := parseExpression(p, 0)
+	if expr == nil {
+		parsingError(fmt.Sprintf("Cannot parse %s", utils.Red(start.Text())), start.Span())
+	}
+	return expr
+}
+
+// skips tokens up to a keyword starting a statement or the `}` closing
+// the scope we're in. Blocks met on the way are skipped whole, so that
+// their statements don't end up in the wrong scope.
+func (p *Parser) synchronize() {
+	t := p.tokenStream
+	depth := 0
+	for t.HasTokens() {
+		tok := t.Current()
+		if depth == 0 && (tok.Text() == lexer.RPAREN || p.startsStatement(tok)) {
+			return
+		}
+		if tok.Type() == lexer.BRACKET && tok.Text() == lexer.LPAREN {
+			depth++
+		} else if tok.Type() == lexer.BRACKET && tok.Text() == lexer.RPAREN {
+			depth--
+		}
+		t.Consume()
+	}
+}
+
+func (p *Parser) startsStatement(tok *lexer.LexerToken) bool {
+	_, ok := p.scopeParselets[tok.Text()]
+	return ok && tok.Type() == lexer.KEYWORD
+}
+
 func parseFunction(p *Parser) node_types.TreeNode {
 	t := p.tokenStream
-	ls := t.ConsumeOnlyIf(lexer.FUNCTION).LineNo()
+	ls := t.ConsumeOnlyIf(lexer.FUNCTION).Span()
 	funcName := t.Consume()
 	var argList []node_types.FuncArg
 	t.ConsumeOnlyIf(lexer.OPEN_PAREN)
 	for t.Current().Text() != lexer.CLOSE_PAREN {
 		varName := t.Consume()
 		dataType := parseDataType(p)
-		argList = append(argList, node_types.FuncArg{Name: varName.Text(), DataT: dataType})
+		argList = append(argList, node_types.FuncArg{Name: varName.Text(), DataT: dataType, Span: varName.Span()})
 		t.ConsumeIf(lexer.COMMA)
 	}
-	le := t.ConsumeOnlyIf(lexer.CLOSE_PAREN).LineNo()
+	t.ConsumeOnlyIf(lexer.CLOSE_PAREN)
 
-	funcNode := node_types.MakeFunctionNode(funcName.Text(), argList, parseDataType(p), parseScope(p).(*node_types.ScopeNode), node_types.MakeMetadata(ls, le))
+	retType := parseDataType(p)
+	scope := parseScope(p).(*node_types.ScopeNode)
+	funcNode := node_types.MakeFunctionNode(funcName.Text(), argList, retType, scope, node_types.MakeMetadata(ls, scope.Span()))
+	funcNode.NameSpan = funcName.Span()
 	return funcNode
 }
 
 func parseReturnStatement(p *Parser) node_types.TreeNode {
-	ls := p.tokenStream.ConsumeOnlyIf(lexer.RETURN).LineNo()
-	return node_types.MakeReturnNode(parseExpression(p, 0), node_types.MakeMetadata(ls, ls))
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.RETURN).Span()
+	value := parseExpression(p, 0)
+	return node_types.MakeReturnNode(value, node_types.MakeMetadata(ls, value.Span()))
 }
 
 func parseDataType(p *Parser) node_types.DataType {
@@ -116,13 +175,16 @@ func parseDataType(p *Parser) node_types.DataType {
 		t.Consume()
 		return node_types.VOID_DATATYPE
 	}
-	parsingError("Couldn't parse type: "+currTok.String(), currTok.LineNo())
+	if !t.HasTokens() {
+		parsingError("Unexpected end of file while parsing a type", t.CurrentSpan())
+	}
+	parsingError("Couldn't parse type: "+currTok.DisplayText(), t.CurrentSpan())
 	return nil
 
 }
 
 func parseVariableDeclaration(p *Parser) node_types.TreeNode {
-	ls := p.tokenStream.ConsumeOnlyIf(lexer.LET).LineNo()
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.LET).Span()
 	dt := parseDataType(p)
 	var decls []node_types.TreeNode
 	decls = append(decls, parseExpression(p, 0))
@@ -130,31 +192,31 @@ func parseVariableDeclaration(p *Parser) node_types.TreeNode {
 		p.tokenStream.Consume()
 		decls = append(decls, parseExpression(p, 0))
 	}
-	varDec := node_types.MakeVariableDeclarationNode(decls, dt, node_types.MakeMetadata(ls, decls[len(decls)-1].Range().End))
+	varDec := node_types.MakeVariableDeclarationNode(decls, dt, node_types.MakeMetadata(ls, decls[len(decls)-1].Span()))
 	return varDec
 }
 
 func parseIfStatement(p *Parser) node_types.TreeNode {
-	ls := p.tokenStream.ConsumeOnlyIf(lexer.IF).LineNo()
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.IF).Span()
 	var branches []node_types.ConditionalBranch
 	ifCond := parseExpression(p, 0)
 	p.tokenStream.ConsumeOnlyIf(lexer.THEN)
 	ifScope := parseScope(p).(*node_types.ScopeNode)
 	branches = append(branches, node_types.ConditionalBranch{Condition: ifCond, Scope: ifScope})
-	le := ls
+	le := ifScope.Span()
 	for p.tokenStream.Current().Text() == lexer.ELSE {
-		le = p.tokenStream.Consume().LineNo()
+		elseSpan := p.tokenStream.Consume().Span()
 		tok := p.tokenStream.ConsumeIf(lexer.IF)
 		var cond node_types.TreeNode
 		if tok != nil {
 			cond = parseExpression(p, 0)
 			p.tokenStream.ConsumeOnlyIf(lexer.THEN)
 		} else {
-			cond = node_types.NewBooleanNode(true, node_types.MakeMetadata(le, le))
+			cond = node_types.NewBooleanNode(true, node_types.MakeMetadata(elseSpan, elseSpan))
 		}
 		scp := parseScope(p).(*node_types.ScopeNode)
 		branches = append(branches, node_types.ConditionalBranch{Condition: cond, Scope: scp})
-
+		le = scp.Span()
 	}
 	return node_types.MakeIfNode(branches, node_types.MakeMetadata(ls, le))
 }
@@ -169,20 +231,22 @@ func parseLoopStatement(p *Parser) node_types.TreeNode {
 }
 
 func parseWhileLoop(p *Parser) node_types.TreeNode {
-	ls := p.tokenStream.ConsumeOnlyIf(lexer.WHILE).LineNo()
-	le := p.tokenStream.ConsumeOnlyIf(lexer.THAT).LineNo()
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.WHILE).Span()
+	p.tokenStream.ConsumeOnlyIf(lexer.THAT)
 	condNode := parseExpression(p, 0)
-	return node_types.MakeLoopNode(&node_types.EmptyPlaceholderNode{}, condNode, &node_types.EmptyPlaceholderNode{}, parseScope(p).(*node_types.ScopeNode), node_types.MakeMetadata(ls, le))
+	scope := parseScope(p).(*node_types.ScopeNode)
+	return node_types.MakeLoopNode(&node_types.EmptyPlaceholderNode{}, condNode, &node_types.EmptyPlaceholderNode{}, scope, node_types.MakeMetadata(ls, scope.Span()))
 }
 
 func parseForLoop(p *Parser) node_types.TreeNode {
-	ls := p.tokenStream.ConsumeOnlyIf(lexer.FOR).LineNo()
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.FOR).Span()
 	varDecl := parseVariableDeclaration(p)
 	p.tokenStream.ConsumeOnlyIf(lexer.SEMICOLON)
 	condNode := parseExpression(p, 0)
-	le := p.tokenStream.ConsumeOnlyIf(lexer.SEMICOLON).LineNo()
+	p.tokenStream.ConsumeOnlyIf(lexer.SEMICOLON)
 	updNode := parseExpression(p, 0)
-	return node_types.MakeLoopNode(varDecl, condNode, updNode, parseScope(p).(*node_types.ScopeNode), node_types.MakeMetadata(ls, le))
+	scope := parseScope(p).(*node_types.ScopeNode)
+	return node_types.MakeLoopNode(varDecl, condNode, updNode, scope, node_types.MakeMetadata(ls, scope.Span()))
 }
 
 func parseStructType(p *Parser) *node_types.StructType {
@@ -190,10 +254,11 @@ func parseStructType(p *Parser) *node_types.StructType {
 	strct := node_types.StructType{}
 	siz := 0
 	for p.tokenStream.Current().Text() != lexer.RPAREN {
-		name := p.tokenStream.Consume().Text()
+		name := p.tokenStream.Consume()
 		dt := parseDataType(p)
 		siz += dt.Size()
-		strct.Fields = append(strct.Fields, node_types.StructFieldTypeInfo{Name: name, Type: dt})
+		span := name.Span().To(p.tokenStream.Previous().Span())
+		strct.Fields = append(strct.Fields, node_types.StructFieldTypeInfo{Name: name.Text(), Type: dt, Span: span})
 		p.tokenStream.ConsumeIf(lexer.COMMA)
 	}
 	strct.Tid = node_types.UniqueTypeId()
@@ -204,7 +269,8 @@ func parseStructType(p *Parser) *node_types.StructType {
 }
 
 func parseStructDefn(p *Parser) node_types.TreeNode {
-	p.tokenStream.ConsumeOnlyIf(lexer.STRUCT)
-	name := p.tokenStream.ConsumeOnlyIfType(lexer.IDENTIFIER).Text()
-	return &node_types.StructDefnNode{Name: name, StructDef: parseStructType(p)}
+	ls := p.tokenStream.ConsumeOnlyIf(lexer.STRUCT).Span()
+	name := p.tokenStream.ConsumeOnlyIfType(lexer.IDENTIFIER)
+	def := parseStructType(p)
+	return &node_types.StructDefnNode{Name: name.Text(), StructDef: def, NameSpan: name.Span(), NodeMetadata: *node_types.MakeMetadata(ls, p.tokenStream.Previous().Span())}
 }
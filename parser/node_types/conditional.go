@@ -10,11 +10,9 @@ type ConditionalBranch struct {
 	Scope     *ScopeNode
 }
 
-var ifSeq int = 0
 type IfNode struct {
-	Branches []ConditionalBranch
+	Branches   []ConditionalBranch
 	Exhaustive bool
-	Seq int
 	NodeMetadata
 }
 
@@ -35,6 +33,5 @@ func (i *IfNode) Type() TreeNodeType {
 }
 
 func MakeIfNode(branches []ConditionalBranch, meta *NodeMetadata) *IfNode {
-	ifSeq++
-	return &IfNode{branches, false, ifSeq, *meta}
+	return &IfNode{branches, false, *meta}
 }
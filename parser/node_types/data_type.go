@@ -1,6 +1,9 @@
 package node_types
 
-import "he++/lexer"
+import (
+	"he++/lexer"
+	"he++/utils"
+)
 
 // nodes for pointer, arr, obj, primitive, error
 
@@ -148,6 +151,8 @@ func (ft *FuncType) Text() string {
 type StructFieldTypeInfo struct {
 	Name string
 	Type DataType
+	// of the field's definition, unset for types not written in the source
+	Span utils.Span
 }
 type StructType struct {
 	Fields []StructFieldTypeInfo
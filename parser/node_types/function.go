@@ -8,12 +8,15 @@ import (
 type FuncArg struct {
 	Name  string
 	DataT DataType
+	Span  utils.Span
 }
 type FuncNode struct {
 	Name       string
 	ArgList    []FuncArg
 	Scope      *ScopeNode
 	ReturnType DataType
+	// where the name is written
+	NameSpan utils.Span
 	NodeMetadata
 }
 
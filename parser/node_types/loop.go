@@ -2,10 +2,7 @@ package node_types
 
 import "he++/utils"
 
-var loopSeq = 0
-
 type LoopNode struct {
-	Seq         int
 	Initializer TreeNode
 	Condition   TreeNode
 	Updater     TreeNode
@@ -31,6 +28,5 @@ func (l *LoopNode) Type() TreeNodeType {
 }
 
 func MakeLoopNode(initializer TreeNode, condition TreeNode, updater TreeNode, scope *ScopeNode, meta *NodeMetadata) *LoopNode {
-	loopSeq++
-	return &LoopNode{loopSeq, initializer, condition, updater, scope, *meta}
+	return &LoopNode{initializer, condition, updater, scope, *meta}
 }
@@ -60,20 +60,24 @@ func NewInfixOperatorNode(left TreeNode, op string, right TreeNode, meta *NodeMe
 	return &InfixOperatorNode{left, op, right, NONE, *meta}
 }
 
+// cond ? ifTrue : ifFalse
 type TernaryOperatorNode struct {
-	condition TreeNode
-	ifTrue    TreeNode
-	ifFalse   TreeNode
+	Condition TreeNode
+	IfTrue    TreeNode
+	IfFalse   TreeNode
 	ResultDT  DataType
 	NodeMetadata
 }
 
 func (t *TernaryOperatorNode) String(p *utils.ASTPrinter) {
 	p.PushIndent()
-	p.WriteLine("ternary")
-	t.condition.String(p)
-	t.ifTrue.String(p)
-	t.ifFalse.String(p)
+	p.WriteLine(utils.Magenta("ternary"))
+	p.WriteLine("condition:")
+	t.Condition.String(p)
+	p.WriteLine("if true:")
+	t.IfTrue.String(p)
+	p.WriteLine("if false:")
+	t.IfFalse.String(p)
 	p.PopIndent()
 }
 
@@ -81,8 +85,8 @@ func (t *TernaryOperatorNode) Type() TreeNodeType {
 	return OPERATOR
 }
 
-func NewTernaryNode(condition TreeNode, ifTrue TreeNode, ifFalse TreeNode) *TernaryOperatorNode {
-	return &TernaryOperatorNode{condition, ifTrue, ifFalse, NONE, NodeMetadata{}}
+func NewTernaryNode(condition TreeNode, ifTrue TreeNode, ifFalse TreeNode, meta *NodeMetadata) *TernaryOperatorNode {
+	return &TernaryOperatorNode{condition, ifTrue, ifFalse, NONE, *meta}
 }
 
 type ArrIndNode struct {
@@ -109,8 +113,8 @@ func NewArrIndNode(arrProvider TreeNode, indexer TreeNode, meta *NodeMetadata) *
 }
 
 type FuncCallNode struct {
-	Callee TreeNode
-	Args   []TreeNode
+	Callee  TreeNode
+	Args    []TreeNode
 	CalleeT *FuncType
 	NodeMetadata
 }
@@ -1,10 +1,15 @@
 package node_types
 
-import "he++/utils"
+import (
+	"he++/utils"
+	"sort"
+)
 
 type StructDefnNode struct {
 	Name      string
 	StructDef *StructType
+	// where the name is written
+	NameSpan utils.Span
 	NodeMetadata
 }
 
@@ -29,15 +34,27 @@ func (s *StructValueNode) String(p *utils.ASTPrinter) {
 	p.PushIndent()
 	p.WriteLine("{")
 	p.PushIndent()
-	for k, v := range s.FieldValues {
+	for _, k := range s.FieldNames() {
 		p.WriteLine(k + ":")
-		v.String(p)
+		s.FieldValues[k].String(p)
 	}
 	p.PopIndent()
 	p.WriteLine("}")
 	p.PopIndent()
 }
 
+// in the order they were written
+func (s *StructValueNode) FieldNames() []string {
+	names := make([]string, 0, len(s.FieldValues))
+	for name := range s.FieldValues {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.FieldValues[names[i]].Span().Start < s.FieldValues[names[j]].Span().Start
+	})
+	return names
+}
+
 func (s *StructValueNode) Type() TreeNodeType {
 	return STRUCT_VAL
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"he++/diagnostics"
 	"he++/lexer"
 	nodes "he++/parser/node_types"
 )
@@ -10,7 +11,11 @@ type Parser struct {
 	tokenStream      *TokenStream
 	prefixParselets  map[string]func(*Parser) nodes.TreeNode
 	postfixParselets map[string]func(*Parser, nodes.TreeNode) nodes.TreeNode
-	scopeParselets   map[string]func(*Parser) nodes.TreeNode
+	// infix operators that aren't plain binary ones
+	infixParselets map[string]func(*Parser, nodes.TreeNode) nodes.TreeNode
+	scopeParselets map[string]func(*Parser) nodes.TreeNode
+	// syntax errors recovered from so far
+	errs []*diagnostics.Diagnostic
 }
 
 func NewParser(l *lexer.Lexer) *Parser {
@@ -18,7 +23,9 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p := &Parser{l.Path, ts,
 		make(map[string]func(*Parser) nodes.TreeNode),
 		make(map[string]func(*Parser, nodes.TreeNode) nodes.TreeNode),
+		make(map[string]func(*Parser, nodes.TreeNode) nodes.TreeNode),
 		make(map[string]func(*Parser) nodes.TreeNode),
+		nil,
 	}
 	p.initParselets()
 	return p
@@ -36,6 +43,7 @@ func (p *Parser) initParselets() {
 	p.prefixParselets[lexer.AMP] = parsePrefixOperator
 	p.prefixParselets[lexer.MUL] = parsePrefixOperator
 	p.prefixParselets[lexer.SUB] = parsePrefixOperator
+	p.prefixParselets[lexer.NOT] = parsePrefixOperator
 	p.prefixParselets[lexer.OPEN_PAREN] = parseBracketExpression
 	p.prefixParselets[lexer.OPEN_SQUARE] = parseArrayDeclaration
 	p.prefixParselets[lexer.LPAREN] = parseStructValue
@@ -45,6 +53,8 @@ func (p *Parser) initParselets() {
 	p.postfixParselets[lexer.INC] = parsePostfixOperator
 	p.postfixParselets[lexer.DEC] = parsePostfixOperator
 
+	p.infixParselets[lexer.TERN_IF] = parseTernary
+
 	p.scopeParselets[lexer.FUNCTION] = parseFunction
 	p.scopeParselets[lexer.LET] = parseVariableDeclaration
 	p.scopeParselets[lexer.IF] = parseIfStatement
@@ -53,10 +63,21 @@ func (p *Parser) initParselets() {
 	p.scopeParselets[lexer.RETURN] = parseReturnStatement
 	p.scopeParselets[lexer.STRUCT] = parseStructDefn
 	p.scopeParselets[lexer.LPAREN] = parseScope
-	
 
 }
 
+func (p *Parser) Diagnostics() []*diagnostics.Diagnostic {
+	return p.errs
+}
+
+// line of the token being parsed
+func (p *Parser) CurrentLine() int {
+	if tok := p.tokenStream.Current(); tok != nil {
+		return tok.LineNo()
+	}
+	return -1
+}
+
 func (p *Parser) getPrefixParselet(tok lexer.LexerToken) (func(*Parser) nodes.TreeNode, bool) {
 	prefix, exists := p.prefixParselets[tok.Text()]
 	if !exists {
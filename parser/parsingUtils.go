@@ -1,26 +1,25 @@
-package parser
-
-import (
-	"fmt"
-	"he++/utils"
-	"he++/lexer"
-)
-
-
-
-func isPostfixOperator(op string) bool {
-	return op == lexer.INC || op == lexer.DEC || op == lexer.OPEN_PAREN || op == lexer.OPEN_SQUARE
-}
-
-func parsingError(msg string, lineNo int) {
-	panic(utils.Red(fmt.Sprintf("Parsing error at line %d: %s\n", lineNo, msg)))
-}
-
-func Contains(arr []interface{}, e interface{}) bool {
-	for i := range arr {
-		if arr[i] == e {
-			return true
-		}
-	}
-	return false
-}
+package parser
+
+import (
+	"he++/diagnostics"
+	"he++/lexer"
+	"he++/utils"
+)
+
+func isPostfixOperator(op string) bool {
+	return op == lexer.INC || op == lexer.DEC || op == lexer.OPEN_PAREN || op == lexer.OPEN_SQUARE
+}
+
+// aborts parsing, the panic carries a *diagnostics.Diagnostic
+func parsingError(msg string, span utils.Span) {
+	panic(diagnostics.Error(span, diagnostics.SyntaxError, msg))
+}
+
+func Contains(arr []interface{}, e interface{}) bool {
+	for i := range arr {
+		if arr[i] == e {
+			return true
+		}
+	}
+	return false
+}
@@ -3,10 +3,12 @@ package parser
 import (
 	"fmt"
 	lexer "he++/lexer"
+	"he++/utils"
 )
 
 type TokenStream struct {
 	tokenChan  <-chan lexer.LexerToken
+	prevTok    *lexer.LexerToken
 	currentTok *lexer.LexerToken
 	nextTok    *lexer.LexerToken
 	endOfToks  bool
@@ -27,8 +29,27 @@ func (ts *TokenStream) HasTokens() bool {
 	return ts.currentTok.Type() != ""
 }
 
+// the last consumed token
+func (ts *TokenStream) Previous() *lexer.LexerToken {
+	return ts.prevTok
+}
+
+// span of the current token, or the point just past the last one at the
+// end of the stream
+func (ts *TokenStream) CurrentSpan() utils.Span {
+	if ts.HasTokens() || ts.prevTok == nil {
+		return ts.currentTok.Span()
+	}
+	span := ts.prevTok.Span()
+	span.Line, span.Col, span.Start = span.EndLine, span.EndCol, span.End
+	return span
+}
+
 func (ts *TokenStream) Consume() *lexer.LexerToken {
 	curr := ts.currentTok
+	if curr != nil {
+		ts.prevTok = curr
+	}
 	ts.currentTok = ts.nextTok
 	tok, ok := <-ts.tokenChan
 	if !ok {
@@ -42,7 +63,7 @@ func (ts *TokenStream) ConsumeOnlyIf(t string) *lexer.LexerToken {
 	if ts.HasTokens() && ts.Current().Text() == t {
 		return ts.Consume()
 	}
-	parsingError(fmt.Sprintf("Expected %s but got %s", t, ts.Current().Text()), ts.Current().LineNo())
+	parsingError(fmt.Sprintf("Expected %s but got %s", t, ts.Current().Text()), ts.CurrentSpan())
 	return nil
 }
 
@@ -57,7 +78,7 @@ func (ts *TokenStream) ConsumeOnlyIfType(t lexer.LexerTokenType) *lexer.LexerTok
 	if ts.HasTokens() && ts.Current().Type() == t {
 		return ts.Consume()
 	}
-	parsingError(fmt.Sprintf("Expected %s but got %s", t, ts.Current().Type().String()), ts.Current().LineNo())
+	parsingError(fmt.Sprintf("Expected %s but got %s", t, ts.Current().Type().String()), ts.CurrentSpan())
 	return nil
 }
 
@@ -2,6 +2,7 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	nodes "he++/parser/node_types"
 	"he++/utils"
 	// "he++/utils"
@@ -21,6 +22,7 @@ Things to check for:
 type VarDefInfo struct {
 	dt      nodes.DataType
 	numUses int
+	sym     *Symbol
 }
 
 type Analyzer struct {
@@ -31,7 +33,10 @@ type Analyzer struct {
 	// sym refers to functions and variables
 	definedSyms map[string]*VarDefInfo
 	// operatorTypeRelations map[nodes.TypeId]
-	Errs []utils.CompilerError
+	Errs []*diagnostics.Diagnostic
+	// line of the node being checked
+	curLine int
+	Info    SourceInfo
 }
 
 func MakeAnalyzer() Analyzer {
@@ -81,7 +86,8 @@ func (a *Analyzer) GetType(key string) (nodes.DataType, bool) {
 	return *val, true
 }
 
-func (a *Analyzer) DefineSym(name string, dt nodes.DataType) string {
+func (a *Analyzer) DefineSym(name string, kind SymbolKind, dt nodes.DataType, span utils.Span) string {
+	sym := a.Info.define(name, kind, dt, span)
 	// maybe instead of stack, just change the name of this var to sth unique
 	// and redirect all references in the scope to the new name
 
@@ -95,7 +101,7 @@ func (a *Analyzer) DefineSym(name string, dt nodes.DataType) string {
 		name = newName
 	}
 	lastScope.DefinedSyms[name] = true
-	a.definedSyms[name] = &VarDefInfo{dt, 0}
+	a.definedSyms[name] = &VarDefInfo{dt, 0, sym}
 	return name
 }
 
@@ -139,13 +145,16 @@ func (a *Analyzer) AnalyzeAST(n *nodes.SourceFileNode) bool {
 			a.checkFunctionDef(funcNode)
 		}
 	}
-	fmt.Printf("In source file %s:\n", utils.Underline(n.FilePath))
-	for _, k := range a.Errs {
-		fmt.Println(&k)
-	}
 	return len(a.Errs) == 0
 }
 
-func (a *Analyzer) AddError(Line int, Name utils.CompilerErrorKind, Msg string) {
-	a.Errs = append(a.Errs, utils.CompilerError{Line: Line, Name: Name, Msg: Msg})
+func (a *Analyzer) CurrentLine() int {
+	return a.curLine
+}
+
+// the returned diagnostic can be given labels and notes
+func (a *Analyzer) AddError(span utils.Span, kind diagnostics.Kind, msg string) *diagnostics.Diagnostic {
+	d := diagnostics.Error(span, kind, msg)
+	a.Errs = append(a.Errs, d)
+	return d
 }
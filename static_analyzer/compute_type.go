@@ -2,6 +2,7 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	"he++/lexer"
 	nodes "he++/parser/node_types"
 	"he++/utils"
@@ -11,8 +12,16 @@ import (
 // this function partners with checkExpression for checking that area of the tree.
 // Assume that the function is not idempotent.
 func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
+	dt := a.computeNodeType(n)
+	if n != nil {
+		a.Info.Types = append(a.Info.Types, TypedNode{Node: n, Type: dt})
+	}
+	return dt
+}
+
+func (a *Analyzer) computeNodeType(n nodes.TreeNode) nodes.DataType {
 	switch v := n.(type) {
-	case *nodes.BooleanNode, *nodes.NumberNode, *nodes.IdentifierNode, *nodes.InfixOperatorNode:
+	case *nodes.BooleanNode, *nodes.NumberNode, *nodes.IdentifierNode, *nodes.InfixOperatorNode, *nodes.TernaryOperatorNode:
 		return a.checkExpression(v)
 
 	case *nodes.PrePostOperatorNode:
@@ -20,6 +29,9 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 			dt := nodes.NONE
 			switch v.Op {
 			case lexer.AMP:
+				if !isLValue(v.Operand) {
+					a.AddError(v.Span(), diagnostics.TypeError, "Cannot take the address of a value that isn't stored anywhere")
+				}
 				dt = &nodes.PrefixOfType{
 					Prefix: nodes.PointerOf,
 					OfType: a.computeType(v.Operand),
@@ -33,13 +45,27 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 				if ch, ok := operandType.(*nodes.PrefixOfType); ok && ch.Prefix == nodes.PointerOf {
 					dt = ch.OfType
 				} else {
-					a.AddError(v.Range().Start, utils.TypeError, fmt.Sprintf("Cannot dereference type %s", utils.Cyan(operandType.Text())))
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Cannot dereference type %s", utils.Cyan(operandType.Text())))
 					dt = ERROR_TYPE
 				}
 			case lexer.SUB:
 				operandType := a.computeType(v.Operand)
 				if !isNumericType(operandType) {
-					a.AddError(v.Range().Start, utils.TypeError, fmt.Sprintf("Cannot negate value of type %s", utils.Cyan(operandType.Text())))
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Cannot negate value of type %s", utils.Cyan(operandType.Text())))
+				}
+				dt = operandType
+			case lexer.NOT:
+				operandType := a.computeType(v.Operand)
+				if !isBooleanType(operandType) {
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Cannot apply %s to value of type %s", utils.Magenta(v.Op), utils.Cyan(operandType.Text())))
+				}
+				dt = BOOLEAN_DATATYPE
+			case lexer.INC, lexer.DEC:
+				operandType := a.computeType(v.Operand)
+				if !operandType.Equals(INT_DATATYPE) {
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Cannot apply %s to value of type %s", utils.Magenta(v.Op), utils.Cyan(operandType.Text())))
+				} else if !isLValue(v.Operand) {
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Operand of %s should be a variable, an array element or a dereferenced pointer", utils.Magenta(v.Op)))
 				}
 				dt = operandType
 
@@ -57,7 +83,7 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 		{
 			sizeType := a.computeType(v.SizeNode)
 			if !isNumericType(sizeType) {
-				a.AddError(v.SizeNode.Range().Start, utils.TypeError, fmt.Sprintf("Size of array should be numeric"))
+				a.AddError(v.SizeNode.Span(), diagnostics.TypeError, fmt.Sprintf("Size of array should be numeric"))
 			}
 			a.verifyAndNormalize(&v.DataT)
 			expectedType := v.DataT
@@ -65,7 +91,7 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 				typ := a.computeType(elem)
 				if !typ.Equals(expectedType) {
 					// todo: check possibility of type casting
-					a.AddError(elem.Range().Start, utils.TypeError,
+					a.AddError(elem.Span(), diagnostics.TypeError,
 						fmt.Sprintf("Element at index %d of type %s cannot be casted to %s", i, utils.Cyan(typ.Text()), utils.Cyan(expectedType.Text())))
 				}
 			}
@@ -77,12 +103,12 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 		argtypes := make([]nodes.DataType, 0)
 		for i := range v.ArgList {
 			if !a.verifyAndNormalize(&v.ArgList[i].DataT) {
-				a.AddError(v.Range().Start, utils.UndefinedError, fmt.Sprintf("Arg type %s is undefined or depends on an undefined type", utils.Cyan(v.ArgList[i].DataT.Text())))
+				a.AddError(v.Span(), diagnostics.UndefinedError, fmt.Sprintf("Arg type %s is undefined or depends on an undefined type", utils.Cyan(v.ArgList[i].DataT.Text())))
 			}
 			argtypes = append(argtypes, v.ArgList[i].DataT)
 		}
 		if !a.verifyAndNormalize(&v.ReturnType) {
-			a.AddError(v.Range().Start, utils.UndefinedError, fmt.Sprintf("Return type %s is undefined or depends on an undefined type", utils.Cyan(v.ReturnType.Text())))
+			a.AddError(v.Span(), diagnostics.UndefinedError, fmt.Sprintf("Return type %s is undefined or depends on an undefined type", utils.Cyan(v.ReturnType.Text())))
 		}
 		return &nodes.FuncType{
 			ReturnType:       v.ReturnType,
@@ -95,7 +121,7 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 		indexedValueType, ok := isIndexable(a, arrType, indexerType)
 		v.DataType = indexedValueType
 		if !ok {
-			a.AddError(v.Range().Start, utils.TypeError, fmt.Sprintf("The type %s cannot be indexed by %s", utils.Cyan(arrType.Text()), utils.Cyan(indexerType.Text())))
+			a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("The type %s cannot be indexed by %s", utils.Cyan(arrType.Text()), utils.Cyan(indexerType.Text())))
 			return ERROR_TYPE
 		}
 		return indexedValueType
@@ -106,8 +132,8 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 		ftyp, ok := funcType.(*nodes.FuncType)
 		if !ok {
 			a.AddError(
-				v.Range().Start,
-				utils.TypeError,
+				v.Span(),
+				diagnostics.TypeError,
 				fmt.Sprintf("Type is not callable: %s", utils.Cyan(funcType.Text())),
 			)
 			return ERROR_TYPE
@@ -119,8 +145,8 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 
 		if len(v.Args) != len(ftyp.ArgTypes) {
 			a.AddError(
-				v.Range().Start,
-				utils.TypeError,
+				v.Span(),
+				diagnostics.TypeError,
 				fmt.Sprintf("Function %s expects %s parameters, but supplied %s", utils.Blue(funcNameTreeStr), utils.Yellow(fmt.Sprint(len(ftyp.ArgTypes))), utils.Yellow(fmt.Sprint(len(v.Args)))),
 			)
 			return ERROR_TYPE
@@ -131,8 +157,8 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 
 			if !expT.Equals(passedT) {
 				a.AddError(
-					v.Range().Start,
-					utils.TypeError,
+					v.Span(),
+					diagnostics.TypeError,
 					fmt.Sprintf("%d th parameter to function %s should be of type %s, not %s", i, utils.Blue(funcNameTreeStr), utils.Cyan(expT.Text()), utils.Cyan(passedT.Text())),
 				)
 			}
@@ -141,7 +167,7 @@ func (a *Analyzer) computeType(n nodes.TreeNode) nodes.DataType {
 		// verification should be done when storing the typedef from the func node
 		return ftyp.ReturnType
 	default:
-		a.AddError(v.Range().Start, utils.UndefinedError, fmt.Sprintf("Can't compute type for %T", v))
+		a.AddError(v.Span(), diagnostics.UndefinedError, fmt.Sprintf("Can't compute type for %T", v))
 		return ERROR_TYPE
 	}
 }
@@ -180,7 +206,7 @@ func (a *Analyzer) verifyAndNormalize(dt *nodes.DataType) bool {
 	case *nodes.VoidType:
 		return true
 	default:
-		a.AddError(-1, utils.UndefinedError, fmt.Sprintf("Can't verify type %T", v))
+		a.AddError(utils.Span{}, diagnostics.UndefinedError, fmt.Sprintf("Can't verify type %T", v))
 		return false
 	}
 }
@@ -2,10 +2,23 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
+	"he++/lexer"
 	nodes "he++/parser/node_types"
 	"he++/utils"
 )
 
+// whether n names a location that can be written to and pointed at
+func isLValue(n nodes.TreeNode) bool {
+	switch v := n.(type) {
+	case *nodes.IdentifierNode, *nodes.ArrIndNode:
+		return true
+	case *nodes.PrePostOperatorNode:
+		return v.OpType == nodes.PREFIX && v.Op == lexer.MUL
+	}
+	return false
+}
+
 // partners with computeType
 func (a *Analyzer) checkExpression(exp nodes.TreeNode) nodes.DataType {
 	exp.String(&utils.ASTPrinter{})
@@ -25,29 +38,50 @@ func (a *Analyzer) checkExpression(exp nodes.TreeNode) nodes.DataType {
 		l := a.computeType(v.Left)
 		r := a.computeType(v.Right)
 		// todo: check if l and r are compatible under this optype
-		ort := a.operatorReturnType(v.Op, l, r, v.Range().Start)
-		if isErrorType(ort) {
-			a.AddError(v.Range().Start, utils.TypeError, fmt.Sprintf("Can't perform %s on types %s and %s", v.Op, utils.Cyan(l.Text()), utils.Cyan(r.Text())))
+		ort := a.operatorReturnType(v.Op, l, r, v.Span())
+		// an operand in error has been reported already
+		if isErrorType(ort) && !isErrorType(l) && !isErrorType(r) {
+			a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Can't perform %s on types %s and %s", v.Op, utils.Cyan(l.Text()), utils.Cyan(r.Text())))
 		}
 		v.ResultDT = ort
 		return ort
+	case *nodes.TernaryOperatorNode:
+		condTyp := a.computeType(v.Condition)
+		if !isBooleanType(condTyp) {
+			a.AddError(v.Condition.Span(), diagnostics.TypeError,
+				fmt.Sprintf("Expected the expression to evaluate to %s or %s", utils.Blue(lexer.TRUE), utils.Blue(lexer.FALSE)))
+		}
+		t := a.computeType(v.IfTrue)
+		f := a.computeType(v.IfFalse)
+		if isErrorType(t) || isErrorType(f) {
+			v.ResultDT = ERROR_TYPE
+		} else if common := commonType(t, f); common != nil {
+			v.ResultDT = common
+		} else {
+			a.AddError(v.Span(), diagnostics.TypeError, "Both sides of the ternary should be of the same type").
+				WithLabel(v.IfTrue.Span(), t.Text()).
+				WithLabel(v.IfFalse.Span(), f.Text())
+			v.ResultDT = ERROR_TYPE
+		}
+		return v.ResultDT
 	case *nodes.IdentifierNode:
 		varname := v.Name()
 		s, exists, readAs := a.GetSymInfo(varname)
 		v.ChangeName(readAs)
 		if !exists {
-			a.AddError(v.Range().Start, utils.UndefinedError, fmt.Sprintf("Undefined identifier %s in expression", utils.Green(varname)))
+			a.AddError(v.Span(), diagnostics.UndefinedError, fmt.Sprintf("Undefined identifier %s in expression", utils.Green(varname)))
 			return ERROR_TYPE
 		}
 		s.numUses += 1
+		a.Info.Refs = append(a.Info.Refs, Reference{Span: v.Span(), Sym: s.sym})
 		v.DataT = s.dt
 		return s.dt
 	case *nodes.FuncCallNode:
 		a.computeType(v)
 	default:
 		a.AddError(
-			v.Range().Start,
-			utils.UndefinedError,
+			v.Span(),
+			diagnostics.UndefinedError,
 			fmt.Sprintf("Can't check for expresion node %T", v),
 		)
 	}
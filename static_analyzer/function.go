@@ -2,6 +2,7 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	nodes "he++/parser/node_types"
 	"he++/utils"
 )
@@ -9,13 +10,13 @@ import (
 func (a *Analyzer) registerFunctionDecl(fnd *nodes.FuncNode) {
 	// todo: if supporting function overloading,
 	// then the key should have args types too
-	fnd.Name = a.DefineSym(fnd.Name, a.computeType(fnd))
+	fnd.Name = a.DefineSym(fnd.Name, FUNCTION_SYM, a.computeType(fnd), fnd.NameSpan)
 }
 
 func (a *Analyzer) checkFunctionDef(fnd *nodes.FuncNode) {
 	a.PushScope(FUNCTION)
 	for i, arg := range fnd.ArgList {
-		fnd.ArgList[i].Name = a.DefineSym(arg.Name, arg.DataT)
+		fnd.ArgList[i].Name = a.DefineSym(arg.Name, ARGUMENT, arg.DataT, arg.Span)
 	}
 	// todo: instead of passing returnType, look up the scope stack
 	// to see what function we're inside. (todo: scope stack)
@@ -24,7 +25,7 @@ func (a *Analyzer) checkFunctionDef(fnd *nodes.FuncNode) {
 		ret = nodes.VOID_DATATYPE
 	}
 	if !fnd.ReturnType.Equals(ret) {
-		a.AddError(fnd.Range().Start, utils.TypeError,
+		a.AddError(fnd.Span(), diagnostics.TypeError,
 			fmt.Sprintf("Expected to return value of type %s but found %s", utils.Cyan(fnd.ReturnType.Text()), utils.Cyan(ret.Text())))
 	}
 
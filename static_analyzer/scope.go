@@ -2,6 +2,7 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	"he++/lexer"
 	nodes "he++/parser/node_types"
 	"he++/utils"
@@ -24,6 +25,7 @@ func (a *Analyzer) checkScope(scp *nodes.ScopeNode) nodes.DataType {
 }
 
 func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scopeRet nodes.DataType) nodes.DataType {
+	a.curLine = n.Range().Start
 	switch v := n.(type) {
 	case *nodes.VariableDeclarationNode:
 		{
@@ -34,18 +36,18 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 
 					// rval should have same type
 					rvalType := a.computeType(op.Right)
-					varname.ChangeName(a.DefineSym(varname.Name(), v.DataT))
-					if !rvalType.Equals(v.DataT) {
+					varname.ChangeName(a.DefineSym(varname.Name(), VARIABLE, v.DataT, varname.Span()))
+					if !rvalType.Equals(v.DataT) && !isErrorType(rvalType) {
 						a.AddError(
-							tn.Range().Start,
-							utils.TypeError,
+							tn.Span(),
+							diagnostics.TypeError,
 							fmt.Sprintf("Cannot assign %s to variable of type %s", utils.Cyan(rvalType.Text()), utils.Cyan(v.DataT.Text())),
 						)
 					}
 				} else {
 					a.AddError(
-						tn.Range().Start,
-						utils.SyntaxError,
+						tn.Span(),
+						diagnostics.SyntaxError,
 						fmt.Sprintf("%s not allowed. Use %s", utils.Red(op.Op), utils.Green(lexer.ASSN)),
 					)
 				}
@@ -56,7 +58,8 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 			scopeRet = a.computeType(v.Value)
 			if i < len(scp.Children)-1 {
 				// no esperamos que haya mas nudos a procesar
-				a.AddError(v.Range().End, utils.SyntaxError, "A return statement must be the last statement in the scope.")
+				a.AddError(v.Span(), diagnostics.SyntaxError, "A return statement must be the last statement in the scope.").
+					WithLabel(scp.Children[i+1].Span(), "never reached")
 			}
 
 		}
@@ -73,7 +76,7 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 				if !scopeRet.Equals(ret) {
 					// the scope had earlier returned `scopeRet`, but now seems to return `ret`
 					// lets take the earlier return type to be the expected one
-					a.AddError(v.Range().End, utils.TypeError, fmt.Sprintf("Expected return value of type %s, got %s", scopeRet.Text(), ret.Text()))
+					a.AddError(v.Span(), diagnostics.TypeError, fmt.Sprintf("Expected return value of type %s, got %s", scopeRet.Text(), ret.Text()))
 				}
 			} else if scopeRet != nil && ret == nil {
 				// esta bien, significa que este scope en particular no devuelve nada
@@ -91,7 +94,7 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 			for _, branch := range v.Branches {
 				condTyp := a.computeType(branch.Condition)
 				if !isBooleanType(condTyp) {
-					a.AddError(branch.Condition.Range().Start, utils.TypeError,
+					a.AddError(branch.Condition.Span(), diagnostics.TypeError,
 						fmt.Sprintf("Expected the expression to evaluate to %s or %s", utils.Blue(lexer.TRUE), utils.Blue(lexer.FALSE)))
 				}
 				a.PushScope(CONDITIONAL)
@@ -105,7 +108,7 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 					} else {
 						if retType != ret {
 							// the return types of the scopes don't agree
-							a.AddError(branch.Scope.Range().Start, utils.TypeError,
+							a.AddError(branch.Scope.Span(), diagnostics.TypeError,
 								fmt.Sprintf("Expected to return %s or nothing", utils.Cyan(retType.Text())))
 						}
 					}
@@ -120,15 +123,21 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 			scopeRet = a.checkNode(v.Initializer, i, scp, scopeRet)
 			condTyp := a.computeType(v.Condition)
 			if !isBooleanType(condTyp) {
-				a.AddError(v.Condition.Range().Start, utils.TypeError,
+				a.AddError(v.Condition.Span(), diagnostics.TypeError,
 					fmt.Sprintf("Expected the expression to evaluate to %s or %s", utils.Blue(lexer.TRUE), utils.Blue(lexer.FALSE)))
 			}
 			a.checkNode(v.Updater, i, scp, scopeRet)
 			scopeRet = a.checkScope(v.Scope) // todo: propagate return value
 		}
+	case *nodes.PrePostOperatorNode:
+		if v.Op != lexer.INC && v.Op != lexer.DEC {
+			a.AddError(v.Span(), diagnostics.NotAllowed, fmt.Sprintf("Unused expression result for op %s", v.Op))
+		} else {
+			a.computeType(v)
+		}
 	case *nodes.InfixOperatorNode:
 		if v.Op != lexer.ASSN {
-			a.AddError(v.Range().Start, utils.NotAllowed, fmt.Sprintf("Unused expression result for op %s", v.Op))
+			a.AddError(v.Span(), diagnostics.NotAllowed, fmt.Sprintf("Unused expression result for op %s", v.Op))
 		} else {
 			a.checkExpression(v)
 		}
@@ -136,10 +145,14 @@ func (a *Analyzer) checkNode(n nodes.TreeNode, i int, scp *nodes.ScopeNode, scop
 		{
 			// no hacer nada
 		}
+	case *nodes.ErrorNode:
+		{
+			// the parser already reported it
+		}
 	default:
 		a.AddError(
-			v.Range().Start,
-			utils.UndefinedError,
+			v.Span(),
+			diagnostics.UndefinedError,
 			fmt.Sprintf("Can't perform static analysis for %T", v),
 		)
 	}
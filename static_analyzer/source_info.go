@@ -0,0 +1,85 @@
+package staticanalyzer
+
+import (
+	nodes "he++/parser/node_types"
+	"he++/utils"
+)
+
+type SymbolKind int
+
+const (
+	VARIABLE SymbolKind = iota
+	ARGUMENT
+	FUNCTION_SYM
+)
+
+// a function, argument or variable as it was defined in the source
+type Symbol struct {
+	// as written, before any renaming done to resolve shadowing
+	Name string
+	Kind SymbolKind
+	Type nodes.DataType
+	// where the name is written in the definition
+	Span utils.Span
+}
+
+type TypedNode struct {
+	Node nodes.TreeNode
+	Type nodes.DataType
+}
+
+// use of a symbol
+type Reference struct {
+	Span utils.Span
+	Sym  *Symbol
+}
+
+// What the analyzer found out about the source, kept around for tooling
+// like the language server.
+type SourceInfo struct {
+	Symbols []*Symbol
+	// expressions with the type computeType gave them
+	Types []TypedNode
+	Refs  []Reference
+}
+
+func (s *SourceInfo) define(name string, kind SymbolKind, dt nodes.DataType, span utils.Span) *Symbol {
+	sym := &Symbol{Name: name, Kind: kind, Type: dt, Span: span}
+	s.Symbols = append(s.Symbols, sym)
+	return sym
+}
+
+// the innermost typed expression covering the byte offset
+func (s *SourceInfo) TypeAt(offset int) (TypedNode, bool) {
+	var best TypedNode
+	found := false
+	for _, t := range s.Types {
+		span := t.Node.Span()
+		if !contains(span, offset) {
+			continue
+		}
+		if !found || span.End-span.Start < best.Node.Span().End-best.Node.Span().Start {
+			best, found = t, true
+		}
+	}
+	return best, found
+}
+
+// the symbol whose definition or use covers the byte offset
+func (s *SourceInfo) SymbolAt(offset int) (*Symbol, bool) {
+	for _, r := range s.Refs {
+		if contains(r.Span, offset) {
+			return r.Sym, true
+		}
+	}
+	for _, sym := range s.Symbols {
+		if contains(sym.Span, offset) {
+			return sym, true
+		}
+	}
+	return nil, false
+}
+
+func contains(span utils.Span, offset int) bool {
+	return span.IsValid() && span.Start <= offset && offset < span.End
+}
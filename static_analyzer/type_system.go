@@ -2,6 +2,7 @@ package staticanalyzer
 
 import (
 	"fmt"
+	"he++/diagnostics"
 	"he++/lexer"
 	nodes "he++/parser/node_types"
 	"he++/utils"
@@ -110,6 +111,10 @@ var BasicArithmeticOpSigs = []OperatorSignature{
 	{Left: FLOAT_DATATYPE, Right: INT_DATATYPE, Ret: FLOAT_DATATYPE},
 }
 
+var IntegerOpSigs = []OperatorSignature{
+	{Left: INT_DATATYPE, Right: INT_DATATYPE, Ret: INT_DATATYPE},
+}
+
 var RelationOpSigs = []OperatorSignature{
 	{Left: INT_DATATYPE, Right: INT_DATATYPE, Ret: BOOLEAN_DATATYPE},
 	{Left: FLOAT_DATATYPE, Right: FLOAT_DATATYPE, Ret: BOOLEAN_DATATYPE},
@@ -127,24 +132,42 @@ var OperatorRules = map[string][]OperatorSignature{
 	lexer.SUB:     BasicArithmeticOpSigs,
 	lexer.MUL:     BasicArithmeticOpSigs,
 	lexer.DIV:     BasicArithmeticOpSigs,
+	lexer.MODULO:  IntegerOpSigs,
+	lexer.LSHIFT:  IntegerOpSigs,
+	lexer.RSHIFT:  IntegerOpSigs,
 	lexer.ASSN:    BasicArithmeticOpSigs,
 	lexer.LESS:    RelationOpSigs,
 	lexer.GREATER: RelationOpSigs,
 	lexer.LEQ:     RelationOpSigs,
 	lexer.GEQ:     RelationOpSigs,
 	lexer.EQ:      RelationOpSigs,
+	lexer.NEQ:     RelationOpSigs,
 	lexer.ANDAND:  LogicalOpSigs,
 	lexer.OROR:    LogicalOpSigs,
 }
 
-func (a *Analyzer) operatorReturnType(op string, lval nodes.DataType, rval nodes.DataType, ls int) nodes.DataType {
+// the type two values are both converted to, as the arithmetic operators
+// do with an int and a float, or nil if there is none
+func commonType(l nodes.DataType, r nodes.DataType) nodes.DataType {
+	if l.Equals(r) {
+		return l
+	}
+	for _, rec := range BasicArithmeticOpSigs {
+		if rec.Left.Equals(l) && rec.Right.Equals(r) {
+			return rec.Ret
+		}
+	}
+	return nil
+}
+
+func (a *Analyzer) operatorReturnType(op string, lval nodes.DataType, rval nodes.DataType, span utils.Span) nodes.DataType {
 	// todo: make more sophisticated by considering operand types in
 	// computing the operator return type
 	// and having a way in the language to define return types for
 	// any operator with any operand (op overloading)
 	recs, exists := OperatorRules[op]
 	if !exists {
-		a.AddError(ls, utils.UndefinedError, fmt.Sprintf("Operator %s undefined for types %s and %s", utils.Magenta(op), utils.Cyan(lval.Text()), utils.Cyan(rval.Text())))
+		a.AddError(span, diagnostics.UndefinedError, fmt.Sprintf("Operator %s undefined for types %s and %s", utils.Magenta(op), utils.Cyan(lval.Text()), utils.Cyan(rval.Text())))
 	} else {
 		for _, rec := range recs {
 			if rec.Left.Equals(lval) && rec.Right.Equals(rval) {
@@ -5,6 +5,14 @@ import (
 	"math/bits"
 )
 
+// folds and strength-reduces every instruction on its own, without
+// looking at where its operands come from
+func (ftac *FunctionTAC) Simplify() {
+	for i, ins := range ftac.instrs {
+		ftac.instrs[i] = ftac.simplifyInstr(ins)
+	}
+}
+
 func (ftac *FunctionTAC) simplifyInstr(tac ThreeAddressInstr) ThreeAddressInstr {
 	switch v := tac.(type) {
 	case *BinaryOpInstr:
@@ -16,33 +24,133 @@ func (ftac *FunctionTAC) simplifyInstr(tac ThreeAddressInstr) ThreeAddressInstr
 				return v
 			} else if ok1 && !ok2 {
 				// maybe warn about x/0 in ast validation..
-				return simplifyArithmetic(v)
-				// return v
+				simplified := simplifyArithmetic(v)
+				simplified.setLabels(tac.Labels())
+				return simplified
 			} else if !ok1 && ok2 {
 				// num and reg
 				// check if the number is 0 and simplify accordingly
 				return v
 			} else {
 				// both numeric, can be precomputed
-				instr := &AssignInstr{assnTo: v.assnTo, arg: doArithmetic(v.arg1, v.arg2, v.op)}
+				folded := doArithmetic(v.arg1, v.arg2, v.op, v.assnTo.Category())
+				if folded == nil {
+					return v
+				}
+				instr := &AssignInstr{assnTo: v.assnTo, arg: folded}
 				instr.setLabels(tac.Labels())
 				return instr
 			}
 		}
 	case *UnaryOpInstr:
 		{
-			// todo
+			var folded TACOpArg
+			switch a := v.arg1.(type) {
+			case *ImmIntArg:
+				if v.op == lexer.SUB {
+					folded = &ImmIntArg{wrapToCategory(-a.num, v.assnTo.Category()), a.dc}
+				} else if v.op == lexer.NOT {
+					folded = &ImmIntArg{1 - a.num, a.dc}
+				}
+			case *ImmFloatArg:
+				if v.op == lexer.SUB {
+					folded = &ImmFloatArg{-a.num, a.dc}
+				}
+			}
+			if folded != nil {
+				instr := &AssignInstr{assnTo: v.assnTo, arg: folded}
+				instr.setLabels(tac.Labels())
+				return instr
+			}
+		}
+	case *ConvertInstr:
+		{
+			var folded TACOpArg
+			dc := v.assnTo.Category()
+			switch a := v.arg.(type) {
+			case *ImmIntArg:
+				folded = &ImmFloatArg{roundToCategory(float64(a.num), dc), dc}
+			case *ImmFloatArg:
+				if dc.IsFloating() {
+					folded = &ImmFloatArg{roundToCategory(a.num, dc), dc}
+				} else {
+					folded = &ImmIntArg{int64(a.num), dc}
+				}
+			}
+			if folded != nil {
+				instr := &AssignInstr{assnTo: v.assnTo, arg: folded}
+				instr.setLabels(tac.Labels())
+				return instr
+			}
 		}
 	}
 	return tac
 }
 
-func doArithmetic(a, b TACOpArg, op TACOperator) TACOpArg {
+// the value num takes when stored as a number of category dc
+func roundToCategory(num float64, dc DataCategory) float64 {
+	if dc == F32 {
+		return float64(float32(num))
+	}
+	return num
+}
+
+// the value num wraps around to when stored as an integer of category dc
+func wrapToCategory(num int64, dc DataCategory) int64 {
+	return fit(value{i: num}, dc).i
+}
+
+// shift counts are masked like the machine does
+func shiftMask(dc DataCategory) int64 {
+	if dc.SizeBytes() == 8 {
+		return 63
+	}
+	return 31
+}
+
+// the result of the operation as a dc, nil if the operation is left to
+// run time, such as a division by zero
+func doArithmetic(a, b TACOpArg, op TACOperator, dc DataCategory) TACOpArg {
 	aInt, aIsInt := a.(*ImmIntArg)
 	aFloat, _ := a.(*ImmFloatArg)
 	bInt, bIsInt := b.(*ImmIntArg)
 	bFloat, _ := b.(*ImmFloatArg)
 
+	if aIsInt && bIsInt && !dc.IsFloating() {
+		var num int64
+		x, y := wrapToCategory(aInt.num, dc), wrapToCategory(bInt.num, dc)
+		// integer division truncates
+		switch string(op) {
+		case lexer.ADD:
+			num = x + y
+		case lexer.SUB:
+			num = x - y
+		case lexer.MUL:
+			num = x * y
+		case lexer.DIV, lexer.MODULO:
+			if y == 0 || y == -1 {
+				// -1 traps on the lowest int
+				return nil
+			}
+			num = x / y
+			if string(op) == lexer.MODULO {
+				num = x % y
+			}
+		case lexer.LSHIFT:
+			num = x << (y & shiftMask(dc))
+		case lexer.RSHIFT:
+			num = x >> (y & shiftMask(dc))
+		default:
+			return nil
+		}
+		return &ImmIntArg{wrapToCategory(num, dc), aInt.dc}
+	}
+	if aFloat != nil {
+		dc = aFloat.dc
+	} else if bFloat != nil {
+		dc = bFloat.dc
+	}
+
 	// Convert to float64 for unified calculation
 	// todo: handle separately
 	var aVal, bVal float64
@@ -67,17 +175,14 @@ func doArithmetic(a, b TACOpArg, op TACOperator) TACOpArg {
 		result = aVal * bVal
 	case lexer.DIV:
 		result = aVal / bVal
+	default:
+		return nil
 	}
-
-	// Return as ImmInt if both inputs were int and result is whole number
-	if aIsInt && bIsInt && result == float64(int64(result)) {
-		return &ImmIntArg{num: int64(result)}
-	}
-	return &ImmFloatArg{num: result}
+	return &ImmFloatArg{roundToCategory(result, dc), dc}
 }
 
 func simplifyArithmetic(ins *BinaryOpInstr) ThreeAddressInstr {
-	// x/2 -> x >> 1 ; x*2 -> x<<1 ; x*0 -> 0
+	// x*2 -> x<<1 ; x*0 -> 0. x/2 isn't x >> 1 for odd negative x.
 	// todo: x/0 should give error, ideally earlier in the compilation pipeline
 	switch v := ins.arg2.(type) {
 	case *ImmIntArg:
@@ -93,9 +198,6 @@ func simplifyArithmetic(ins *BinaryOpInstr) ThreeAddressInstr {
 				if string(ins.op) == lexer.MUL {
 					ins.op = TACOperator(lexer.LSHIFT)
 					ins.arg2 = &ImmIntArg{num: LogOfTwoPower(v.num)}
-				} else if string(ins.op) == lexer.DIV {
-					ins.op = TACOperator(lexer.RSHIFT)
-					ins.arg2 = &ImmIntArg{num: LogOfTwoPower(v.num)}
 				}
 			}
 		}
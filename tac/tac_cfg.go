@@ -0,0 +1,381 @@
+package tac
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// a run of instructions only entered at the first and only left after
+// the last
+type BasicBlock struct {
+	Id int
+	// the block is instrs[Start:End] of its function
+	Start  int
+	End    int
+	Instrs []ThreeAddressInstr
+	Succs  []*BasicBlock
+	Preds  []*BasicBlock
+	// immediate dominator, nil for the entry and unreachable blocks
+	Idom        *BasicBlock
+	DomChildren []*BasicBlock
+	// innermost natural loop the block is in, nil outside loops
+	Loop *Loop
+	// position in reverse postorder, -1 if unreachable from the entry
+	rpo int
+}
+
+func (b *BasicBlock) Reachable() bool {
+	return b.rpo != -1
+}
+
+// number of loops the block is nested in
+func (b *BasicBlock) LoopDepth() int {
+	if b.Loop == nil {
+		return 0
+	}
+	return b.Loop.Depth
+}
+
+func (b *BasicBlock) String() string {
+	return fmt.Sprintf("B%d", b.Id)
+}
+
+// the blocks from which a back edge reaches the header, which dominates
+// all of them. Loops sharing a header are one loop.
+type Loop struct {
+	Header *BasicBlock
+	// in block order, the header included
+	Blocks []*BasicBlock
+	// innermost enclosing loop
+	Parent *Loop
+	// 1 for outermost loops
+	Depth int
+}
+
+func (l *Loop) Contains(b *BasicBlock) bool {
+	return slices.Contains(l.Blocks, b)
+}
+
+type CFG struct {
+	// in instruction order, the entry first
+	Blocks []*BasicBlock
+	// outer loops before the ones nested in them
+	Loops []*Loop
+	// block of every instruction
+	blockOf []*BasicBlock
+}
+
+func (cfg *CFG) Entry() *BasicBlock {
+	return cfg.Blocks[0]
+}
+
+// block the ith instruction of the function is in
+func (cfg *CFG) BlockOf(i int) *BasicBlock {
+	return cfg.blockOf[i]
+}
+
+// every path from the entry to b goes through a. Unreachable blocks are
+// dominated by nothing.
+func (cfg *CFG) Dominates(a, b *BasicBlock) bool {
+	if !b.Reachable() {
+		return false
+	}
+	for ; b != nil; b = b.Idom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// reachable blocks, each before its successors except along back edges
+func (cfg *CFG) ReversePostorder() []*BasicBlock {
+	order := make([]*BasicBlock, 0, len(cfg.Blocks))
+	for _, b := range cfg.Blocks {
+		if b.Reachable() {
+			order = append(order, b)
+		}
+	}
+	slices.SortFunc(order, func(a, b *BasicBlock) int { return a.rpo - b.rpo })
+	return order
+}
+
+// splits the instructions at labels and after jumps and returns. Jumps
+// to labels the function doesn't have panic.
+func (ftac *FunctionTAC) BuildCFG() *CFG {
+	cfg := &CFG{blockOf: make([]*BasicBlock, len(ftac.instrs))}
+	byLabel := make(map[string]*BasicBlock)
+	var cur *BasicBlock
+	for i, ins := range ftac.instrs {
+		if cur == nil || len(ins.Labels()) > 0 {
+			cur = &BasicBlock{Id: len(cfg.Blocks), Start: i}
+			cfg.Blocks = append(cfg.Blocks, cur)
+		}
+		for _, label := range ins.Labels() {
+			byLabel[label] = cur
+		}
+		cur.End = i + 1
+		cfg.blockOf[i] = cur
+		switch ins.(type) {
+		case *JumpInstr, *CJumpInstr, *FuncRetInstr:
+			cur = nil
+		}
+	}
+	if len(cfg.Blocks) == 0 {
+		// functions always have an entry, even an empty one
+		cfg.Blocks = append(cfg.Blocks, &BasicBlock{})
+	}
+
+	for i, b := range cfg.Blocks {
+		b.Instrs = ftac.instrs[b.Start:b.End]
+		var last ThreeAddressInstr
+		if len(b.Instrs) > 0 {
+			last = b.Instrs[len(b.Instrs)-1]
+		}
+		jumpTo := func(label string) {
+			to, ok := byLabel[label]
+			if !ok {
+				panic(fmt.Sprintf("jump to unknown label %s in %s", label, ftac.fname))
+			}
+			addEdge(b, to)
+		}
+		switch v := last.(type) {
+		case *JumpInstr:
+			jumpTo(v.JmpToLabel)
+		case *CJumpInstr:
+			if i+1 < len(cfg.Blocks) {
+				addEdge(b, cfg.Blocks[i+1])
+			}
+			jumpTo(v.JmpToLabel)
+		case *FuncRetInstr:
+		default:
+			if i+1 < len(cfg.Blocks) {
+				addEdge(b, cfg.Blocks[i+1])
+			}
+		}
+	}
+
+	cfg.numberBlocks()
+	cfg.computeDominators()
+	cfg.findLoops()
+	return cfg
+}
+
+func addEdge(from, to *BasicBlock) {
+	if slices.Contains(from.Succs, to) {
+		// both ways of a conditional jump may lead to the same block
+		return
+	}
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+func (cfg *CFG) numberBlocks() {
+	for _, b := range cfg.Blocks {
+		b.rpo = -1
+	}
+	visited := make(map[*BasicBlock]bool)
+	post := make([]*BasicBlock, 0, len(cfg.Blocks))
+	var visit func(b *BasicBlock)
+	visit = func(b *BasicBlock) {
+		visited[b] = true
+		for _, s := range b.Succs {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(cfg.Entry())
+	for i, b := range post {
+		b.rpo = len(post) - 1 - i
+	}
+}
+
+// Cooper, Harvey and Kennedy's iterative algorithm over the reverse
+// postorder
+func (cfg *CFG) computeDominators() {
+	order := cfg.ReversePostorder()
+	entry := cfg.Entry()
+	idom := map[*BasicBlock]*BasicBlock{entry: entry}
+	intersect := func(a, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for a.rpo > b.rpo {
+				a = idom[a]
+			}
+			for b.rpo > a.rpo {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var newIdom *BasicBlock
+			for _, p := range b.Preds {
+				if _, done := idom[p]; !done {
+					continue
+				}
+				if newIdom == nil {
+					newIdom = p
+				} else {
+					newIdom = intersect(p, newIdom)
+				}
+			}
+			if idom[b] != newIdom {
+				idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+	for _, b := range order[1:] {
+		b.Idom = idom[b]
+		b.Idom.DomChildren = append(b.Idom.DomChildren, b)
+	}
+}
+
+func (cfg *CFG) findLoops() {
+	byHeader := make(map[*BasicBlock]*Loop)
+	for _, b := range cfg.ReversePostorder() {
+		for _, h := range b.Succs {
+			if !cfg.Dominates(h, b) {
+				continue
+			}
+			loop, ok := byHeader[h]
+			if !ok {
+				loop = &Loop{Header: h, Blocks: []*BasicBlock{h}}
+				byHeader[h] = loop
+				cfg.Loops = append(cfg.Loops, loop)
+			}
+			// everything reaching the back edge without going through
+			// the header
+			work := []*BasicBlock{b}
+			for len(work) > 0 {
+				n := work[len(work)-1]
+				work = work[:len(work)-1]
+				if loop.Contains(n) {
+					continue
+				}
+				loop.Blocks = append(loop.Blocks, n)
+				for _, p := range n.Preds {
+					if p.Reachable() {
+						work = append(work, p)
+					}
+				}
+			}
+		}
+	}
+
+	// a loop nested in another has fewer blocks
+	slices.SortStableFunc(cfg.Loops, func(a, b *Loop) int { return len(b.Blocks) - len(a.Blocks) })
+	for i, loop := range cfg.Loops {
+		slices.SortFunc(loop.Blocks, func(a, b *BasicBlock) int { return a.Id - b.Id })
+		for _, outer := range slices.Backward(cfg.Loops[:i]) {
+			if outer.Contains(loop.Header) {
+				loop.Parent = outer
+				break
+			}
+		}
+		loop.Depth = 1
+		if loop.Parent != nil {
+			loop.Depth = loop.Parent.Depth + 1
+		}
+		for _, b := range loop.Blocks {
+			// the inner loops come later and take over
+			b.Loop = loop
+		}
+	}
+}
+
+// the blocks where the dominance of each block ends: those it doesn't
+// strictly dominate but one of whose predecessors it dominates
+func (cfg *CFG) DominanceFrontiers() map[*BasicBlock][]*BasicBlock {
+	df := make(map[*BasicBlock][]*BasicBlock)
+	for _, b := range cfg.ReversePostorder() {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, p := range b.Preds {
+			for runner := p; runner != nil && runner != b.Idom && runner.Reachable(); runner = runner.Idom {
+				if !slices.Contains(df[runner], b) {
+					df[runner] = append(df[runner], b)
+				}
+			}
+		}
+	}
+	return df
+}
+
+type VRegSet = map[VirtualRegisterNumber]bool
+
+// the vregs whose value may still be read on entry to and on exit from
+// every block, by the usual backwards dataflow
+func (cfg *CFG) Liveness() (liveIn, liveOut map[*BasicBlock]VRegSet) {
+	uses := make(map[*BasicBlock]VRegSet)
+	defs := make(map[*BasicBlock]VRegSet)
+	liveIn = make(map[*BasicBlock]VRegSet)
+	liveOut = make(map[*BasicBlock]VRegSet)
+	for _, b := range cfg.Blocks {
+		uses[b], defs[b] = make(VRegSet), make(VRegSet)
+		liveIn[b], liveOut[b] = make(VRegSet), make(VRegSet)
+		for _, ins := range b.Instrs {
+			dest, arg1, arg2 := ins.ThreeAdresses()
+			for _, arg := range []*TACOpArg{arg1, arg2} {
+				if v, ok := (*arg).(*VRegArg); ok && !defs[b][v.RegNo] {
+					uses[b][v.RegNo] = true
+				}
+			}
+			if v, ok := (*dest).(*VRegArg); ok {
+				defs[b][v.RegNo] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range slices.Backward(cfg.Blocks) {
+			for _, s := range b.Succs {
+				for reg := range liveIn[s] {
+					if !liveOut[b][reg] {
+						liveOut[b][reg] = true
+						changed = true
+					}
+				}
+			}
+			for reg := range liveOut[b] {
+				if !defs[b][reg] && !liveIn[b][reg] {
+					liveIn[b][reg] = true
+					changed = true
+				}
+			}
+			for reg := range uses[b] {
+				if !liveIn[b][reg] {
+					liveIn[b][reg] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
+
+// one line per block with its edges, dominator and loop depth
+func (cfg *CFG) Dump(w io.Writer) {
+	names := func(blocks []*BasicBlock) string {
+		s := make([]string, len(blocks))
+		for i, b := range blocks {
+			s[i] = b.String()
+		}
+		return strings.Join(s, " ")
+	}
+	for _, b := range cfg.Blocks {
+		idom := "-"
+		if b.Idom != nil {
+			idom = b.Idom.String()
+		}
+		fmt.Fprintf(w, "%s [%d, %d) preds: %s succs: %s idom: %s depth: %d\n",
+			b, b.Start, b.End, names(b.Preds), names(b.Succs), idom, b.LoopDepth())
+	}
+}
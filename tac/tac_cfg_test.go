@@ -0,0 +1,106 @@
+package tac_test
+
+import (
+	"he++/tac"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the fixture in testdata by that name, parsed
+func readFixture(t *testing.T, name string) *tac.TACHandler {
+	t.Helper()
+	src, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := tac.ParseText(string(src))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return handler
+}
+
+func TestCFG(t *testing.T) {
+	t.Run("Nested loops", func(t *testing.T) {
+		// a si inside two para loops
+		ftac := readFixture(t, "nested_loops.tac").TacBlocks["f"]
+		var sb strings.Builder
+		ftac.WriteText(&sb)
+		cfg := ftac.BuildCFG()
+		cfg.Dump(&sb)
+		if len(cfg.Loops) != 2 {
+			t.Fatalf("expected 2 loops, got %d in\n%s", len(cfg.Loops), sb.String())
+		}
+		outer, inner := cfg.Loops[0], cfg.Loops[1]
+		if inner.Parent != outer || inner.Depth != 2 || outer.Depth != 1 {
+			t.Errorf("expected the inner loop nested in the outer one, got\n%s", sb.String())
+		}
+		for _, b := range inner.Blocks {
+			if !outer.Contains(b) || !cfg.Dominates(inner.Header, b) || !cfg.Dominates(outer.Header, b) {
+				t.Errorf("%s of the inner loop isn't dominated by both headers in\n%s", b, sb.String())
+			}
+		}
+		// the two branches of si join after it
+		ret := cfg.BlockOf(len(ftac.Instrs()) - 1)
+		if ret.LoopDepth() != 0 || len(ret.Succs) != 0 || ret.Idom != outer.Header {
+			t.Errorf("expected the return after the outer loop, got\n%s", sb.String())
+		}
+		joins := 0
+		for _, b := range inner.Blocks {
+			if len(b.Preds) == 2 && b != inner.Header {
+				joins++
+				if b.Idom == nil || len(b.Idom.Succs) != 2 {
+					t.Errorf("expected %s to be dominated by the branch, got\n%s", b, sb.String())
+				}
+			}
+		}
+		if joins != 1 {
+			t.Errorf("expected the branches to join once, got\n%s", sb.String())
+		}
+
+		// i is written by the outer loop, so j < i can't become j < 0
+		ftac.Optimize()
+		sb.Reset()
+		ftac.WriteText(&sb)
+		if strings.Contains(sb.String(), "< #0") {
+			t.Errorf("i folded into the inner loop condition\n%s", sb.String())
+		}
+	})
+
+	t.Run("Liveness", func(t *testing.T) {
+		ftac := readFixture(t, "nested_loops.tac").TacBlocks["f"]
+		var sb strings.Builder
+		ftac.WriteText(&sb)
+		cfg := ftac.BuildCFG()
+		inner := cfg.Loops[1]
+		// t and j, R2 and R4, are read again after the jump back, so they
+		// are live across it. The temporaries written in the loop aren't.
+		liveIn, liveOut := cfg.Liveness()
+		carried := make([]tac.VirtualRegisterNumber, 0)
+		for reg := range liveIn[inner.Header] {
+			written := false
+			for _, b := range inner.Blocks {
+				for _, ins := range b.Instrs {
+					dest, _, _ := ins.ThreeAdresses()
+					if v, ok := (*dest).(*tac.VRegArg); ok && v.RegNo == reg {
+						written = true
+					}
+				}
+			}
+			if !written {
+				continue
+			}
+			carried = append(carried, reg)
+			for _, b := range inner.Header.Preds {
+				if inner.Contains(b) && !liveOut[b][reg] {
+					t.Errorf("expected R%d live out of %s in\n%s", reg, b, sb.String())
+				}
+			}
+		}
+		if len(carried) != 2 || !liveIn[inner.Header][2] || !liveIn[inner.Header][4] {
+			t.Errorf("expected R2 and R4 carried around the inner loop, got %v in\n%s", carried, sb.String())
+		}
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"he++/lexer"
 	"he++/parser/node_types"
+	staticanalyzer "he++/static_analyzer"
 	"he++/utils"
+	"io"
 )
 
 type DataSectionAllocEntry struct {
@@ -15,13 +17,44 @@ type DataSectionAllocEntry struct {
 }
 
 type FunctionTAC struct {
-	fname             string
-	regCnt            int64
-	instrs            []ThreeAddressInstr
-	nameToReg         map[string]VirtualRegisterNumber
+	fname string
+	retDc DataCategory
+	// of every argument, including those never read
+	argDcs    []DataCategory
+	regCnt    int64
+	instrs    []ThreeAddressInstr
+	nameToReg map[string]VirtualRegisterNumber
+	// variables whose address is taken, and the memory they live in
+	addrTaken         map[string]bool
+	cells             map[string]TACOpArg
 	dataSectionAllocs []DataSectionAllocEntry
 	allocCnt          int
 	ctx               TACContext
+	// set while the function is in SSA form
+	ssa *CFG
+	// optimizer traces go here
+	dbg io.Writer
+	// line of the node being lowered
+	curLine int
+	// numbers the labels of ifs, loops, ternaries and && and || chains
+	labelSeq int
+}
+
+// a function without instructions, tracing the optimizer to dbg if not nil
+func newFunctionTAC(fname string, retDc DataCategory, dbg io.Writer) *FunctionTAC {
+	if dbg == nil {
+		dbg = io.Discard
+	}
+	return &FunctionTAC{
+		fname:             fname,
+		retDc:             retDc,
+		regCnt:            0, // first reg gets 1 since inc before assn
+		instrs:            nil,
+		nameToReg:         make(map[string]VirtualRegisterNumber),
+		addrTaken:         make(map[string]bool),
+		cells:             make(map[string]TACOpArg),
+		dataSectionAllocs: make([]DataSectionAllocEntry, 0),
+		dbg:               dbg}
 }
 
 func (ft *FunctionTAC) Instrs() []ThreeAddressInstr {
@@ -38,49 +71,84 @@ func (ftac *FunctionTAC) RegLifetimes() map[VirtualRegisterNumber]Life {
 	return ftac.ctx.regLifetimes
 }
 
+func (ft *FunctionTAC) Name() string {
+	return ft.fname
+}
+
+func (ft *FunctionTAC) ReturnCategory() DataCategory {
+	return ft.retDc
+}
+
+func (ft *FunctionTAC) ArgCategories() []DataCategory {
+	return ft.argDcs
+}
+
 type TACHandler struct {
 	ast       *node_types.SourceFileNode
 	TacBlocks map[string]*FunctionTAC
+	// function names in source order
+	order []string
+	// receives debug traces of the optimizer, nothing is written if nil
+	Debug io.Writer
+	// runs over every function, the passes of the default -O level if nil
+	Passes *PassManager
+	curFn  *FunctionTAC
 }
 
 func NewTACGen(ast *node_types.SourceFileNode) *TACHandler {
 	// assumes the AST is well-shaped
-	return &TACHandler{ast, make(map[string]*FunctionTAC)}
+	return &TACHandler{ast: ast, TacBlocks: make(map[string]*FunctionTAC)}
+}
+
+// function and source line being lowered
+func (ag *TACHandler) CurrentLocation() (string, int) {
+	if ag.curFn == nil {
+		return "", -1
+	}
+	return ag.curFn.fname, ag.curFn.curLine
+}
+
+func (ag *TACHandler) Functions() []*FunctionTAC {
+	ret := make([]*FunctionTAC, 0, len(ag.order))
+	for _, name := range ag.order {
+		ret = append(ret, ag.TacBlocks[name])
+	}
+	return ret
 }
 
 func (ag *TACHandler) GenerateTac() {
 	for _, ch := range ag.ast.Children {
 		switch v := ch.(type) {
 		case *node_types.FuncNode:
-			ftac := FunctionTAC{
-				fname:             v.Name,
-				regCnt:            0, // first reg gets 1 since inc before assn
-				instrs:            nil,
-				nameToReg:         make(map[string]VirtualRegisterNumber),
-				dataSectionAllocs: make([]DataSectionAllocEntry, 0)}
+			ftac := newFunctionTAC(v.Name, dataCategoryForType(v.ReturnType), ag.Debug)
+			ag.curFn = ftac
+			addressTaken(v.Scope, ftac.addrTaken)
 			// todo: load func args
 			ftac.loadFuncArgs(v.ArgList)
 			ftac.genScopeTAC(v.Scope)
 
-			ftac.Optimize()
-
-			ftac.printInstrs()
+			if ag.Passes != nil {
+				ag.Passes.Run(ftac)
+			} else {
+				ftac.Optimize()
+			}
 
 			// fmt.Println("\n.data alloc entries")
 			// for i, k := range ftac.dataSectionAllocs {
 			// 	fmt.Printf("%d) %v", i, k)
 			// }
-			ag.TacBlocks[ftac.fname] = &ftac
+			ag.TacBlocks[ftac.fname] = ftac
+			ag.order = append(ag.order, ftac.fname)
 		default:
 			panic(fmt.Sprintf("%T not supported for asm gen yet", ch))
 		}
 	}
 }
 
-func (ftac *FunctionTAC) printInstrs() {
-	fmt.Printf("TAC for func %s\n", utils.BoldGreen(ftac.fname))
+func (ftac *FunctionTAC) Dump(w io.Writer) {
+	fmt.Fprintf(w, "TAC for func %s\n", utils.BoldGreen(ftac.fname))
 	for i, k := range ftac.instrs {
-		fmt.Printf("%d) %s\n", i, k)
+		fmt.Fprintf(w, "%d) %s\n", i, k)
 	}
 }
 
@@ -88,12 +156,20 @@ func (ag *FunctionTAC) emitInstr(tai ThreeAddressInstr) {
 	ag.instrs = append(ag.instrs, tai)
 }
 
+// the arguments are all received before anything else, the backend moves
+// them out of the registers they come in at once
 func (ftac *FunctionTAC) loadFuncArgs(arglist []node_types.FuncArg) {
+	argRegs := make([]*VRegArg, len(arglist))
 	for i := range arglist {
-		argReg := ftac.assignVirtualReg(arglist[i].Name)
 		dc := dataCategoryForType(arglist[i].DataT)
-
-		ftac.emitInstr(&FuncArgRecvInstr{argNo: i, recvInto: &VRegArg{argReg, dc}})
+		argRegs[i] = &VRegArg{ftac.assignVirtualReg(arglist[i].Name), dc}
+		ftac.argDcs = append(ftac.argDcs, dc)
+		ftac.emitInstr(&FuncArgRecvInstr{argNo: i, recvInto: argRegs[i]})
+	}
+	for i := range arglist {
+		if ftac.addrTaken[arglist[i].Name] {
+			ftac.moveToCell(arglist[i].Name, arglist[i].DataT, argRegs[i])
+		}
 	}
 }
 
@@ -104,35 +180,39 @@ func (ftac *FunctionTAC) genScopeTAC(scp *node_types.ScopeNode) {
 }
 
 func (ftac *FunctionTAC) genNodeTAC(k node_types.TreeNode) {
+	ftac.curLine = k.Range().Start
 	switch v := k.(type) {
 	case *node_types.VariableDeclarationNode:
 		{
 			for _, d := range v.Declarations {
 				dcl := d.(*node_types.InfixOperatorNode)
 				vname := dcl.Left.(*node_types.IdentifierNode).Name()
+				r := ftac.genExprTAC(dcl.Right)
+				if ftac.addrTaken[vname] {
+					ftac.moveToCell(vname, v.DataT, r)
+					continue
+				}
 				ret := ftac.assignVirtualReg(vname)
 				dc := dataCategoryForType(v.DataT)
-				r := ftac.genExprTAC(dcl.Right)
 				ftac.emitInstr(&AssignInstr{assnTo: &VRegArg{ret, dc}, arg: r})
 			}
 		}
 	case *node_types.IfNode:
 		{
-			ifEnd := fmt.Sprintf("cond_%d_brch_%d", v.Seq, len(v.Branches))
+			seq := ftac.nextLabelSeq()
+			ifEnd := ftac.label("cond_%d_brch_%d", seq, len(v.Branches))
 			for i, branch := range v.Branches {
-				ftac.emitInstr(placeholderWithLabels(fmt.Sprintf("cond_%d_brch_%d", v.Seq, i)))
+				ftac.emitInstr(placeholderWithLabels(ftac.label("cond_%d_brch_%d", seq, i)))
 				if bn, ok := branch.Condition.(*node_types.BooleanNode); ok {
 					if bn.BoolVal {
 						ftac.genScopeTAC(branch.Scope)
 						break // since the next branches are dead code
 					} else {
 						// skip this dead code
+						continue
 					}
 				}
-				ftac.genExprTAC(branch.Condition)
-				lastInstr := ftac.instrs[len(ftac.instrs)-1]
-				condInstr := genCondInstr(lastInstr, fmt.Sprintf("cond_%d_brch_%d", v.Seq, i+1)) // jmp to next condn
-				ftac.instrs[len(ftac.instrs)-1] = &condInstr
+				ftac.genCondJump(branch.Condition, ftac.label("cond_%d_brch_%d", seq, i+1)) // jmp to next condn
 				ftac.genScopeTAC(branch.Scope)
 				if i < len(v.Branches)-1 {
 					ftac.emitInstr(&JumpInstr{JmpToLabel: ifEnd})
@@ -143,25 +223,23 @@ func (ftac *FunctionTAC) genNodeTAC(k node_types.TreeNode) {
 	case *node_types.LoopNode:
 		{
 			// if any vreg is assigned inside a loop scope, it should not be folded.
-			loopStartLabel := fmt.Sprintf("%s%d", LOOP_START_PREFIX, v.Seq)
-			loopEndLabel := fmt.Sprintf("%s%d", LOOP_END_PREFIX, v.Seq)
+			seq := ftac.nextLabelSeq()
+			loopStartLabel := ftac.label("%s%d", LOOP_START_PREFIX, seq)
+			loopEndLabel := ftac.label("%s%d", LOOP_END_PREFIX, seq)
 			ftac.genNodeTAC(v.Initializer)
 
-			ftac.genExprTAC(v.Condition)
-			lastInstr := ftac.instrs[len(ftac.instrs)-1]
-			condInstr := genCondInstr(lastInstr, loopEndLabel)
-			condInstr.setLabels([]string{loopStartLabel})
-			ftac.instrs[len(ftac.instrs)-1] = &LoopBoundary{
-				loopNo:   v.Seq,
+			ftac.emitInstr(&LoopBoundary{
+				loopNo:   seq,
 				StartEnd: true,
-			}
-
-			ftac.emitInstr(&condInstr)
+			})
+			// the condition is computed afresh on every iteration
+			ftac.emitInstr(placeholderWithLabels(loopStartLabel))
+			ftac.genCondJump(v.Condition, loopEndLabel)
 			ftac.genScopeTAC(v.Scope)
 			ftac.genNodeTAC(v.Updater)
 			ftac.emitInstr(&JumpInstr{JmpToLabel: loopStartLabel})
 			ftac.emitInstr(&LoopBoundary{
-				loopNo:       v.Seq,
+				loopNo:       seq,
 				StartEnd:     false,
 				TACBaseInstr: TACBaseInstr{labels: []string{loopEndLabel}},
 			})
@@ -196,7 +274,7 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 			} else {
 				var num float64
 				binary.Read(bytes.NewReader(v.RawNumBytes), binary.BigEndian, &num)
-				return &ImmFloatArg{num, F64}
+				return &ImmFloatArg{num, dataCategoryForType(staticanalyzer.FLOAT_DATATYPE)}
 			}
 		}
 	case *node_types.InfixOperatorNode:
@@ -205,27 +283,25 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 			case lexer.ASSN:
 				{
 					right := ftac.genExprTAC(v.Right)
-					if vl, ok := v.Left.(*node_types.ArrIndNode); ok {
-						indexedElemAddrArg, elemType := ftac.getMemLocationPointingAt(vl)
-						ftac.emitInstr(&MemStoreInstr{
-							StoreAt:   indexedElemAddrArg,
-							StoreWhat: right,
-							NumBytes:  elemType.Size()},
-						)
-						return right // todo: decide semantics of a <binop> b = c
-					} else {
-						left := ftac.genExprTAC(v.Left)
-						ftac.emitInstr(&AssignInstr{assnTo: left, arg: right})
-						return left
+					lv := ftac.genLValue(v.Left)
+					right = ftac.convert(right, dataCategoryForType(lv.dt))
+					ftac.store(lv, right)
+					if lv.reg != nil {
+						return lv.reg
 					}
+					return right // todo: decide semantics of a <binop> b = c
+				}
+			case lexer.ANDAND, lexer.OROR, lexer.LESS, lexer.GREATER, lexer.LEQ, lexer.GEQ, lexer.EQ, lexer.NEQ:
+				{
+					// the outcome is worked out by branching
+					return ftac.genBoolValue(v)
 				}
 			default:
 				{
 					retArg := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(v.ResultDT)}
 
-					// todo: short circuiting for || and &&
-					left := ftac.genExprTAC(v.Left)
-					right := ftac.genExprTAC(v.Right)
+					left := ftac.convert(ftac.genExprTAC(v.Left), retArg.dc)
+					right := ftac.convert(ftac.genExprTAC(v.Right), retArg.dc)
 					ftac.emitInstr(&BinaryOpInstr{
 						assnTo: retArg,
 						op:     TACOperator(v.Op),
@@ -237,27 +313,62 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 			}
 
 		}
-	case *node_types.PrePostOperatorNode:
+	case *node_types.BooleanNode:
 		{
+			var num int64
+			if v.BoolVal {
+				num = 1
+			}
+			return &ImmIntArg{num, dataCategoryForType(staticanalyzer.BOOLEAN_DATATYPE)}
+		}
+	case *node_types.TernaryOperatorNode:
+		{
+			seq := ftac.nextLabelSeq()
+			elseLabel := ftac.label("tern_%d_else", seq)
+			endLabel := ftac.label("tern_%d_end", seq)
 			retArg := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(v.ResultDT)}
-			operand := ftac.genExprTAC(v.Operand)
+			ftac.genCondJump(v.Condition, elseLabel)
+			// an int arm of a float ternary is converted
+			ftac.emitInstr(&AssignInstr{assnTo: retArg, arg: ftac.convert(ftac.genExprTAC(v.IfTrue), retArg.dc)})
+			ftac.emitInstr(&JumpInstr{JmpToLabel: endLabel})
+			ftac.emitInstr(placeholderWithLabels(elseLabel))
+			ftac.emitInstr(&AssignInstr{assnTo: retArg, arg: ftac.convert(ftac.genExprTAC(v.IfFalse), retArg.dc)})
+			ftac.emitInstr(placeholderWithLabels(endLabel))
+			return retArg
+		}
+	case *node_types.PrePostOperatorNode:
+		{
 			switch v.Op {
-			case lexer.SUB:
-				{
-					ftac.emitInstr(&UnaryOpInstr{assnTo: retArg, op: v.Op, arg1: operand})
+			case lexer.INC, lexer.DEC:
+				return ftac.genIncDec(v)
+			case lexer.AMP:
+				if id, ok := v.Operand.(*node_types.IdentifierNode); ok {
+					if cell, ok := ftac.cells[id.Name()]; ok {
+						return cell
+					}
+					// a function, whose address is its label
+					return ftac.genExprTAC(id)
 				}
-			default:
-				panic("Not impl for prepost op" + v.Op)
+				return ftac.genLValue(v.Operand).addr
+			case lexer.MUL:
+				return ftac.load(ftac.genLValue(v))
 			}
+			retArg := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(v.ResultDT)}
+			operand := ftac.genExprTAC(v.Operand)
+			ftac.emitInstr(&UnaryOpInstr{assnTo: retArg, op: v.Op, arg1: operand})
 			return retArg
 		}
 	case *node_types.IdentifierNode:
 		{
+			if cell, ok := ftac.cells[v.Name()]; ok {
+				return ftac.load(lvalue{addr: cell, dt: v.DataT})
+			}
 			reg, ok := ftac.nameToReg[v.Name()]
 			dc := dataCategoryForType(v.DataT)
 			if !ok {
-				// treat this name as a label
-				retArg := &VRegArg{ftac.assignVirtualReg(v.Name()), dc}
+				// treat this name as a label, loaded again at every use
+				// since the first one may be on a path that isn't taken
+				retArg := &VRegArg{ftac.assignVirtualReg(""), dc}
 
 				ftac.emitInstr(&LoadLabelInstr{
 					loadeeLabel: v.Name(),
@@ -275,13 +386,19 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 
 			arrSizeArg := ftac.genExprTAC(v.SizeNode)
 			elemSizeBytes := v.DataT.Size()
-			reqBytesArg := &VRegArg{ftac.assignVirtualReg(""), I64}
-
-			ftac.emitInstr(&BinaryOpInstr{
-				assnTo: reqBytesArg,
-				op:     TACOperator(lexer.MUL),
-				arg1:   arrSizeArg,
-				arg2:   &ImmIntArg{int64(elemSizeBytes), I64}})
+			var reqBytesArg TACOpArg
+			if n, ok := arrSizeArg.(*ImmIntArg); ok {
+				// the backend only allocates sizes it knows up front,
+				// even when nothing is optimized
+				reqBytesArg = &ImmIntArg{n.Num() * int64(elemSizeBytes), I64}
+			} else {
+				reqBytesArg = &VRegArg{ftac.assignVirtualReg(""), I64}
+				ftac.emitInstr(&BinaryOpInstr{
+					assnTo: reqBytesArg.(*VRegArg),
+					op:     TACOperator(lexer.MUL),
+					arg1:   arrSizeArg,
+					arg2:   &ImmIntArg{int64(elemSizeBytes), I64}})
+			}
 
 			arrPtr := &VRegArg{ftac.assignVirtualReg(""), PTR}
 
@@ -295,17 +412,18 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 			memLocArg := &VRegArg{ftac.assignVirtualReg(""), PTR}
 			ftac.emitInstr(&AssignInstr{assnTo: memLocArg, arg: arrPtr})
 			for _, entry := range v.Elems {
-				ftac.emitInstr(&BinaryOpInstr{op: TACOperator(lexer.ADD),
-					assnTo: memLocArg,
-					arg1:   memLocArg,
-					arg2:   &ImmIntArg{int64(elemSizeBytes), I64},
-				})
 				storeVal := ftac.genExprTAC(entry)
 				ftac.emitInstr(&MemStoreInstr{
 					StoreAt:   memLocArg,
 					StoreWhat: storeVal,
 					NumBytes:  v.DataT.Size(),
 				})
+				// on to the next element
+				ftac.emitInstr(&BinaryOpInstr{op: TACOperator(lexer.ADD),
+					assnTo: memLocArg,
+					arg1:   memLocArg,
+					arg2:   &ImmIntArg{int64(elemSizeBytes), I64},
+				})
 			}
 			return arrPtr
 		}
@@ -323,15 +441,19 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 		}
 	case *node_types.FuncCallNode:
 		{
-			for _, arg := range v.Args {
-				areg := ftac.genExprTAC(arg)
-				ftac.emitInstr(&ParamInstr{arg: areg})
+			// the params come right before their call, with nothing
+			// computed in between, nested calls included
+			args := make([]TACOpArg, len(v.Args))
+			for i, arg := range v.Args {
+				args[i] = ftac.genExprTAC(arg)
 			}
-
-			retArg := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(v.CalleeT.ReturnType)}
-			callAddr := ftac.genExprTAC(v.Callee)
 			// if callee is a static label, have a separate instr for it instead of assigning
 			// label to vreg and then calling the vreg
+			callAddr := ftac.genExprTAC(v.Callee)
+			for _, arg := range args {
+				ftac.emitInstr(&ParamInstr{arg: arg})
+			}
+			retArg := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(v.CalleeT.ReturnType)}
 			ftac.emitInstr(&CallInstr{retReg: retArg, calleeAddr: callAddr})
 			return retArg
 		}
@@ -347,23 +469,98 @@ func (ftac *FunctionTAC) genExprTAC(n node_types.TreeNode) TACOpArg {
 	return &NULLOpArg{}
 }
 
-func genCondInstr(fromInstr ThreeAddressInstr, jmpToLabel string) CJumpInstr {
-	condInstr := CJumpInstr{}
-	switch vv := fromInstr.(type) {
-	case *BinaryOpInstr:
-		{
-			condInstr.Op = vv.op
-			condInstr.argL = vv.arg1
-			condInstr.argR = vv.arg2
-			condInstr.JmpToLabel = jmpToLabel
-		}
-	case *UnaryOpInstr:
-		{
-			// todo: not op
-			panic("! operator not impl in if-node tac gen")
+var comparisonOps = map[string]bool{
+	lexer.LESS:    true,
+	lexer.GREATER: true,
+	lexer.LEQ:     true,
+	lexer.GEQ:     true,
+	lexer.EQ:      true,
+	lexer.NEQ:     true,
+}
+
+// jumps to falseLabel unless cond holds. Comparisons become the jump
+// themselves, any other condition is compared against 0. The right
+// operand of && and || is skipped once the left one decides the outcome.
+func (ftac *FunctionTAC) genCondJump(cond node_types.TreeNode, falseLabel string) {
+	if v, ok := cond.(*node_types.PrePostOperatorNode); ok && v.Op == lexer.NOT {
+		val := ftac.genExprTAC(v.Operand)
+		ftac.emitInstr(&CJumpInstr{Op: TACOperator(lexer.EQ), argL: val, argR: &ImmIntArg{0, val.Category()}, JmpToLabel: falseLabel})
+		return
+	}
+	if v, ok := cond.(*node_types.InfixOperatorNode); ok {
+		switch {
+		case comparisonOps[v.Op]:
+			left, right := ftac.unifyNumbers(ftac.genExprTAC(v.Left), ftac.genExprTAC(v.Right))
+			ftac.emitInstr(&CJumpInstr{Op: TACOperator(v.Op), argL: left, argR: right, JmpToLabel: falseLabel})
+			return
+		case v.Op == lexer.ANDAND:
+			ftac.genCondJump(v.Left, falseLabel)
+			ftac.genCondJump(v.Right, falseLabel)
+			return
+		case v.Op == lexer.OROR:
+			seq := ftac.nextLabelSeq()
+			rightLabel := ftac.label("or_%d_right", seq)
+			trueLabel := ftac.label("or_%d_true", seq)
+			ftac.genCondJump(v.Left, rightLabel)
+			ftac.emitInstr(&JumpInstr{JmpToLabel: trueLabel})
+			ftac.emitInstr(placeholderWithLabels(rightLabel))
+			ftac.genCondJump(v.Right, falseLabel)
+			ftac.emitInstr(placeholderWithLabels(trueLabel))
+			return
 		}
 	}
-	return condInstr
+	val := ftac.genExprTAC(cond)
+	ftac.emitInstr(&CJumpInstr{Op: TACOperator(lexer.NEQ), argL: val, argR: &ImmIntArg{0, val.Category()}, JmpToLabel: falseLabel})
+}
+
+// arg as a number of category dc, converted if one of the two is floating
+// point and the other isn't, or they are floating point of other widths
+func (ftac *FunctionTAC) convert(arg TACOpArg, dc DataCategory) TACOpArg {
+	from := arg.Category()
+	if from.IsFloating() == dc.IsFloating() && (!dc.IsFloating() || from == dc) {
+		return arg
+	}
+	converted := &VRegArg{ftac.assignVirtualReg(""), dc}
+	ftac.emitInstr(&ConvertInstr{assnTo: converted, arg: arg})
+	return converted
+}
+
+// an integer compared with a floating point number is compared as one
+func (ftac *FunctionTAC) unifyNumbers(left, right TACOpArg) (TACOpArg, TACOpArg) {
+	if left.Category().IsFloating() {
+		return left, ftac.convert(right, left.Category())
+	}
+	if right.Category().IsFloating() {
+		return ftac.convert(left, right.Category()), right
+	}
+	return left, right
+}
+
+func (ftac *FunctionTAC) nextLabelSeq() int {
+	ftac.labelSeq++
+	return ftac.labelSeq
+}
+
+// labels are global in the assembly, so they begin with the function's
+// name, which can't clash with another since names have no digits
+func (ftac *FunctionTAC) label(format string, a ...any) string {
+	return ftac.fname + "_" + fmt.Sprintf(format, a...)
+}
+
+// a condition used as a value, 1 if it holds and 0 otherwise
+func (ftac *FunctionTAC) genBoolValue(cond node_types.TreeNode) TACOpArg {
+	seq := ftac.nextLabelSeq()
+	falseLabel := ftac.label("bool_%d_false", seq)
+	endLabel := ftac.label("bool_%d_end", seq)
+	dc := dataCategoryForType(staticanalyzer.BOOLEAN_DATATYPE)
+	retArg := &VRegArg{ftac.assignVirtualReg(""), dc}
+	ftac.genCondJump(cond, falseLabel)
+	ftac.emitInstr(&AssignInstr{assnTo: retArg, arg: &ImmIntArg{1, dc}})
+	ftac.emitInstr(&JumpInstr{JmpToLabel: endLabel})
+	ftac.emitInstr(placeholderWithLabels(falseLabel))
+	ftac.emitInstr(&AssignInstr{assnTo: retArg, arg: &ImmIntArg{0, dc}})
+	ftac.emitInstr(placeholderWithLabels(endLabel))
+	return retArg
 }
 
 func (ftac *FunctionTAC) getMemLocationPointingAt(v *node_types.ArrIndNode) (TACOpArg, node_types.DataType) {
@@ -63,10 +63,31 @@ func (u *UnaryOpInstr) String() string {
 	return LabInstrStr(u, fmt.Sprintf("%v = %s %v", u.assnTo, u.op, u.arg1))
 }
 
+func (u *UnaryOpInstr) Operator() string {
+	return u.op
+}
+
 func (u *UnaryOpInstr) ThreeAdresses() (*TACOpArg, *TACOpArg, *TACOpArg) {
 	return &u.assnTo, &u.arg1, &NOWHERE
 }
 
+// changes the representation of a number between integer and floating
+// point, or between floating point widths. Integers are truncated
+// towards zero.
+type ConvertInstr struct {
+	TACBaseInstr
+	assnTo TACOpArg
+	arg    TACOpArg
+}
+
+func (c *ConvertInstr) String() string {
+	return LabInstrStr(c, fmt.Sprintf("%v = %s %s %v", c.assnTo, utils.BoldCyan("conv"), c.assnTo.Category(), c.arg))
+}
+
+func (c *ConvertInstr) ThreeAdresses() (*TACOpArg, *TACOpArg, *TACOpArg) {
+	return &c.assnTo, &c.arg, &NOWHERE
+}
+
 // special kind of UnaryOpInstr
 type AssignInstr struct {
 	TACBaseInstr
@@ -150,6 +171,10 @@ func (j *LoadLabelInstr) String() string {
 	return LabInstrStr(j, fmt.Sprintf("%s %v [%v]", utils.BoldCyan("load"), j.to, utils.BoldGreen(j.loadeeLabel)))
 }
 
+func (l *LoadLabelInstr) Label() string {
+	return l.loadeeLabel
+}
+
 func (l *LoadLabelInstr) ThreeAdresses() (*TACOpArg, *TACOpArg, *TACOpArg) {
 	return &l.to, &NOWHERE, &NOWHERE
 }
@@ -261,30 +286,13 @@ func (f *FuncArgRecvInstr) String() string {
 	return LabInstrStr(f, fmt.Sprintf("%v = %s %d", f.recvInto, utils.BoldCyan("arg"), f.argNo))
 }
 
+func (f *FuncArgRecvInstr) ArgNo() int {
+	return f.argNo
+}
+
 func (f *FuncArgRecvInstr) ThreeAdresses() (*TACOpArg, *TACOpArg, *TACOpArg) {
 	return &f.recvInto, &NOWHERE, &NOWHERE
 }
 
 var LOOP_START_PREFIX = "loop_start_"
 var LOOP_END_PREFIX = "loop_end_"
-
-func hasLoopLabel(v ThreeAddressInstr) (bool, bool) {
-	labels := v.Labels()
-	hasStart := false
-	hasEnd := false
-
-	for _, label := range labels {
-		if strings.HasPrefix(label, LOOP_START_PREFIX) {
-			hasStart = true
-		}
-		if strings.HasPrefix(label, LOOP_END_PREFIX) {
-			hasEnd = true
-		}
-	}
-	// todo assert both aren't simultaneously true
-	if !hasStart && !hasEnd {
-		return false, false
-	}
-
-	return true, hasStart
-}
@@ -0,0 +1,462 @@
+package tac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"he++/lexer"
+	"math"
+	"slices"
+)
+
+// Runs the TAC of a program without going through the backend, as a
+// reference for what the program computes. Integers wrap around at the
+// width of their category like the machine's do, and divisions the
+// machine would trap on stop the program. Memory from allocs lives until
+// the call that made it returns; touching anything else stops the
+// program too.
+
+// interpreted functions are found at these addresses, below any memory
+const codeBase int64 = 0x1000
+
+const memBase int64 = 0x10000
+
+// a single alloc can't ask for more
+const maxAllocBytes = 1 << 30
+
+type Interpreter struct {
+	funcs  map[string]*FunctionTAC
+	byAddr map[int64]*FunctionTAC
+	addrOf map[string]int64
+	// instruction each label is on, per function
+	labels map[*FunctionTAC]map[string]int
+	// bytes from memBase on
+	mem []byte
+	// live allocations, in increasing address order
+	allocs []allocation
+	cur    *frame
+	depth  int
+	steps  int
+	// instructions executed before the program is stopped, no limit if 0
+	MaxSteps int
+	// calls that may be nested before the program is stopped
+	MaxDepth int
+}
+
+type allocation struct {
+	start, size int64
+}
+
+// integers and pointers are kept in i, floating point numbers in f.
+// Immediates have both set.
+type value struct {
+	i int64
+	f float64
+}
+
+type frame struct {
+	ftac *FunctionTAC
+	pc   int
+	regs map[VirtualRegisterNumber]value
+	args []value
+	// values of the params given so far to the next call
+	params []value
+}
+
+// what stopped a program
+type TrapKind string
+
+const (
+	// a division the machine would raise SIGFPE on
+	TRAP_ARITH TrapKind = "arithmetic"
+	// memory outside of any allocation, or calls nested too deep
+	TRAP_MEMORY TrapKind = "memory"
+	// Interpreter.MaxSteps ran out
+	TRAP_LIMIT TrapKind = "limit"
+	// TAC the interpreter can't make sense of
+	TRAP_INVALID TrapKind = "invalid"
+)
+
+// why the program was stopped, and where
+type RuntimeError struct {
+	Kind  TrapKind
+	Func  string
+	Instr int
+	Msg   string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error in %s at instruction %d: %s", e.Func, e.Instr, e.Msg)
+}
+
+func NewInterpreter(funcs []*FunctionTAC) *Interpreter {
+	in := &Interpreter{
+		funcs:    make(map[string]*FunctionTAC),
+		byAddr:   make(map[int64]*FunctionTAC),
+		addrOf:   make(map[string]int64),
+		MaxDepth: 1 << 16,
+	}
+	for i, ftac := range funcs {
+		addr := codeBase + 16*int64(i)
+		in.funcs[ftac.fname] = ftac
+		in.byAddr[addr] = ftac
+		in.addrOf[ftac.fname] = addr
+	}
+	return in
+}
+
+// calls fname with integer arguments and returns its result, truncated
+// if it is a floating point number
+func (in *Interpreter) Run(fname string, args ...int64) (ret int64, err error) {
+	ftac, ok := in.funcs[fname]
+	if !ok {
+		return 0, fmt.Errorf("no function %s to run", fname)
+	}
+	// the functions may have changed since the last run
+	in.labels = make(map[*FunctionTAC]map[string]int)
+	for _, f := range in.funcs {
+		in.labels[f] = make(map[string]int)
+		for i, ins := range f.instrs {
+			for _, label := range ins.Labels() {
+				in.labels[f][label] = i
+			}
+		}
+	}
+	in.mem, in.allocs, in.cur, in.depth, in.steps = nil, nil, nil, 0, 0
+
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = rerr
+		}
+	}()
+	vals := make([]value, len(args))
+	for i, a := range args {
+		vals[i] = value{i: a, f: float64(a)}
+	}
+	res := in.call(ftac, vals)
+	if ftac.retDc.IsFloating() {
+		return int64(res.f), nil
+	}
+	return res.i, nil
+}
+
+func (in *Interpreter) fail(kind TrapKind, format string, args ...any) {
+	panic(&RuntimeError{Kind: kind, Func: in.cur.ftac.fname, Instr: in.cur.pc, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (in *Interpreter) call(ftac *FunctionTAC, args []value) value {
+	caller := in.cur
+	in.depth++
+	if in.MaxDepth > 0 && in.depth > in.MaxDepth {
+		in.fail(TRAP_MEMORY, "calls nested deeper than %d", in.MaxDepth)
+	}
+	memMark, allocMark := len(in.mem), len(in.allocs)
+	defer func() {
+		in.cur = caller
+		in.depth--
+		in.mem, in.allocs = in.mem[:memMark], in.allocs[:allocMark]
+	}()
+	f := &frame{ftac: ftac, regs: make(map[VirtualRegisterNumber]value), args: args}
+	in.cur = f
+
+	// the instruction control came from, for phis
+	prev := -1
+	for f.pc < len(ftac.instrs) {
+		in.steps++
+		if in.MaxSteps > 0 && in.steps > in.MaxSteps {
+			in.fail(TRAP_LIMIT, "more than %d instructions executed", in.MaxSteps)
+		}
+		next := f.pc + 1
+		switch v := ftac.instrs[f.pc].(type) {
+		case *AssignInstr:
+			in.write(v.assnTo, in.read(v.arg))
+		case *BinaryOpInstr:
+			in.write(v.assnTo, in.binaryOp(v))
+		case *UnaryOpInstr:
+			x := in.read(v.arg1)
+			switch {
+			case v.op == lexer.SUB && v.assnTo.Category().IsFloating():
+				in.write(v.assnTo, value{f: -x.f})
+			case v.op == lexer.SUB:
+				in.write(v.assnTo, value{i: -x.i})
+			case v.op == lexer.NOT:
+				// booleans are 0 or 1
+				in.write(v.assnTo, value{i: x.i ^ 1})
+			default:
+				in.fail(TRAP_INVALID, "unsupported unary operator %s", v.op)
+			}
+		case *ConvertInstr:
+			in.write(v.assnTo, in.convert(in.read(v.arg), v.arg.Category(), v.assnTo.Category()))
+		case *JumpInstr:
+			next = in.labelOf(v.JmpToLabel)
+		case *CJumpInstr:
+			if !in.compare(v) {
+				next = in.labelOf(v.JmpToLabel)
+			}
+		case *ParamInstr:
+			f.params = append(f.params, in.read(v.arg))
+		case *CallInstr:
+			addr := in.read(v.calleeAddr).i
+			callee, ok := in.byAddr[addr]
+			if !ok {
+				in.fail(TRAP_INVALID, "call to %#x, which isn't a function", addr)
+			}
+			params := f.params
+			f.params = nil
+			in.write(v.retReg, in.call(callee, params))
+		case *LoadLabelInstr:
+			addr, ok := in.addrOf[v.loadeeLabel]
+			if !ok {
+				in.fail(TRAP_INVALID, "no function %s", v.loadeeLabel)
+			}
+			in.write(v.to, value{i: addr})
+		case *AllocInstr:
+			if v.AllocType != STACK_ALLOC {
+				in.fail(TRAP_INVALID, "only stack allocations are supported")
+			}
+			in.write(v.PtrToAlloc, value{i: in.alloc(in.read(v.SizeReg).i)})
+		case *MemLoadInstr:
+			in.write(v.StoreAt, in.load(in.read(v.LoadFrom).i, v.NumBytes, v.StoreAt.Category()))
+		case *MemStoreInstr:
+			in.store(in.read(v.StoreAt).i, v.NumBytes, in.read(v.StoreWhat), v.StoreWhat.Category())
+		case *FuncArgRecvInstr:
+			if v.argNo >= len(f.args) {
+				in.fail(TRAP_INVALID, "argument %d not given", v.argNo)
+			}
+			in.write(v.recvInto, f.args[v.argNo])
+		case *FuncRetInstr:
+			return in.read(v.retReg)
+		case *PhiInstr:
+			next = f.pc + in.runPhis(prev)
+		case *LoopBoundary, *LabelPlaceholder:
+		default:
+			in.fail(TRAP_INVALID, "can't interpret %T", v)
+		}
+		prev, f.pc = f.pc, next
+	}
+	// ran off the end without a devolver
+	return value{}
+}
+
+func (in *Interpreter) read(arg TACOpArg) value {
+	switch v := arg.(type) {
+	case *VRegArg:
+		return in.cur.regs[v.RegNo]
+	case *ImmIntArg:
+		return value{i: v.num, f: float64(v.num)}
+	case *ImmFloatArg:
+		return value{i: int64(v.num), f: v.num}
+	}
+	return value{}
+}
+
+func (in *Interpreter) write(arg TACOpArg, val value) {
+	if v, ok := arg.(*VRegArg); ok {
+		in.cur.regs[v.RegNo] = fit(val, v.dc)
+	}
+}
+
+// val as stored in a vreg of category dc
+func fit(val value, dc DataCategory) value {
+	switch dc {
+	case BYTE:
+		val.i = int64(int8(val.i))
+	case I16:
+		val.i = int64(int16(val.i))
+	case I32:
+		val.i = int64(int32(val.i))
+	case F32:
+		val.f = float64(float32(val.f))
+	}
+	return val
+}
+
+func (in *Interpreter) labelOf(label string) int {
+	i, ok := in.labels[in.cur.ftac][label]
+	if !ok {
+		in.fail(TRAP_INVALID, "jump to unknown label %s", label)
+	}
+	return i
+}
+
+func (in *Interpreter) binaryOp(v *BinaryOpInstr) value {
+	x, y := in.read(v.arg1), in.read(v.arg2)
+	dc := v.assnTo.Category()
+	if dc.IsFloating() || v.arg1.Category().IsFloating() {
+		switch string(v.op) {
+		case lexer.ADD:
+			return value{f: x.f + y.f}
+		case lexer.SUB:
+			return value{f: x.f - y.f}
+		case lexer.MUL:
+			return value{f: x.f * y.f}
+		case lexer.DIV:
+			return value{f: x.f / y.f}
+		}
+		in.fail(TRAP_INVALID, "unsupported floating point operator %s", v.op)
+	}
+	mask := shiftMask(dc)
+	switch string(v.op) {
+	case lexer.ADD:
+		return value{i: x.i + y.i}
+	case lexer.SUB:
+		return value{i: x.i - y.i}
+	case lexer.MUL:
+		return value{i: x.i * y.i}
+	case lexer.DIV, lexer.MODULO:
+		if y.i == 0 {
+			in.fail(TRAP_ARITH, "division by zero")
+		}
+		if lowest := -int64(1) << (8*dc.SizeBytes() - 1); y.i == -1 && fit(x, dc).i == lowest {
+			in.fail(TRAP_ARITH, "division overflow")
+		}
+		if string(v.op) == lexer.DIV {
+			return value{i: x.i / y.i}
+		}
+		return value{i: x.i % y.i}
+	case lexer.LSHIFT:
+		return value{i: x.i << (y.i & mask)}
+	case lexer.RSHIFT:
+		return value{i: x.i >> (y.i & mask)}
+	case lexer.AMP:
+		return value{i: x.i & y.i}
+	case lexer.PIPE:
+		return value{i: x.i | y.i}
+	case "^":
+		return value{i: x.i ^ y.i}
+	}
+	in.fail(TRAP_INVALID, "unsupported operator %s", v.op)
+	return value{}
+}
+
+// whether the condition of the jump holds
+func (in *Interpreter) compare(v *CJumpInstr) bool {
+	x, y := in.read(v.argL), in.read(v.argR)
+	if v.argL.Category().IsFloating() || v.argR.Category().IsFloating() {
+		return compareOp(string(v.Op), x.f, y.f)
+	}
+	return compareOp(string(v.Op), x.i, y.i)
+}
+
+func compareOp[T int64 | float64](op string, a, b T) bool {
+	switch op {
+	case lexer.LESS:
+		return a < b
+	case lexer.LEQ:
+		return a <= b
+	case lexer.GREATER:
+		return a > b
+	case lexer.GEQ:
+		return a >= b
+	case lexer.EQ:
+		return a == b
+	case lexer.NEQ:
+		return a != b
+	}
+	panic("unsupported comparison operator: " + op)
+}
+
+// floating point numbers become integers truncated towards zero; those
+// out of range, and NaN, become the lowest integer as with cvttsd2si
+func (in *Interpreter) convert(val value, from, to DataCategory) value {
+	if to.IsFloating() {
+		if from.IsFloating() {
+			return value{f: val.f}
+		}
+		return value{f: float64(val.i)}
+	}
+	if !from.IsFloating() {
+		return val
+	}
+	bits := 32
+	if to.SizeBytes() == 8 {
+		bits = 64
+	}
+	limit := math.Ldexp(1, bits-1)
+	if math.IsNaN(val.f) || val.f >= limit || val.f < -limit {
+		return value{i: -int64(1) << (bits - 1)}
+	}
+	return value{i: int64(val.f)}
+}
+
+// memory for the current call, zeroed and aligned to 8 bytes
+func (in *Interpreter) alloc(size int64) int64 {
+	if size < 0 || size > maxAllocBytes {
+		in.fail(TRAP_MEMORY, "can't allocate %d bytes", size)
+	}
+	offset := (int64(len(in.mem)) + 7) &^ 7
+	in.mem = append(in.mem, make([]byte, offset+size-int64(len(in.mem)))...)
+	in.allocs = append(in.allocs, allocation{start: memBase + offset, size: size})
+	return memBase + offset
+}
+
+// the n bytes at addr, which must all lie in one allocation
+func (in *Interpreter) access(addr int64, n int) []byte {
+	i, found := slices.BinarySearchFunc(in.allocs, addr, func(a allocation, addr int64) int {
+		switch {
+		case a.start+a.size <= addr:
+			return -1
+		case a.start > addr:
+			return 1
+		}
+		return 0
+	})
+	if !found || addr+int64(n) > in.allocs[i].start+in.allocs[i].size {
+		in.fail(TRAP_MEMORY, "%d bytes at %#x are outside of allocated memory", n, addr)
+	}
+	return in.mem[addr-memBase : addr-memBase+int64(n)]
+}
+
+func (in *Interpreter) load(addr int64, n int, dc DataCategory) value {
+	var buf [8]byte
+	copy(buf[:], in.access(addr, n))
+	bits := binary.LittleEndian.Uint64(buf[:])
+	if dc.IsFloating() {
+		if n == 4 {
+			return value{f: float64(math.Float32frombits(uint32(bits)))}
+		}
+		return value{f: math.Float64frombits(bits)}
+	}
+	// sign extended from the width loaded
+	shift := 64 - 8*n
+	return value{i: int64(bits<<shift) >> shift}
+}
+
+func (in *Interpreter) store(addr int64, n int, val value, dc DataCategory) {
+	bits := uint64(val.i)
+	if dc.IsFloating() {
+		bits = math.Float64bits(val.f)
+		if n == 4 {
+			bits = uint64(math.Float32bits(float32(val.f)))
+		}
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], bits)
+	copy(in.access(addr, n), buf[:n])
+}
+
+// runs the phis of the block control just entered, all reading their
+// args before any is written. Returns how many there were.
+func (in *Interpreter) runPhis(prev int) int {
+	ftac := in.cur.ftac
+	if ftac.ssa == nil || prev == -1 {
+		in.fail(TRAP_INVALID, "phi outside of a block entered from another")
+	}
+	from := ftac.ssa.BlockOf(prev)
+	phis := leadingPhis(ftac.ssa.BlockOf(in.cur.pc))
+	vals := make([]value, len(phis))
+	for i, phi := range phis {
+		j := slices.Index(phi.From, from)
+		if j == -1 {
+			in.fail(TRAP_INVALID, "%s isn't a predecessor of the phi", from)
+		}
+		vals[i] = in.read(phi.Args[j])
+	}
+	for i, phi := range phis {
+		in.write(phi.assnTo, vals[i])
+	}
+	return len(phis)
+}
@@ -0,0 +1,57 @@
+package tac_test
+
+import (
+	"he++/tac"
+	"strings"
+	"testing"
+)
+
+func TestInterpreter(t *testing.T) {
+	t.Run("Calls", func(t *testing.T) {
+		in := tac.NewInterpreter(readFixture(t, "fib.tac").Functions())
+		if ret, err := in.Run("principal"); err != nil || ret != 55+3 {
+			t.Errorf("expected 58, got %d, %v", ret, err)
+		}
+		if ret, err := in.Run("fib", 20); err != nil || ret != 6765 {
+			t.Errorf("expected fib(20) to be 6765, got %d, %v", ret, err)
+		}
+	})
+
+	t.Run("SSA form", func(t *testing.T) {
+		// phis pick the value of the edge taken
+		for fixture, want := range map[string]int64{"ssa.tac": 49, "nested_loops.tac": 8, "fib.tac": 58} {
+			handler := readFixture(t, fixture)
+			for _, ftac := range handler.Functions() {
+				ftac.ToSSA()
+			}
+			if ret, err := tac.NewInterpreter(handler.Functions()).Run("principal"); err != nil || ret != want {
+				t.Errorf("%s: expected %d in SSA form, got %d, %v", fixture, want, ret, err)
+			}
+		}
+	})
+
+	t.Run("Traps", func(t *testing.T) {
+		for body, msg := range map[string]string{
+			"R1:i32 = #0:i64\n\tR2:i32 = #7:i64 / R1:i32\n\tret R2:i32":                                        "division by zero",
+			"R1:ptr = alloc 0 s #8:i64\n\tR2:ptr = R1:ptr + #8:i64\n\tR3:i32 = load [R2:ptr], 4\n\tret R3:i32": "outside of allocated memory",
+		} {
+			src := "func principal() i32 {\n\t" + body + "\n}\n"
+			handler, err := tac.ParseText(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tac.NewInterpreter(handler.Functions()).Run("principal")
+			if rerr, ok := err.(*tac.RuntimeError); !ok || rerr.Func != "principal" || !strings.Contains(rerr.Msg, msg) {
+				t.Errorf("expected %q running\n%s\ngot %v", msg, src, err)
+			}
+		}
+	})
+
+	t.Run("Nesting", func(t *testing.T) {
+		in := tac.NewInterpreter(readFixture(t, "fib.tac").Functions())
+		in.MaxDepth = 100
+		if _, err := in.Run("fib", 200); err == nil {
+			t.Errorf("expected fib(200) to nest too deep")
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package tac
+
+import (
+	"fmt"
+	"he++/lexer"
+	"he++/parser/node_types"
+)
+
+// A location that assignments and ++/-- write to: either the register of a
+// variable or a memory address.
+type lvalue struct {
+	reg  *VRegArg
+	addr TACOpArg
+	dt   node_types.DataType
+}
+
+func (ftac *FunctionTAC) genLValue(n node_types.TreeNode) lvalue {
+	switch v := n.(type) {
+	case *node_types.IdentifierNode:
+		if cell, ok := ftac.cells[v.Name()]; ok {
+			return lvalue{addr: cell, dt: v.DataT}
+		}
+		return lvalue{reg: ftac.genExprTAC(v).(*VRegArg), dt: v.DataT}
+	case *node_types.ArrIndNode:
+		addr, dt := ftac.getMemLocationPointingAt(v)
+		return lvalue{addr: addr, dt: dt}
+	case *node_types.PrePostOperatorNode:
+		if v.OpType == node_types.PREFIX && v.Op == lexer.MUL {
+			return lvalue{addr: ftac.genExprTAC(v.Operand), dt: v.ResultDT}
+		}
+	}
+	panic(fmt.Sprintf("%T can't be assigned to", n))
+}
+
+func (ftac *FunctionTAC) load(lv lvalue) TACOpArg {
+	if lv.reg != nil {
+		return lv.reg
+	}
+	val := &VRegArg{ftac.assignVirtualReg(""), dataCategoryForType(lv.dt)}
+	ftac.emitInstr(&MemLoadInstr{LoadFrom: lv.addr, StoreAt: val, NumBytes: lv.dt.Size()})
+	return val
+}
+
+func (ftac *FunctionTAC) store(lv lvalue, val TACOpArg) {
+	if lv.reg != nil {
+		ftac.emitInstr(&AssignInstr{assnTo: lv.reg, arg: val})
+		return
+	}
+	ftac.emitInstr(&MemStoreInstr{StoreAt: lv.addr, StoreWhat: val, NumBytes: lv.dt.Size()})
+}
+
+// ++ and --, yielding the value from before the update for the postfix forms
+func (ftac *FunctionTAC) genIncDec(v *node_types.PrePostOperatorNode) TACOpArg {
+	lv := ftac.genLValue(v.Operand)
+	old := ftac.load(lv)
+	dc := dataCategoryForType(lv.dt)
+	if v.OpType == node_types.POSTFIX && lv.reg != nil {
+		// the register is about to be overwritten
+		copied := &VRegArg{ftac.assignVirtualReg(""), dc}
+		ftac.emitInstr(&AssignInstr{assnTo: copied, arg: old})
+		old = copied
+	}
+	op := lexer.ADD
+	if v.Op == lexer.DEC {
+		op = lexer.SUB
+	}
+	updated := &VRegArg{ftac.assignVirtualReg(""), dc}
+	ftac.emitInstr(&BinaryOpInstr{assnTo: updated, op: TACOperator(op), arg1: old, arg2: &ImmIntArg{1, dc}})
+	ftac.store(lv, updated)
+	if v.OpType == node_types.POSTFIX {
+		return old
+	}
+	return updated
+}
+
+// gives each variable whose address is taken a memory cell, so that
+// writes through a pointer to it and to the variable itself are seen by
+// both. The cell is put where the variable's register would have been.
+func (ftac *FunctionTAC) moveToCell(name string, dt node_types.DataType, val TACOpArg) {
+	cell := &VRegArg{ftac.assignVirtualReg(""), PTR}
+	ftac.emitInstr(&AllocInstr{
+		AllocType:  STACK_ALLOC,
+		SizeReg:    &ImmIntArg{int64(dt.Size()), I64},
+		PtrToAlloc: cell,
+		AllocNo:    ftac.allocCnt,
+	})
+	ftac.allocCnt++
+	ftac.cells[name] = cell
+	ftac.store(lvalue{addr: cell, dt: dt}, val)
+}
+
+// collects the names of the variables n takes the address of
+func addressTaken(n node_types.TreeNode, names map[string]bool) {
+	switch v := n.(type) {
+	case *node_types.PrePostOperatorNode:
+		if id, ok := v.Operand.(*node_types.IdentifierNode); ok && v.Op == lexer.AMP {
+			names[id.Name()] = true
+		}
+		addressTaken(v.Operand, names)
+	case *node_types.ScopeNode:
+		for _, ch := range v.Children {
+			addressTaken(ch, names)
+		}
+	case *node_types.VariableDeclarationNode:
+		for _, d := range v.Declarations {
+			addressTaken(d, names)
+		}
+	case *node_types.InfixOperatorNode:
+		addressTaken(v.Left, names)
+		addressTaken(v.Right, names)
+	case *node_types.TernaryOperatorNode:
+		addressTaken(v.Condition, names)
+		addressTaken(v.IfTrue, names)
+		addressTaken(v.IfFalse, names)
+	case *node_types.FuncCallNode:
+		addressTaken(v.Callee, names)
+		for _, arg := range v.Args {
+			addressTaken(arg, names)
+		}
+	case *node_types.ArrIndNode:
+		addressTaken(v.ArrProvider, names)
+		addressTaken(v.Indexer, names)
+	case *node_types.ArrayDeclarationNode:
+		addressTaken(v.SizeNode, names)
+		for _, elem := range v.Elems {
+			addressTaken(elem, names)
+		}
+	case *node_types.StructValueNode:
+		for _, val := range v.FieldValues {
+			addressTaken(val, names)
+		}
+	case *node_types.IfNode:
+		for _, branch := range v.Branches {
+			addressTaken(branch.Condition, names)
+			addressTaken(branch.Scope, names)
+		}
+	case *node_types.LoopNode:
+		addressTaken(v.Initializer, names)
+		addressTaken(v.Condition, names)
+		addressTaken(v.Updater, names)
+		addressTaken(v.Scope, names)
+	case *node_types.ReturnNode:
+		addressTaken(v.Value, names)
+	}
+}
@@ -35,6 +35,30 @@ func (dc DataCategory) IsFloating() bool {
 	return dc == F32 || dc == F64
 }
 
+func (dc DataCategory) String() string {
+	switch dc {
+	case I16:
+		return "i16"
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case PTR:
+		return "ptr"
+	case BYTE:
+		return "byte"
+	case AGGREGATE:
+		return "aggregate"
+	case VOID:
+		return "void"
+	}
+	return fmt.Sprintf("DataCategory(%d)", int16(dc))
+}
+
 func (dc DataCategory) SizeBytes() int {
 	switch dc {
 	case I16:
@@ -160,6 +184,8 @@ func dataCategoryForType(dt node_types.DataType) DataCategory {
 	}
 
 	switch dt.Size() {
+	case 0:
+		return VOID
 	case 1:
 		return BYTE
 	case 2:
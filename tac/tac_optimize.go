@@ -8,109 +8,75 @@ import (
 type TACContext struct {
 	regLifetimes  map[VirtualRegisterNumber]Life
 	loopLifetimes map[int]Life
-	loopWritelog  map[int]map[VirtualRegisterNumber]bool
 }
 
+// runs the passes of the default -O level and readies the function for
+// the backend
 func (ftac *FunctionTAC) Optimize() {
-	ctx := ftac.livenessAnalysis()
-	ftac.PropagateRegs(&ctx)
-	eliminatedRegs := ftac.Prune()
+	pm, _ := NewPassManager(OptLevels[DEFAULT_OPT_LEVEL])
+	pm.Run(ftac)
+}
 
-	ftac.eliminateNilInstrs()
+// merges the label placeholders into the instructions after them and
+// works out the lifetimes of the vregs, optimized or not
+func (ftac *FunctionTAC) PrepareForBackend() {
 	ftac.removeRedundantInstrs()
-	ctx = ftac.livenessAnalysis()
-	fmt.Println("Reglifetimes:", ctx.regLifetimes)
-	fmt.Println("looplifetimes:", ctx.loopLifetimes)
-	fmt.Println("Eliminated regs: ", eliminatedRegs)
-	ftac.ctx = ctx
+	ftac.ctx = ftac.livenessAnalysis()
 }
 
-// constant and copy propagation
-func (ftac *FunctionTAC) PropagateRegs(ctx *TACContext) {
-	loopStack := utils.MakeStack(-1)
-
-	propagMap := make(map[VirtualRegisterNumber]*TACOpArg) // size of var ka kya krna h
-
+// constant and copy propagation, over the SSA form. A vreg written by a
+// copy holds the same value wherever it is read, so every read can be
+// given what was copied. Folding may turn more instructions into copies,
+// and phis whose args agree are copies too.
+func (ftac *FunctionTAC) PropagateRegs() {
+	ftac.ToSSA()
+	copied := make(map[VirtualRegisterNumber]TACOpArg)
 	// for cases like r1 = #5, r2 = r1, r3 = r2 + #blabla
 	// we want r2 to be replaced by #5, not r1.
-	for i := 0; i < len(ftac.instrs); i++ {
-		switch v := ftac.instrs[i].(type) {
-		case *LoopBoundary:
-			{
-				if v.StartEnd {
-					loopStack.Push(v.loopNo)
-				} else {
-					loopStack.Pop()
-				}
+	resolve := func(arg *TACOpArg) {
+		for {
+			v, ok := (*arg).(*VRegArg)
+			if !ok {
+				return
 			}
-		default:
-			dest, arg1, arg2 := v.ThreeAdresses()
-			lno, _ := loopStack.Peek()
-			if canPropagateTo(arg1, *lno, ctx) {
-				fold(arg1, propagMap)
-			}
-			if canPropagateTo(arg2, *lno, ctx) {
-				fold(arg2, propagMap)
+			to, ok := copied[v.RegNo]
+			if !ok {
+				return
 			}
-
-			simpInstr := ftac.simplifyInstr(ftac.instrs[i])
-			if assn, ok := simpInstr.(*AssignInstr); ok {
-				assto := assn.assnTo.(*VRegArg)
-				propagMap[assto.RegNo] = &assn.arg
-
-			} else {
-				assto, ok := (*dest).(*VRegArg)
-				if ok {
-					delete(propagMap, assto.RegNo)
-					/**
-					if r1 = r2; r2 = 15; r3 = r1 + 3
-					then in 3rd instr we want r1 to be the value in r2 before 2nd instr
-					but since we've mapped r1 -> r2, we make 3rd instr: r3 = r2 + 1 == 17
-					*/
-					// currently it seems that the way we generate instructions avoids this failure mode
-
-					// todo: remove those entries in the map which map to assto.regNo too!
-				}
-
-			}
-			ftac.instrs[i] = simpInstr
+			*arg = to
 		}
 	}
-}
-
-func fold(arg *TACOpArg, propagMap map[VirtualRegisterNumber]*TACOpArg) {
-	if vregarg, ok := (*arg).(*VRegArg); ok {
-		if replace, ex := propagMap[vregarg.RegNo]; ex {
-			// assign replace to arg
-			*arg = *replace
-		}
-	}
-}
-
-func canPropagateTo(arg *TACOpArg, currLoop int, ctx *TACContext) bool {
-	loopLife, ex := ctx.loopLifetimes[currLoop]
-	if !ex {
-		return true
-	}
-	if varg, ok := (*arg).(*VRegArg); ok {
-		// idea: we should not fold those registers satisfying all of:
-		// lifetime extends beyond the loop
-		// written to inside the loop
-		// read from inside the loop.
-		regLife, ex := ctx.regLifetimes[varg.RegNo]
-		if !ex {
-			panic("lifetime info should have existed for arg " + varg.String())
-		}
-		if regLife.Start < loopLife.Start || regLife.End > loopLife.End {
-			if hasBeenWritten(varg.RegNo, currLoop, ctx.loopWritelog) {
-				return false
+	for changed := true; changed; {
+		changed = false
+		for _, b := range ftac.ssa.ReversePostorder() {
+			for i, ins := range b.Instrs {
+				dest, arg1, arg2 := ins.ThreeAdresses()
+				v, isDef := (*dest).(*VRegArg)
+				if isDef && copied[v.RegNo] != nil {
+					continue
+				}
+				if phi, ok := ins.(*PhiInstr); ok {
+					for j := range phi.Args {
+						resolve(&phi.Args[j])
+					}
+					if same := phi.soleArg(); same != nil {
+						copied[v.RegNo] = same
+						changed = true
+					}
+					continue
+				}
+				resolve(arg1)
+				resolve(arg2)
+				b.Instrs[i] = ftac.simplifyInstr(ins)
+				// a copy of itself only reads what it never wrote
+				if assn, ok := b.Instrs[i].(*AssignInstr); ok && !sameArg(assn.arg, v) {
+					copied[v.RegNo] = assn.arg
+					changed = true
+				}
 			}
 		}
-	} else {
-		return false // can only propagate to a vreg arg
 	}
-
-	return true
+	ftac.instrs = ftac.ssa.layOut()
 }
 
 // Dead code elimination
@@ -158,15 +124,15 @@ func (ftac *FunctionTAC) Prune() map[*VRegArg]bool {
 	// Debug: print depReg adjacency list
 	for reg, deps := range depReg {
 		if len(deps) > 0 {
-			print(reg, " : ")
+			fmt.Fprint(ftac.dbg, reg, " : ")
 			for k, _ := range deps {
-				fmt.Printf("%d, ", k)
+				fmt.Fprintf(ftac.dbg, "%d, ", k)
 			}
-			println()
+			fmt.Fprintln(ftac.dbg)
 		}
 	}
 
-	fmt.Println("Initial useful regs:", usefulRegs)
+	fmt.Fprintln(ftac.dbg, "Initial useful regs:", usefulRegs)
 
 	q := utils.MakeQueue[VirtualRegisterNumber]()
 	for a := range usefulRegs {
@@ -199,6 +165,10 @@ func (ftac *FunctionTAC) Prune() map[*VRegArg]bool {
 				dest, _, _ := instr.ThreeAdresses()
 				if v, ok := (*dest).(*VRegArg); ok && !usefulRegs[v.RegNo] {
 					ftac.instrs[i] = nil
+					if labels := instr.Labels(); len(labels) > 0 {
+						// jumps may still go there
+						ftac.instrs[i] = placeholderWithLabels(labels...)
+					}
 					eliminatedRegs[v] = true
 				}
 			}
@@ -235,7 +205,6 @@ func (ftac *FunctionTAC) livenessAnalysis() TACContext {
 
 	regLifetimes := make(map[VirtualRegisterNumber]Life)
 	loopLifetimes := make(map[int]Life)
-	loopWritelog := make(map[int]map[VirtualRegisterNumber]bool)
 	updateLiveness := func(a *TACOpArg, i int) {
 		arg, ok := (*a).(*VRegArg)
 		if !ok {
@@ -250,50 +219,42 @@ func (ftac *FunctionTAC) livenessAnalysis() TACContext {
 		}
 
 	}
-	registerWriteInLoop := func(reg VirtualRegisterNumber, lno int) {
-		if loopWritelog[lno] == nil {
-			loopWritelog[lno] = make(map[VirtualRegisterNumber]bool)
-		}
-		loopWritelog[lno][reg] = true
-	}
-	loopStack := utils.MakeStack(-1)
 	for i, instr := range utils.Backwards(ftac.instrs) {
 		dest, arg1, arg2 := instr.ThreeAdresses()
 		updateLiveness(dest, i)
 		updateLiveness(arg1, i)
 		updateLiveness(arg2, i)
-		if v, ok := (*dest).(*VRegArg); ok {
-			// register it as being written to in this loop
-			lno, _ := loopStack.Peek() // assert that lno is not zero value
-			if *lno != -1 {
-				registerWriteInLoop(v.RegNo, *lno)
-			}
-		}
 		if v, ok := instr.(*LoopBoundary); ok {
 			if k, ex := loopLifetimes[v.loopNo]; !ex {
 				loopLifetimes[v.loopNo] = Life{i, i}
-				loopStack.Push(v.loopNo)
-
 			} else {
 				k.Start = min(k.Start, i)
 				loopLifetimes[v.loopNo] = k
-				loopStack.Pop()
 			}
 		}
 	}
 
+	// a value still needed after a jump back to the start of a loop has
+	// to outlive the last read inside the loop
+	cfg := ftac.BuildCFG()
+	liveIn, liveOut := cfg.Liveness()
+	for _, b := range cfg.Blocks {
+		for reg := range liveIn[b] {
+			life := regLifetimes[reg]
+			life.Start = min(life.Start, b.Start)
+			regLifetimes[reg] = life
+		}
+		for reg := range liveOut[b] {
+			life := regLifetimes[reg]
+			life.End = max(life.End, b.End-1)
+			regLifetimes[reg] = life
+		}
+	}
+
 	return TACContext{
 		regLifetimes:  regLifetimes,
 		loopLifetimes: loopLifetimes,
-		loopWritelog:  loopWritelog,
-	}
-}
-
-func hasBeenWritten(reg VirtualRegisterNumber, lno int, wlog map[int]map[VirtualRegisterNumber]bool) bool {
-	if wlog[lno] == nil {
-		return false
 	}
-	return wlog[lno][reg]
 }
 
 func (ftac *FunctionTAC) eliminateNilInstrs() {
@@ -310,7 +271,7 @@ func (ftac *FunctionTAC) removeRedundantInstrs() {
 	pruned := make([]ThreeAddressInstr, 0)
 	for i, ins := range ftac.instrs {
 		if v, ok := ins.(*LabelPlaceholder); ok {
-			if i == len(ftac.instrs) {
+			if i == len(ftac.instrs)-1 {
 				pruned = append(pruned, ins)
 				continue
 			}
@@ -0,0 +1,155 @@
+package tac
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An optimization working on one function at a time. Passes over the SSA
+// form get the function in SSA form, the others get it out of it.
+type Pass struct {
+	Name string
+	// one line on what the pass does
+	Doc string
+	SSA bool
+	Run func(ftac *FunctionTAC)
+}
+
+// in the order they were registered
+var passes = make([]*Pass, 0)
+
+// makes the pass known by its name to --passes and the pass manager
+func RegisterPass(p *Pass) {
+	if LookupPass(p.Name) != nil {
+		panic(fmt.Sprintf("pass %s is registered twice", p.Name))
+	}
+	passes = append(passes, p)
+}
+
+// nil if there is no pass by that name
+func LookupPass(name string) *Pass {
+	for _, p := range passes {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func Passes() []*Pass {
+	return slices.Clone(passes)
+}
+
+func init() {
+	RegisterPass(&Pass{
+		Name: "propagate",
+		Doc:  "propagate constants and copies, folding what they make constant",
+		SSA:  true,
+		Run:  (*FunctionTAC).PropagateRegs,
+	})
+	RegisterPass(&Pass{
+		Name: "simplify",
+		Doc:  "fold operations on immediates and strength-reduce multiplications",
+		Run:  (*FunctionTAC).Simplify,
+	})
+	RegisterPass(&Pass{
+		Name: "prune",
+		Doc:  "remove instructions whose values are never used",
+		Run: func(ftac *FunctionTAC) {
+			ftac.Prune()
+			ftac.eliminateNilInstrs()
+		},
+	})
+}
+
+// the passes run by -O0, -O1 and -O2
+var OptLevels = [][]string{
+	{},
+	{"simplify", "prune"},
+	{"propagate", "prune"},
+}
+
+// the -O level the compiler runs at unless told otherwise
+const DEFAULT_OPT_LEVEL = 2
+
+// runs passes over functions, then readies them for the backend
+type PassManager struct {
+	passes []*Pass
+	// the function is written out in the textual format to Log after each
+	// of these passes
+	PrintAfter []string
+	Log        io.Writer
+	// time spent in every pass, over all functions
+	timings map[string]time.Duration
+}
+
+// a manager running the named passes in order
+func NewPassManager(names []string) (*PassManager, error) {
+	pm := &PassManager{Log: io.Discard, timings: make(map[string]time.Duration)}
+	for _, name := range names {
+		p := LookupPass(name)
+		if p == nil {
+			return nil, fmt.Errorf("unknown pass %q, expected one of %s", name, PassList())
+		}
+		pm.passes = append(pm.passes, p)
+	}
+	return pm, nil
+}
+
+// the names of the passes run, in order
+func (pm *PassManager) Passes() []string {
+	names := make([]string, len(pm.passes))
+	for i, p := range pm.passes {
+		names[i] = p.Name
+	}
+	return names
+}
+
+func (pm *PassManager) Run(ftac *FunctionTAC) {
+	for _, p := range pm.passes {
+		start := time.Now()
+		if p.SSA {
+			ftac.ToSSA()
+		} else {
+			ftac.FromSSA()
+		}
+		p.Run(ftac)
+		pm.timings[p.Name] += time.Since(start)
+		if slices.Contains(pm.PrintAfter, p.Name) {
+			fmt.Fprintf(pm.Log, "// after %s\n", p.Name)
+			ftac.WriteText(pm.Log)
+		}
+	}
+	ftac.FromSSA()
+	ftac.PrepareForBackend()
+}
+
+// a line per pass with the time spent in it, then the total
+func (pm *PassManager) WriteTimings(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	var total time.Duration
+	seen := make(map[string]bool)
+	for _, p := range pm.passes {
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		total += pm.timings[p.Name]
+		fmt.Fprintf(tw, "%s\t%v\t\n", p.Name, pm.timings[p.Name])
+	}
+	fmt.Fprintf(tw, "total\t%v\t\n", total)
+	tw.Flush()
+}
+
+// the names of the registered passes, for messages
+func PassList() string {
+	names := make([]string, len(passes))
+	for i, p := range passes {
+		names[i] = p.Name
+	}
+	return strings.Join(names, "|")
+}
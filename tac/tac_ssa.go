@@ -0,0 +1,329 @@
+package tac
+
+import (
+	"fmt"
+	"he++/utils"
+	"slices"
+	"strings"
+)
+
+// In SSA form every vreg is written by a single instruction. Where the
+// values of a variable written on different paths meet, a phi at the
+// start of the block picks the one of the path control came from. While
+// a function is in SSA form its CFG is kept around, and the instruction
+// list is its blocks laid out one after another.
+
+// the arg coming from the predecessor control arrived from
+type PhiInstr struct {
+	TACBaseInstr
+	assnTo TACOpArg
+	Args   []TACOpArg
+	// the predecessor each arg comes from
+	From []*BasicBlock
+	// vreg the phi was placed for, before renaming
+	of VirtualRegisterNumber
+}
+
+func (p *PhiInstr) String() string {
+	args := make([]string, len(p.Args))
+	for i := range p.Args {
+		args[i] = fmt.Sprintf("%v %s", p.Args[i], p.From[i])
+	}
+	return LabInstrStr(p, fmt.Sprintf("%v = %s(%s)", p.assnTo, utils.BoldCyan("phi"), strings.Join(args, ", ")))
+}
+
+// the args aren't among the three addresses, passes working on the SSA
+// form look at them separately
+func (p *PhiInstr) ThreeAdresses() (*TACOpArg, *TACOpArg, *TACOpArg) {
+	return &p.assnTo, &NOWHERE, &NOWHERE
+}
+
+func (ftac *FunctionTAC) InSSA() bool {
+	return ftac.ssa != nil
+}
+
+// the CFG of a function in SSA form, nil otherwise
+func (ftac *FunctionTAC) SSACFG() *CFG {
+	return ftac.ssa
+}
+
+// places phis where the definitions of a vreg meet and the vreg is still
+// live, then gives every definition a vreg of its own. Reads that no
+// definition reaches keep the original vreg.
+func (ftac *FunctionTAC) ToSSA() {
+	if ftac.ssa != nil {
+		return
+	}
+	cfg := ftac.BuildCFG()
+	for _, b := range cfg.Blocks {
+		b.Instrs = slices.Clone(b.Instrs)
+	}
+	liveIn, _ := cfg.Liveness()
+	ftac.placePhis(cfg, liveIn)
+	ftac.rename(cfg)
+	ftac.ssa = cfg
+	ftac.instrs = cfg.layOut()
+}
+
+func (ftac *FunctionTAC) placePhis(cfg *CFG, liveIn map[*BasicBlock]VRegSet) {
+	defBlocks := make(map[VirtualRegisterNumber][]*BasicBlock)
+	cats := make(map[VirtualRegisterNumber]DataCategory)
+	for _, b := range cfg.ReversePostorder() {
+		for _, ins := range b.Instrs {
+			dest, _, _ := ins.ThreeAdresses()
+			if v, ok := (*dest).(*VRegArg); ok && !slices.Contains(defBlocks[v.RegNo], b) {
+				defBlocks[v.RegNo] = append(defBlocks[v.RegNo], b)
+				cats[v.RegNo] = v.dc
+			}
+		}
+	}
+	vregs := make([]VirtualRegisterNumber, 0, len(defBlocks))
+	for v := range defBlocks {
+		vregs = append(vregs, v)
+	}
+	slices.Sort(vregs)
+
+	df := cfg.DominanceFrontiers()
+	for _, v := range vregs {
+		added := make(map[*BasicBlock]bool)
+		hasPhi := make(map[*BasicBlock]bool)
+		work := slices.Clone(defBlocks[v])
+		for _, b := range work {
+			added[b] = true
+		}
+		for len(work) > 0 {
+			x := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, y := range df[x] {
+				if hasPhi[y] || !liveIn[y][v] {
+					continue
+				}
+				hasPhi[y] = true
+				phi := &PhiInstr{
+					assnTo: &VRegArg{v, cats[v]},
+					Args:   make([]TACOpArg, len(y.Preds)),
+					From:   slices.Clone(y.Preds),
+					of:     v,
+				}
+				for i := range phi.Args {
+					phi.Args[i] = &VRegArg{v, cats[v]}
+				}
+				// the labels stay on the first instruction of the block
+				phi.setLabels(y.Instrs[0].Labels())
+				y.Instrs[0].setLabels(nil)
+				y.Instrs = append([]ThreeAddressInstr{phi}, y.Instrs...)
+				if !added[y] {
+					added[y] = true
+					work = append(work, y)
+				}
+			}
+		}
+	}
+}
+
+// walks the dominator tree with a stack of the current definitions of
+// every original vreg
+func (ftac *FunctionTAC) rename(cfg *CFG) {
+	stacks := make(map[VirtualRegisterNumber][]VirtualRegisterNumber)
+	current := func(arg TACOpArg) TACOpArg {
+		v, ok := arg.(*VRegArg)
+		if !ok || len(stacks[v.RegNo]) == 0 {
+			return arg
+		}
+		return &VRegArg{stacks[v.RegNo][len(stacks[v.RegNo])-1], v.dc}
+	}
+	var walk func(b *BasicBlock)
+	walk = func(b *BasicBlock) {
+		pushed := make([]VirtualRegisterNumber, 0)
+		for _, ins := range b.Instrs {
+			dest, arg1, arg2 := ins.ThreeAdresses()
+			if _, isPhi := ins.(*PhiInstr); !isPhi {
+				*arg1 = current(*arg1)
+				*arg2 = current(*arg2)
+			}
+			if v, ok := (*dest).(*VRegArg); ok {
+				reg := ftac.assignVirtualReg("")
+				stacks[v.RegNo] = append(stacks[v.RegNo], reg)
+				pushed = append(pushed, v.RegNo)
+				*dest = &VRegArg{reg, v.dc}
+			}
+		}
+		for _, s := range b.Succs {
+			for _, phi := range leadingPhis(s) {
+				for i, from := range phi.From {
+					if from == b {
+						phi.Args[i] = current(phi.Args[i])
+					}
+				}
+			}
+		}
+		for _, c := range b.DomChildren {
+			walk(c)
+		}
+		for _, v := range pushed {
+			stacks[v] = stacks[v][:len(stacks[v])-1]
+		}
+	}
+	walk(cfg.Entry())
+}
+
+func leadingPhis(b *BasicBlock) []*PhiInstr {
+	phis := make([]*PhiInstr, 0)
+	for _, ins := range b.Instrs {
+		phi, ok := ins.(*PhiInstr)
+		if !ok {
+			break
+		}
+		phis = append(phis, phi)
+	}
+	return phis
+}
+
+// the blocks one after the other, with Start and End updated
+func (cfg *CFG) layOut() []ThreeAddressInstr {
+	instrs := make([]ThreeAddressInstr, 0)
+	cfg.blockOf = cfg.blockOf[:0]
+	for _, b := range cfg.Blocks {
+		b.Start = len(instrs)
+		instrs = append(instrs, b.Instrs...)
+		b.End = len(instrs)
+		for range b.Instrs {
+			cfg.blockOf = append(cfg.blockOf, b)
+		}
+	}
+	return instrs
+}
+
+// turns the phis into copies at the end of the predecessors. An edge
+// from a block with several successors to one with several predecessors
+// gets a block of its own for the copies, so that they don't happen on
+// the other paths.
+func (ftac *FunctionTAC) FromSSA() {
+	cfg := ftac.ssa
+	if cfg == nil {
+		return
+	}
+	// blocks made for edges go after the function's code
+	edgeBlocks := make([]ThreeAddressInstr, 0)
+	for _, b := range cfg.Blocks {
+		phis := leadingPhis(b)
+		if len(phis) == 0 {
+			continue
+		}
+		b.Instrs = b.Instrs[len(phis):]
+		b.Instrs[0].setLabels(phis[0].Labels())
+		for i, p := range phis[0].From {
+			copies := make([]*AssignInstr, 0)
+			for _, phi := range phis {
+				if !sameArg(phi.assnTo, phi.Args[i]) {
+					copies = append(copies, &AssignInstr{assnTo: phi.assnTo, arg: phi.Args[i]})
+				}
+			}
+			if len(copies) == 0 {
+				continue
+			}
+			last := p.Instrs[len(p.Instrs)-1]
+			switch v := last.(type) {
+			case *JumpInstr:
+				seq := ftac.sequentialize(copies)
+				seq[0].setLabels(last.Labels())
+				last.setLabels(nil)
+				p.Instrs = slices.Insert(p.Instrs, len(p.Instrs)-1, seq...)
+			case *CJumpInstr:
+				if p.Id+1 < len(cfg.Blocks) && cfg.Blocks[p.Id+1] == b {
+					// only run when the jump isn't taken
+					p.Instrs = append(p.Instrs, ftac.sequentialize(copies)...)
+				}
+				if slices.Contains(b.Instrs[0].Labels(), v.JmpToLabel) {
+					label := fmt.Sprintf("%s_edge_%d_%d", ftac.fname, p.Id, b.Id)
+					seq := ftac.sequentialize(copies)
+					seq[0].setLabels([]string{label})
+					edgeBlocks = append(edgeBlocks, seq...)
+					edgeBlocks = append(edgeBlocks, &JumpInstr{JmpToLabel: v.JmpToLabel})
+					v.JmpToLabel = label
+				}
+			default:
+				p.Instrs = append(p.Instrs, ftac.sequentialize(copies)...)
+			}
+		}
+	}
+	instrs := cfg.layOut()
+	if len(edgeBlocks) > 0 {
+		switch instrs[len(instrs)-1].(type) {
+		case *JumpInstr, *FuncRetInstr:
+			instrs = append(instrs, edgeBlocks...)
+		default:
+			// the function would run into them otherwise
+			end := ftac.fname + "_edges_end"
+			instrs = append(instrs, &JumpInstr{JmpToLabel: end})
+			instrs = append(instrs, edgeBlocks...)
+			instrs = append(instrs, placeholderWithLabels(end))
+		}
+	}
+	ftac.instrs = instrs
+	ftac.ssa = nil
+}
+
+// the phis of a block all read their args at once. Copies are ordered
+// so that none overwrites a vreg another one still has to read, going
+// through a temporary where they read each other in a cycle.
+func (ftac *FunctionTAC) sequentialize(copies []*AssignInstr) []ThreeAddressInstr {
+	pending := make([]*AssignInstr, len(copies))
+	for i, c := range copies {
+		pending[i] = &AssignInstr{assnTo: c.assnTo, arg: c.arg}
+	}
+	seq := make([]ThreeAddressInstr, 0, len(pending))
+	for len(pending) > 0 {
+		free := slices.IndexFunc(pending, func(c *AssignInstr) bool {
+			return !slices.ContainsFunc(pending, func(o *AssignInstr) bool {
+				return o != c && sameArg(o.arg, c.assnTo)
+			})
+		})
+		if free != -1 {
+			seq = append(seq, pending[free])
+			pending = slices.Delete(pending, free, free+1)
+			continue
+		}
+		// every destination is still to be read, save one of them
+		saved := pending[0].assnTo
+		tmp := &VRegArg{ftac.assignVirtualReg(""), saved.Category()}
+		seq = append(seq, &AssignInstr{assnTo: tmp, arg: saved})
+		for _, c := range pending {
+			if sameArg(c.arg, saved) {
+				c.arg = tmp
+			}
+		}
+	}
+	return seq
+}
+
+// the value all args agree on, leaving out the phi itself, nil if they
+// don't
+func (p *PhiInstr) soleArg() TACOpArg {
+	var sole TACOpArg
+	for _, arg := range p.Args {
+		if sameArg(arg, p.assnTo) {
+			continue
+		}
+		if sole != nil && !sameArg(arg, sole) {
+			return nil
+		}
+		sole = arg
+	}
+	return sole
+}
+
+func sameArg(a, b TACOpArg) bool {
+	switch x := a.(type) {
+	case *VRegArg:
+		y, ok := b.(*VRegArg)
+		return ok && x.RegNo == y.RegNo
+	case *ImmIntArg:
+		y, ok := b.(*ImmIntArg)
+		return ok && x.num == y.num
+	case *ImmFloatArg:
+		y, ok := b.(*ImmFloatArg)
+		return ok && x.num == y.num && x.dc == y.dc
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package tac_test
+
+import (
+	"fmt"
+	"he++/tac"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSSA(t *testing.T) {
+	t.Run("Propagation", func(t *testing.T) {
+		// x keeps the value a had before it was written
+		f := readFixture(t, "ssa.tac").TacBlocks["f"]
+		f.Optimize()
+		var sb strings.Builder
+		f.WriteText(&sb)
+		arg, _, _ := f.Instrs()[0].ThreeAdresses()
+		sum := regexp.MustCompile(fmt.Sprintf(`R\d+:i32 = R%d:i32 \+ #15:`, (*arg).(*tac.VRegArg).RegNo))
+		if !sum.MatchString(sb.String()) {
+			t.Errorf("expected the argument added to 15, got\n%s", sb.String())
+		}
+	})
+
+	t.Run("Phis", func(t *testing.T) {
+		g := readFixture(t, "ssa.tac").TacBlocks["g"]
+		g.ToSSA()
+		var sb strings.Builder
+		g.WriteText(&sb)
+		if !g.InSSA() || !strings.Contains(sb.String(), "phi") {
+			t.Fatalf("expected phis for t and i, got\n%s", sb.String())
+		}
+		defined := make(map[tac.VirtualRegisterNumber]bool)
+		for _, ins := range g.Instrs() {
+			dest, _, _ := ins.ThreeAdresses()
+			if v, ok := (*dest).(*tac.VRegArg); ok {
+				if defined[v.RegNo] {
+					t.Errorf("R%d written twice in\n%s", v.RegNo, sb.String())
+				}
+				defined[v.RegNo] = true
+			}
+		}
+
+		g.FromSSA()
+		sb.Reset()
+		g.WriteText(&sb)
+		if g.InSSA() || strings.Contains(sb.String(), "phi") {
+			t.Errorf("expected the phis turned into copies, got\n%s", sb.String())
+		}
+		g.BuildCFG()
+	})
+
+	t.Run("Out of SSA runs the same", func(t *testing.T) {
+		handler := readFixture(t, "ssa.tac")
+		for _, ftac := range handler.Functions() {
+			ftac.ToSSA()
+			ftac.FromSSA()
+		}
+		if got, err := tac.NewInterpreter(handler.Functions()).Run("principal"); err != nil || got != 49 {
+			t.Errorf("expected 49, got %d, %v", got, err)
+		}
+	})
+}
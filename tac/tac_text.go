@@ -0,0 +1,364 @@
+package tac
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The TAC as plain text that ParseText reads back, for tests to start
+// from hand written TAC instead of he++ source. Functions follow one
+// another, labels stand on lines of their own before the instruction
+// they are on, and operands carry their category:
+//
+//	func half(i32) i32 {
+//		R1:i32 = arg 0
+//		jmp_if_false R1:i32 > #0:i64, half_neg
+//		R2:i32 = R1:i32 >> #1:i64
+//		ret R2:i32
+//	half_neg:
+//		ret #0:i32
+//	}
+//
+// Vregs are R<n>, immediates #<n> with floating point ones always having
+// a '.' or an exponent, and _ is no operand. The other instructions are
+//
+//	D = A, D = op A, D = conv A, D = arg n, D = addr label
+//	D = call A, or call A when the value returned isn't kept
+//	D = alloc n s|h A, D = load [A], bytes, store [A], B, bytes
+//	param A, ret A, jmp label, loop_start n, loop_end n
+//	nop, a placeholder holding labels
+//
+// Phis are written as D = phi A B0, ... but can't be read back, functions
+// are read outside of SSA form. Everything after // on a line is a
+// comment.
+
+// the functions in the textual format
+func WriteText(w io.Writer, funcs []*FunctionTAC) {
+	for i, ftac := range funcs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		ftac.WriteText(w)
+	}
+}
+
+func (ftac *FunctionTAC) WriteText(w io.Writer) {
+	args := make([]string, len(ftac.argDcs))
+	for i, dc := range ftac.argDcs {
+		args[i] = dc.String()
+	}
+	fmt.Fprintf(w, "func %s(%s) %s {\n", ftac.fname, strings.Join(args, ", "), ftac.retDc)
+	for _, ins := range ftac.instrs {
+		for _, label := range ins.Labels() {
+			fmt.Fprintf(w, "%s:\n", label)
+		}
+		fmt.Fprintf(w, "\t%s\n", instrText(ins))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func instrText(ins ThreeAddressInstr) string {
+	switch v := ins.(type) {
+	case *BinaryOpInstr:
+		return fmt.Sprintf("%s = %s %s %s", argText(v.assnTo), argText(v.arg1), v.op, argText(v.arg2))
+	case *UnaryOpInstr:
+		return fmt.Sprintf("%s = %s %s", argText(v.assnTo), v.op, argText(v.arg1))
+	case *ConvertInstr:
+		return fmt.Sprintf("%s = conv %s", argText(v.assnTo), argText(v.arg))
+	case *AssignInstr:
+		return fmt.Sprintf("%s = %s", argText(v.assnTo), argText(v.arg))
+	case *JumpInstr:
+		return fmt.Sprintf("jmp %s", v.JmpToLabel)
+	case *CJumpInstr:
+		return fmt.Sprintf("jmp_if_false %s %s %s, %s", argText(v.argL), v.Op, argText(v.argR), v.JmpToLabel)
+	case *ParamInstr:
+		return fmt.Sprintf("param %s", argText(v.arg))
+	case *CallInstr:
+		if v.retReg.LocType() == Null {
+			return fmt.Sprintf("call %s", argText(v.calleeAddr))
+		}
+		return fmt.Sprintf("%s = call %s", argText(v.retReg), argText(v.calleeAddr))
+	case *LoadLabelInstr:
+		return fmt.Sprintf("%s = addr %s", argText(v.to), v.loadeeLabel)
+	case *LabelPlaceholder:
+		return "nop"
+	case *LoopBoundary:
+		if v.StartEnd {
+			return fmt.Sprintf("loop_start %d", v.loopNo)
+		}
+		return fmt.Sprintf("loop_end %d", v.loopNo)
+	case *AllocInstr:
+		return fmt.Sprintf("%s = alloc %d %c %s", argText(v.PtrToAlloc), v.AllocNo, v.AllocType, argText(v.SizeReg))
+	case *MemStoreInstr:
+		return fmt.Sprintf("store [%s], %s, %d", argText(v.StoreAt), argText(v.StoreWhat), v.NumBytes)
+	case *MemLoadInstr:
+		return fmt.Sprintf("%s = load [%s], %d", argText(v.StoreAt), argText(v.LoadFrom), v.NumBytes)
+	case *FuncRetInstr:
+		return fmt.Sprintf("ret %s", argText(v.retReg))
+	case *FuncArgRecvInstr:
+		return fmt.Sprintf("%s = arg %d", argText(v.recvInto), v.argNo)
+	case *PhiInstr:
+		args := make([]string, len(v.Args))
+		for i := range v.Args {
+			args[i] = fmt.Sprintf("%s %s", argText(v.Args[i]), v.From[i])
+		}
+		return fmt.Sprintf("%s = phi %s", argText(v.assnTo), strings.Join(args, ", "))
+	}
+	panic(fmt.Sprintf("no textual form for %T", ins))
+}
+
+func argText(arg TACOpArg) string {
+	switch v := arg.(type) {
+	case *VRegArg:
+		return fmt.Sprintf("R%d:%s", v.RegNo, v.dc)
+	case *ImmIntArg:
+		return fmt.Sprintf("#%d:%s", v.num, v.dc)
+	case *ImmFloatArg:
+		num := strconv.FormatFloat(v.num, 'g', -1, 64)
+		if !strings.ContainsAny(num, ".eIN") {
+			// read back as an integer otherwise
+			num += ".0"
+		}
+		return fmt.Sprintf("#%s:%s", num, v.dc)
+	}
+	return "_"
+}
+
+// a line of TAC text that can't be read
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type textParser struct {
+	line int
+	ftac *FunctionTAC
+	// labels waiting for the instruction they are on
+	labels []string
+}
+
+// the functions written by WriteText, as they were before being written.
+// They are neither optimized nor prepared for the backend, which is left
+// to the caller like the rest of the pipeline.
+func ParseText(src string) (handler *TACHandler, err error) {
+	p := &textParser{}
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			handler, err = nil, perr
+		}
+	}()
+	handler = &TACHandler{TacBlocks: make(map[string]*FunctionTAC)}
+	for i, line := range strings.Split(src, "\n") {
+		p.line = i + 1
+		if c := strings.Index(line, "//"); c != -1 {
+			line = line[:c]
+		}
+		fields := strings.Fields(strings.NewReplacer(",", " ", "[", " ", "]", " ").Replace(line))
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case p.ftac == nil:
+			ftac := p.header(strings.TrimSpace(line))
+			if _, ok := handler.TacBlocks[ftac.fname]; ok {
+				p.fail("function %s is defined twice", ftac.fname)
+			}
+			handler.TacBlocks[ftac.fname] = ftac
+			handler.order = append(handler.order, ftac.fname)
+			p.ftac = ftac
+		case len(fields) == 1 && fields[0] == "}":
+			p.end()
+		case len(fields) == 1 && strings.HasSuffix(fields[0], ":"):
+			p.labels = append(p.labels, strings.TrimSuffix(fields[0], ":"))
+		default:
+			ins := p.instr(fields)
+			ins.setLabels(p.labels)
+			p.labels = nil
+			p.ftac.emitInstr(ins)
+		}
+	}
+	if p.ftac != nil {
+		p.fail("function %s isn't closed", p.ftac.fname)
+	}
+	return handler, nil
+}
+
+func (p *textParser) fail(format string, args ...any) {
+	panic(&ParseError{Line: p.line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// func name(dc, ...) dc {
+func (p *textParser) header(line string) *FunctionTAC {
+	rest, ok := strings.CutPrefix(line, "func ")
+	open, close := strings.Index(rest, "("), strings.Index(rest, ")")
+	if !ok || open <= 0 || close < open || !strings.HasSuffix(rest, "{") {
+		p.fail("expected a function, got %q", line)
+	}
+	ftac := newFunctionTAC(strings.TrimSpace(rest[:open]), p.category(strings.TrimSpace(rest[close+1:len(rest)-1])), nil)
+	for _, arg := range strings.Split(rest[open+1:close], ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			ftac.argDcs = append(ftac.argDcs, p.category(arg))
+		}
+	}
+	return ftac
+}
+
+// labels at the end of the function are kept on a placeholder, jumps must
+// go to labels the function has
+func (p *textParser) end() {
+	ftac := p.ftac
+	if len(p.labels) > 0 {
+		ftac.emitInstr(placeholderWithLabels(p.labels...))
+		p.labels = nil
+	}
+	labels := make(map[string]bool)
+	for _, ins := range ftac.instrs {
+		for _, label := range ins.Labels() {
+			if labels[label] {
+				p.fail("label %s is defined twice in %s", label, ftac.fname)
+			}
+			labels[label] = true
+		}
+	}
+	for _, ins := range ftac.instrs {
+		to := ""
+		switch v := ins.(type) {
+		case *JumpInstr:
+			to = v.JmpToLabel
+		case *CJumpInstr:
+			to = v.JmpToLabel
+		}
+		if to != "" && !labels[to] {
+			p.fail("jump to unknown label %s in %s", to, ftac.fname)
+		}
+	}
+	p.ftac = nil
+}
+
+func (p *textParser) instr(f []string) ThreeAddressInstr {
+	switch {
+	case f[0] == "jmp" && len(f) == 2:
+		return &JumpInstr{JmpToLabel: f[1]}
+	case f[0] == "jmp_if_false" && len(f) == 5:
+		return &CJumpInstr{argL: p.arg(f[1]), Op: TACOperator(f[2]), argR: p.arg(f[3]), JmpToLabel: f[4]}
+	case f[0] == "param" && len(f) == 2:
+		return &ParamInstr{arg: p.arg(f[1])}
+	case f[0] == "call" && len(f) == 2:
+		return &CallInstr{calleeAddr: p.arg(f[1]), retReg: NOWHERE}
+	case f[0] == "ret" && len(f) == 2:
+		return &FuncRetInstr{retReg: p.arg(f[1])}
+	case f[0] == "store" && len(f) == 4:
+		return &MemStoreInstr{StoreAt: p.arg(f[1]), StoreWhat: p.arg(f[2]), NumBytes: p.number(f[3])}
+	case f[0] == "loop_start" && len(f) == 2:
+		return &LoopBoundary{loopNo: p.number(f[1]), StartEnd: true}
+	case f[0] == "loop_end" && len(f) == 2:
+		return &LoopBoundary{loopNo: p.number(f[1])}
+	case f[0] == "nop" && len(f) == 1:
+		return &LabelPlaceholder{}
+	case len(f) >= 3 && f[1] == "=":
+		return p.assignment(f[0], f[2:])
+	}
+	p.fail("can't read instruction %q", strings.Join(f, " "))
+	return nil
+}
+
+// the instructions writing to dest
+func (p *textParser) assignment(dest string, f []string) ThreeAddressInstr {
+	to := p.arg(dest)
+	if _, ok := to.(*VRegArg); !ok {
+		p.fail("can only assign to vregs, not %s", dest)
+	}
+	switch {
+	case len(f) == 1:
+		return &AssignInstr{assnTo: to, arg: p.arg(f[0])}
+	case f[0] == "conv" && len(f) == 2:
+		return &ConvertInstr{assnTo: to, arg: p.arg(f[1])}
+	case f[0] == "arg" && len(f) == 2:
+		return &FuncArgRecvInstr{argNo: p.number(f[1]), recvInto: to}
+	case f[0] == "call" && len(f) == 2:
+		return &CallInstr{calleeAddr: p.arg(f[1]), retReg: to}
+	case f[0] == "addr" && len(f) == 2:
+		return &LoadLabelInstr{loadeeLabel: f[1], to: to}
+	case f[0] == "load" && len(f) == 3:
+		return &MemLoadInstr{LoadFrom: p.arg(f[1]), StoreAt: to, NumBytes: p.number(f[2])}
+	case f[0] == "alloc" && len(f) == 4:
+		allocType := AllocType(f[2][0])
+		if len(f[2]) != 1 || allocType != STACK_ALLOC && allocType != HEAP_ALLOC {
+			p.fail("allocations are s or h, not %s", f[2])
+		}
+		alloc := &AllocInstr{AllocNo: p.number(f[1]), AllocType: allocType, SizeReg: p.arg(f[3]), PtrToAlloc: to}
+		p.ftac.allocCnt = max(p.ftac.allocCnt, alloc.AllocNo+1)
+		return alloc
+	case f[0] == "phi":
+		p.fail("phis can't be read, functions are read outside of SSA form")
+	case len(f) == 2:
+		return &UnaryOpInstr{assnTo: to, op: f[0], arg1: p.arg(f[1])}
+	case len(f) == 3:
+		return &BinaryOpInstr{assnTo: to, arg1: p.arg(f[0]), op: TACOperator(f[1]), arg2: p.arg(f[2])}
+	}
+	p.fail("can't read instruction %q", dest+" = "+strings.Join(f, " "))
+	return nil
+}
+
+func (p *textParser) arg(s string) TACOpArg {
+	if s == "_" {
+		return NOWHERE
+	}
+	colon := strings.LastIndex(s, ":")
+	if colon == -1 || len(s) < 2 {
+		p.fail("expected an operand with its category, got %q", s)
+	}
+	num, dc := s[1:colon], p.category(s[colon+1:])
+	switch s[0] {
+	case 'R':
+		reg, err := strconv.ParseInt(num, 10, 64)
+		if err != nil || reg <= 0 {
+			p.fail("bad vreg %q", s)
+		}
+		p.ftac.regCnt = max(p.ftac.regCnt, reg)
+		return &VRegArg{reg, dc}
+	case '#':
+		if strings.ContainsAny(num, ".eIN") {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				p.fail("bad immediate %q", s)
+			}
+			return &ImmFloatArg{f, dc}
+		}
+		i, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			p.fail("bad immediate %q", s)
+		}
+		return &ImmIntArg{i, dc}
+	}
+	p.fail("operands are vregs, immediates or _, not %q", s)
+	return nil
+}
+
+func (p *textParser) number(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		p.fail("expected a number, got %q", s)
+	}
+	return n
+}
+
+func (p *textParser) category(s string) DataCategory {
+	for dc := I16; dc <= VOID; dc++ {
+		if dc.String() == s {
+			return dc
+		}
+	}
+	p.fail("unknown category %q", s)
+	return VOID
+}
@@ -0,0 +1,198 @@
+package tac_test
+
+import (
+	"fmt"
+	"he++/asm_gen"
+	"he++/compiler"
+	"he++/diagnostics"
+	"he++/difftest"
+	"he++/tac"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		paths, _ := filepath.Glob("../samples/programs/*.lg")
+		if len(paths) == 0 {
+			t.Fatal("no sample programs")
+		}
+		for _, path := range paths {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, unoptimized := range []bool{true, false} {
+				res, diags := compiler.Compile(string(src), compiler.Options{StopAfter: compiler.TAC, Unoptimized: unoptimized})
+				if diagnostics.HasErrors(diags) {
+					t.Fatalf("%s: unexpected diagnostics %v", path, diags)
+				}
+				var written strings.Builder
+				res.DumpTAC(&written)
+				handler, err := tac.ParseText(written.String())
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", path, err, written.String())
+				}
+				var rewritten strings.Builder
+				tac.WriteText(&rewritten, handler.Functions())
+				if rewritten.String() != written.String() {
+					t.Errorf("%s: read back differently:\n%s\nwas\n%s", path, rewritten.String(), written.String())
+				}
+				want, wantErr := tac.NewInterpreter(res.Functions).Run("principal")
+				got, err := tac.NewInterpreter(handler.Functions()).Run("principal")
+				if got != want || (err == nil) != (wantErr == nil) {
+					t.Errorf("%s: read back returns %d, %v instead of %d, %v", path, got, err, want, wantErr)
+				}
+			}
+		}
+	})
+
+	t.Run("Fixtures", func(t *testing.T) {
+		paths, _ := filepath.Glob("testdata/*.tac")
+		if len(paths) == 0 {
+			t.Fatal("no fixtures")
+		}
+		returns := regexp.MustCompile(`^// returns (\d+)\n`)
+		for _, path := range paths {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := returns.FindSubmatch(src)
+			if m == nil {
+				t.Fatalf("%s: doesn't say what it returns", path)
+			}
+			want, _ := strconv.ParseInt(string(m[1]), 10, 64)
+			parse := func() *tac.TACHandler {
+				handler, err := tac.ParseText(string(src))
+				if err != nil {
+					t.Fatalf("%s: %v", path, err)
+				}
+				return handler
+			}
+
+			handler := parse()
+			var written strings.Builder
+			tac.WriteText(&written, handler.Functions())
+			if fixture := strings.TrimPrefix(string(src), string(m[0])); written.String() != fixture {
+				t.Errorf("%s: written as\n%s", path, written.String())
+			}
+			if got, err := tac.NewInterpreter(handler.Functions()).Run("principal"); err != nil || got != want {
+				t.Errorf("%s: expected %d, got %d, %v", path, want, got, err)
+			}
+
+			for _, optimize := range []bool{false, true} {
+				handler := parse()
+				for _, ftac := range handler.Functions() {
+					if optimize {
+						ftac.Optimize()
+					} else {
+						ftac.PrepareForBackend()
+					}
+				}
+				if got, err := tac.NewInterpreter(handler.Functions()).Run("principal"); err != nil || got != want {
+					t.Errorf("%s optimized: %v: expected %d, got %d, %v", path, optimize, want, got, err)
+				}
+				if !difftest.HasToolchain() {
+					continue
+				}
+				for i, regAlloc := range asm_gen.RegAllocators {
+					ag := asm_gen.NewAsmGen(handler)
+					ag.RegAlloc = regAlloc
+					ag.GenerateAsm()
+					var asm strings.Builder
+					ag.WriteAsmFile(&asm)
+					dir := t.TempDir()
+					bin := filepath.Join(dir, fmt.Sprint("prog_", i))
+					if err := asm_gen.BuildExecutable(asm.String(), dir, bin); err != nil {
+						t.Fatalf("%s: %v", path, err)
+					}
+					err := exec.Command(bin).Run()
+					status := 0
+					if exitErr, ok := err.(*exec.ExitError); ok {
+						status = exitErr.ExitCode()
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					if int64(status) != want {
+						t.Errorf("%s optimized: %v: built with %s, expected %d, got %d", path, optimize, regAlloc, want, status)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Optimizing fixtures", func(t *testing.T) {
+		src, err := os.ReadFile("testdata/fold.tac")
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := tac.ParseText(string(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ftac := handler.TacBlocks["principal"]
+		ftac.Optimize()
+		var sb strings.Builder
+		ftac.WriteText(&sb)
+		if want := "func principal() i32 {\n\tret #42:i64\n}\n"; sb.String() != want {
+			t.Errorf("expected everything folded into the return, got\n%s", sb.String())
+		}
+	})
+
+	t.Run("Lowering what the backend can't", func(t *testing.T) {
+		handler, err := tac.ParseText("func principal() i32 {\n\tR1:ptr = alloc 0 h #8:i64\n\tret #0:i32\n}\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "can't lower") {
+				t.Errorf("expected the heap allocation refused, got %v", r)
+			}
+		}()
+		for _, ftac := range handler.Functions() {
+			ftac.PrepareForBackend()
+		}
+		ag := asm_gen.NewAsmGen(handler)
+		ag.GenerateAsm()
+	})
+
+	t.Run("Floating point immediates", func(t *testing.T) {
+		src := "func principal() f64 {\n\tR1:f64 = #1.0:f64\n\tR2:f64 = R1:f64 + #-0.0:f64\n\tR3:f32 = #1e+300:f64\n\tret R2:f64\n}\n"
+		handler, err := tac.ParseText(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		tac.WriteText(&sb, handler.Functions())
+		if sb.String() != src {
+			t.Errorf("expected the floats written as read, got\n%s", sb.String())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for src, msg := range map[string]string{
+			"R1:i32 = #1:i32":                                "line 1: expected a function",
+			"func f() i32 {\n\tR1:i32 = #1:i32\n":            "line 3: function f isn't closed",
+			"func f() i32 {\n\tjmp nowhere\n}":               "line 3: jump to unknown label nowhere in f",
+			"func f() i32 {\na:\n\tnop\na:\n\tnop\n}":        "line 6: label a is defined twice in f",
+			"func f() i32 {\n\tret #1:i33\n}":                "line 2: unknown category \"i33\"",
+			"func f() i32 {\n\tR1 = #1:i32\n}":               "line 2: expected an operand with its category",
+			"func f() i32 {\n\t#1:i32 = R1:i32\n}":           "line 2: can only assign to vregs",
+			"func f() i32 {\n\tR1:i32 = phi R2:i32 B0\n}":    "line 2: phis can't be read",
+			"func f() i32 {\n\tfrobnicate R1:i32\n}":         "line 2: can't read instruction",
+			"func f() i32 {\n}\nfunc f() i32 {\n}":           "line 3: function f is defined twice",
+			"func f() i32 {\n\tR1:ptr = alloc 0 x #4:i64\n}": "line 2: allocations are s or h",
+		} {
+			_, err := tac.ParseText(src)
+			if _, ok := err.(*tac.ParseError); !ok || !strings.Contains(err.Error(), msg) {
+				t.Errorf("expected %q reading %q, got %v", msg, src, err)
+			}
+		}
+	})
+}
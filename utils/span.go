@@ -0,0 +1,36 @@
+package utils
+
+import "fmt"
+
+// A range of source text. Lines and columns start at 1, columns count
+// bytes. Start and End are byte offsets into the file, End exclusive.
+type Span struct {
+	File    string
+	Line    int
+	Col     int
+	EndLine int
+	EndCol  int
+	Start   int
+	End     int
+}
+
+// the span covering s up to the end of other
+func (s Span) To(other Span) Span {
+	if !other.IsValid() {
+		return s
+	}
+	if !s.IsValid() {
+		return other
+	}
+	s.EndLine, s.EndCol, s.End = other.EndLine, other.EndCol, other.End
+	return s
+}
+
+// the zero span stands for "no location", e.g. for synthesized nodes
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Col)
+}